@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+)
+
+func bagCommand(args []string) (int, error) {
+	if len(args) == 2 && args[0] == "validate" {
+		return validateBagCommand(args[1])
+	}
+
+	if len(args) != 2 {
+		printUsageAndExit("bag requires exactly 2 args")
+	}
+
+	partition, err := partition_lib.LoadPartition(args[0])
+
+	if err != nil {
+		return 1, err
+	}
+
+	if err := partition.CreateBag(context.Background(), args[1]); err != nil {
+		return 1, err
+	}
+
+	return 0, nil
+}
+
+func validateBagCommand(bagDir string) (int, error) {
+	mismatches := partition_lib.ValidateBag(context.Background(), bagDir)
+	mismatchesCount := 0
+
+	for m := range mismatches.Channel {
+		fmt.Println(sprintManifestMismatch(bagDir, m))
+		mismatchesCount++
+	}
+
+	if mismatches.Err != nil {
+		return 1, mismatches.Err
+	}
+
+	if mismatchesCount > 0 {
+		return 1, nil
+	}
+
+	return 0, nil
+}
@@ -1,42 +1,304 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"flag"
 	"fmt"
+	"math/rand/v2"
 	"os"
 	"runtime"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/azerum/data-storage-suite/pkg/archivefs"
 	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+	"github.com/azerum/data-storage-suite/pkg/registry"
+	"github.com/azerum/data-storage-suite/pkg/s3fs"
 	"github.com/azerum/data-storage-suite/pkg/utils"
 )
 
-func checkCommand(partitionDirs []string) (int, error) {
+func checkCommand(args []string) (int, error) {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	all := flags.Bool("all", false, "")
+	recursive := flags.Bool("recursive", false, "")
+	sample := flags.String("sample", "", "")
+	seed := flags.Uint64("seed", rand.Uint64(), "")
+	stopAtBadChunk := flags.Bool("stop-at-bad-chunk", false, "")
+	mtree := flags.String("mtree", "", "")
+
+	partitionDirs := parseFlags(flags, args)
+
+	if *all && *recursive {
+		printUsageAndExit("check --all and --recursive are mutually exclusive")
+	}
+
+	conflictsCount := 0
+
+	if *recursive {
+		dirs, count, err := discoverPartitions(partitionDirs)
+
+		if err != nil {
+			return 1, err
+		}
+
+		partitionDirs = dirs
+		conflictsCount = count
+	}
+
+	if *all {
+		if len(partitionDirs) != 0 {
+			printUsageAndExit("check --all does not accept partition dirs")
+		}
+
+		dirs, err := mountedRegisteredPartitionDirs()
+
+		if err != nil {
+			return 1, err
+		}
+
+		partitionDirs = dirs
+	}
+
+	check := fullCheck(partition_lib.CheckOptions{
+		StopAtFirstBadChunk: *stopAtBadChunk,
+	})
+
+	if *sample != "" {
+		options, err := parseSampleOptions(*sample)
+
+		if err != nil {
+			printUsageAndExit(err.Error())
+		}
+
+		options.Seed = *seed
+		check = sampleCheck(options)
+	}
+
+	if *mtree != "" {
+		if *sample != "" {
+			printUsageAndExit("check --mtree and --sample are mutually exclusive")
+		}
+
+		spec, err := os.ReadFile(*mtree)
+
+		if err != nil {
+			return 1, err
+		}
+
+		check = mtreeCheck(spec)
+	}
+
+	mismatchCounts, err := checkPartitions(partitionDirs, check)
+
+	if err != nil {
+		return 1, err
+	}
+
+	// Sample check is not a replacement for the full check, and mtree
+	// check does not use the manifest, so they are not recorded
+	if *sample == "" && *mtree == "" {
+		if err := recordCheckResults(partitionDirs, mismatchCounts); err != nil {
+			return 1, err
+		}
+	}
+
+	if conflictsCount > 0 {
+		return 1, nil
+	}
+
+	for _, count := range mismatchCounts {
+		if count > 0 {
+			return 1, nil
+		}
+	}
+
+	return 0, nil
+}
+
+// Returns dirs of registered partitions. Partitions that are not
+// mounted are skipped with a message to stderr
+func mountedRegisteredPartitionDirs() ([]string, error) {
+	r, err := registry.LoadDefault()
+
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := make([]string, 0, len(r.Partitions))
+
+	for _, e := range r.Partitions {
+		if !e.IsMounted() {
+			fmt.Fprintf(os.Stderr, "skipped %s: %s is not mounted\n", e.Label, e.Path)
+			continue
+		}
+
+		dirs = append(dirs, e.Path)
+	}
+
+	return dirs, nil
+}
+
+// Parses --sample value: either percentage of files (5%) or number of files (1000)
+func parseSampleOptions(s string) (partition_lib.SampleOptions, error) {
+	if percent, isPercent := strings.CutSuffix(s, "%"); isPercent {
+		value, err := strconv.ParseFloat(percent, 64)
+
+		if err != nil || value <= 0 || value > 100 {
+			return partition_lib.SampleOptions{}, fmt.Errorf("invalid --sample %s", s)
+		}
+
+		return partition_lib.SampleOptions{Fraction: value / 100}, nil
+	}
+
+	count, err := strconv.Atoi(s)
+
+	if err != nil || count <= 0 {
+		return partition_lib.SampleOptions{}, fmt.Errorf("invalid --sample %s", s)
+	}
+
+	return partition_lib.SampleOptions{Count: count}, nil
+}
+
+type checkFn func(
+	partitionDir string,
+	partition *partition_lib.Partition,
+) *utils.ChanWithError[partition_lib.ManifestMismatch]
+
+func fullCheck(options partition_lib.CheckOptions) checkFn {
+	return func(
+		_partitionDir string,
+		partition *partition_lib.Partition,
+	) *utils.ChanWithError[partition_lib.ManifestMismatch] {
+		return partition.CheckWithOptions(context.Background(), options)
+	}
+}
+
+// Checks partitions against the mtree spec instead of their manifests
+func mtreeCheck(spec []byte) checkFn {
+	return func(
+		_partitionDir string,
+		partition *partition_lib.Partition,
+	) *utils.ChanWithError[partition_lib.ManifestMismatch] {
+		return partition.CheckMtree(context.Background(), bytes.NewReader(spec))
+	}
+}
+
+const sampleConfidence = 0.95
+
+// Once sample check of a partition completes, prints its stats to stderr
+func sampleCheck(options partition_lib.SampleOptions) checkFn {
+	return func(
+		partitionDir string,
+		partition *partition_lib.Partition,
+	) *utils.ChanWithError[partition_lib.ManifestMismatch] {
+		out := utils.NewChanWithError[partition_lib.ManifestMismatch](1)
+		mismatches, stats := partition.CheckSample(context.Background(), options)
+
+		go func() {
+			hadHashMismatch := false
+
+			for m := range mismatches.Channel {
+				if _, ok := m.(partition_lib.HashDoesNotMatch); ok {
+					hadHashMismatch = true
+				}
+
+				out.Channel <- m
+			}
+
+			if mismatches.Err != nil {
+				out.CloseWithError(mismatches.Err)
+				return
+			}
+
+			fmt.Fprintf(
+				os.Stderr,
+				"sampled %s: %d of %d files (%d of %d bytes), seed=%d\n",
+				partitionDir,
+				stats.SampledFiles,
+				stats.TotalFiles,
+				stats.SampledBytes,
+				stats.TotalBytes,
+				options.Seed,
+			)
+
+			if !hadHashMismatch {
+				fmt.Fprintf(
+					os.Stderr,
+					"sampled %s: with %.0f%% confidence at most %d of %d files are corrupted\n",
+					partitionDir,
+					sampleConfidence*100,
+					stats.MaxCorruptedFiles(sampleConfidence),
+					stats.TotalFiles,
+				)
+			}
+
+			out.CloseOk()
+		}()
+
+		return out
+	}
+}
+
+// Prints mismatches of all partitions to stdout. Returns number of
+// mismatches in each partition
+func checkPartitions(partitionDirs []string, check checkFn) (map[string]int, error) {
 	concurrency := runtime.NumCPU()
 
 	input := fanOutPartitionDirs(partitionDirs, concurrency)
 
-	lines := utils.MapConcurrently(
+	mismatches := utils.MapConcurrently(
 		input,
-		checkPartitionAndGetStdoutLines,
+		func(partitionDir string) *utils.ChanWithError[partitionMismatch] {
+			return checkPartition(partitionDir, check)
+		},
 		concurrency,
 	)
 
-	hadAtLeastOneMismatch := false
+	mismatchCounts := make(map[string]int)
 
-	for l := range lines.Channel {
-		fmt.Println(l)
-		hadAtLeastOneMismatch = true
+	for m := range mismatches.Channel {
+		fmt.Println(sprintManifestMismatch(m.partitionDir, m.mismatch))
+		mismatchCounts[m.partitionDir]++
 	}
 
-	if lines.Err != nil {
-		return 1, lines.Err
+	if mismatches.Err != nil {
+		return nil, mismatches.Err
 	}
 
-	if hadAtLeastOneMismatch {
-		return 1, nil
+	return mismatchCounts, nil
+}
+
+// Updates last check time & result of the checked partitions which
+// are registered
+func recordCheckResults(partitionDirs []string, mismatchCounts map[string]int) error {
+	r, err := registry.LoadDefault()
+
+	if err != nil {
+		return err
 	}
 
-	return 0, nil
+	now := time.Now().Unix()
+	changed := false
+
+	for _, dir := range partitionDirs {
+		entry := r.FindByPath(dir)
+
+		if entry == nil {
+			continue
+		}
+
+		entry.LastCheckTime = now
+		entry.LastCheckMismatches = mismatchCounts[dir]
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+
+	return r.Save()
 }
 
 func fanOutPartitionDirs(partitionDirs []string, bufferSize int) <-chan string {
@@ -53,46 +315,89 @@ func fanOutPartitionDirs(partitionDirs []string, bufferSize int) <-chan string {
 	return out
 }
 
-func checkPartitionAndGetStdoutLines(
+type partitionMismatch struct {
+	partitionDir string
+	mismatch     partition_lib.ManifestMismatch
+}
+
+func checkPartition(
 	partitionDir string,
-) *utils.ChanWithError[string] {
-	lines := utils.NewChanWithError[string](1)
+	check checkFn,
+) *utils.ChanWithError[partitionMismatch] {
+	out := utils.NewChanWithError[partitionMismatch](1)
 
 	go func() {
+		if s3fs.IsURL(partitionDir) {
+			partition, err := loadPartitionAt(partitionDir)
+
+			if err != nil {
+				out.CloseWithError(err)
+				return
+			}
+
+			forwardMismatches(partitionDir, check(partitionDir, partition), out)
+			return
+		}
+
 		info, err := os.Stat(partitionDir)
 
 		if err != nil {
-			lines.CloseWithError(err)
+			out.CloseWithError(err)
 			return
 		}
 
-		if !info.IsDir() {
-			lines.CloseOk()
+		var partition *partition_lib.Partition
+
+		switch {
+		case info.IsDir():
+			partition, err = partition_lib.LoadPartition(partitionDir)
+
+		// Partition packed with `part pack`
+		case archivefs.IsArchive(partitionDir):
+			fsys, closer, openErr := archivefs.Open(partitionDir)
+
+			if openErr != nil {
+				out.CloseWithError(openErr)
+				return
+			}
+
+			defer closer.Close()
+			partition, err = partition_lib.LoadPartitionFS(fsys, partitionDir)
+
+		default:
+			fmt.Fprintf(os.Stderr, "skipped %s: not a directory or archive\n", partitionDir)
+			out.CloseOk()
 			return
 		}
 
-		partition, err := partition_lib.LoadPartition(partitionDir)
-
 		if err != nil {
-			lines.CloseWithError(err)
+			out.CloseWithError(err)
 			return
 		}
 
-		mismatches := partition.Check(context.Background())
+		forwardMismatches(partitionDir, check(partitionDir, partition), out)
+	}()
 
-		for m := range mismatches.Channel {
-			l := sprintManifestMismatch(partitionDir, m)
-			lines.Channel <- l
-		}
+	return out
+}
 
-		if mismatches.Err != nil {
-			lines.CloseWithError(mismatches.Err)
-		} else {
-			lines.CloseOk()
+func forwardMismatches(
+	partitionDir string,
+	mismatches *utils.ChanWithError[partition_lib.ManifestMismatch],
+	out *utils.ChanWithError[partitionMismatch],
+) {
+	for m := range mismatches.Channel {
+		out.Channel <- partitionMismatch{
+			partitionDir: partitionDir,
+			mismatch:     m,
 		}
-	}()
+	}
 
-	return lines
+	if mismatches.Err != nil {
+		out.CloseWithError(mismatches.Err)
+	} else {
+		out.CloseOk()
+	}
 }
 
 func sprintManifestMismatch(partitionDir string, mismatch partition_lib.ManifestMismatch) string {
@@ -103,10 +408,42 @@ func sprintManifestMismatch(partitionDir string, mismatch partition_lib.Manifest
 	case partition_lib.FileMissing:
 		return fmt.Sprintf("?- %s %s", partitionDir, c.ManifestPath)
 
+	case partition_lib.SizeDoesNotMatch:
+		return fmt.Sprintf("?# %s %s actual=%d expected=%d", partitionDir, c.ManifestPath, c.ActualSize, c.ExpectedSize)
+
 	case partition_lib.HashDoesNotMatch:
-		return fmt.Sprintf("?* %s %s actual=%s expected=%s", partitionDir, c.ManifestPath, c.ActualHash, c.ExpectedHash)
+		line := fmt.Sprintf("?* %s %s actual=%s expected=%s", partitionDir, c.ManifestPath, c.ActualHash, c.ExpectedHash)
+
+		if c.CorruptedRanges != nil {
+			line += " corrupted=" + sprintByteRanges(c.CorruptedRanges)
+		}
+
+		return line
+
+	case partition_lib.MetadataDoesNotMatch:
+		return fmt.Sprintf("?~ %s %s %s actual=%s expected=%s", partitionDir, c.ManifestPath, c.Keyword, c.Actual, c.Expected)
+
+	case partition_lib.XattrDoesNotMatch:
+		return fmt.Sprintf("?x %s %s xattr=%s expected=%s", partitionDir, c.ManifestPath, c.XattrHash, c.ExpectedHash)
+
+	case partition_lib.ParityOutdated:
+		return fmt.Sprintf("?! %s parity is outdated", partitionDir)
+
+	case partition_lib.ParityDamaged:
+		return fmt.Sprintf("?! %s parity is damaged: %s", partitionDir, c.Reason)
 
 	default:
 		panic(fmt.Sprintf("Unknown ManifestMismatch: %+v", mismatch))
 	}
 }
+
+// Formats ranges as <offset>+<length>,...
+func sprintByteRanges(ranges []partition_lib.ByteRange) string {
+	parts := make([]string, 0, len(ranges))
+
+	for _, r := range ranges {
+		parts = append(parts, fmt.Sprintf("%d+%d", r.Offset, r.Length))
+	}
+
+	return strings.Join(parts, ",")
+}
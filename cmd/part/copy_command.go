@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+)
+
+func copyCommand(srcDir string, dstDir string) (int, error) {
+	partition, err := partition_lib.LoadPartition(srcDir)
+
+	if err != nil {
+		return 1, err
+	}
+
+	outcomes := partition.CopyTo(context.Background(), dstDir)
+
+	copiedCount := 0
+	copiedBytes := int64(0)
+	skippedCount := 0
+	failedCount := 0
+
+	for o := range outcomes.Channel {
+		switch c := o.(type) {
+		case partition_lib.FileCopied:
+			fmt.Printf("copied %s %s\n", dstDir, c.ManifestPath)
+
+			copiedCount++
+			copiedBytes += max(0, c.Size)
+
+		case partition_lib.FileAlreadyCopied:
+			skippedCount++
+
+		case partition_lib.FileNotCopied:
+			fmt.Printf("!copy %s %s: %s\n", srcDir, c.ManifestPath, c.Reason)
+			failedCount++
+
+		default:
+			panic(fmt.Sprintf("Unknown CopyOutcome: %+v", o))
+		}
+	}
+
+	if outcomes.Err != nil {
+		return 1, outcomes.Err
+	}
+
+	fmt.Fprintf(
+		os.Stderr,
+		"copied %d files (%d bytes), %d already copied, could not copy %d files\n",
+		copiedCount,
+		copiedBytes,
+		skippedCount,
+		failedCount,
+	)
+
+	if failedCount > 0 {
+		fmt.Fprintf(os.Stderr, "manifest was not written to %s\n", dstDir)
+		return 1, nil
+	}
+
+	return 0, nil
+}
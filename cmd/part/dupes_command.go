@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+)
+
+func dupesCommand(args []string) (int, error) {
+	flags := flag.NewFlagSet("dupes", flag.ContinueOnError)
+	all := flags.Bool("all", false, "")
+	hardlink := flags.Bool("hardlink", false, "")
+	reflink := flags.Bool("reflink", false, "")
+	deleteDupes := flags.Bool("delete", false, "")
+
+	partitionDirs := parseFlags(flags, args)
+
+	if *all {
+		if len(partitionDirs) != 0 {
+			printUsageAndExit("dupes --all does not accept partition dirs")
+		}
+
+		dirs, err := mountedRegisteredPartitionDirs()
+
+		if err != nil {
+			return 1, err
+		}
+
+		partitionDirs = dirs
+	}
+
+	if len(partitionDirs) == 0 {
+		printUsageAndExit("dupes requires at least 1 partition dir or --all")
+	}
+
+	actionsCount := 0
+	action := partition_lib.DedupeHardlink
+
+	if *hardlink {
+		actionsCount++
+	}
+
+	if *reflink {
+		actionsCount++
+		action = partition_lib.DedupeReflink
+	}
+
+	if *deleteDupes {
+		actionsCount++
+		action = partition_lib.DedupeDelete
+	}
+
+	if actionsCount > 1 {
+		printUsageAndExit("dupes --hardlink, --reflink and --delete are mutually exclusive")
+	}
+
+	partitions := make([]*partition_lib.Partition, 0, len(partitionDirs))
+
+	for _, dir := range partitionDirs {
+		p, err := partition_lib.LoadPartition(dir)
+
+		if err != nil {
+			return 1, err
+		}
+
+		partitions = append(partitions, p)
+	}
+
+	groups, err := partition_lib.FindDuplicates(partitions)
+
+	if err != nil {
+		return 1, err
+	}
+
+	if actionsCount == 0 {
+		reclaimable := int64(0)
+
+		for _, group := range groups {
+			fmt.Printf("= %s size=%d reclaimable=%d\n", group.Hash, group.Size, group.ReclaimableBytes())
+
+			for _, f := range group.Files {
+				fmt.Printf("  %s %s\n", f.Partition.AbsoluteDirOsPath, f.ManifestPath)
+			}
+
+			reclaimable += group.ReclaimableBytes()
+		}
+
+		fmt.Fprintf(os.Stderr, "%d groups of duplicates, %d bytes reclaimable\n", len(groups), reclaimable)
+		return 0, nil
+	}
+
+	outcomes := partition_lib.Dedupe(context.Background(), groups, action)
+	skippedCount := 0
+
+	for o := range outcomes.Channel {
+		switch c := o.(type) {
+		case partition_lib.DuplicateReplaced:
+			fmt.Printf(
+				"replaced %s %s with %s %s\n",
+				c.File.Partition.AbsoluteDirOsPath,
+				c.File.ManifestPath,
+				c.Kept.Partition.AbsoluteDirOsPath,
+				c.Kept.ManifestPath,
+			)
+
+		case partition_lib.DuplicateDeleted:
+			fmt.Printf("deleted %s %s\n", c.File.Partition.AbsoluteDirOsPath, c.File.ManifestPath)
+
+		case partition_lib.DuplicateSkipped:
+			fmt.Printf("!dedupe %s %s: %s\n", c.File.Partition.AbsoluteDirOsPath, c.File.ManifestPath, c.Reason)
+			skippedCount++
+
+		default:
+			panic(fmt.Sprintf("Unknown DedupeOutcome: %+v", o))
+		}
+	}
+
+	if outcomes.Err != nil {
+		return 1, outcomes.Err
+	}
+
+	if skippedCount > 0 {
+		fmt.Fprintf(os.Stderr, "skipped %d duplicates\n", skippedCount)
+		return 1, nil
+	}
+
+	return 0, nil
+}
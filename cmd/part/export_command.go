@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+)
+
+var exportFormats = map[string]partition_lib.ExportFormat{
+	"sha1sum":   partition_lib.ExportSha1sum,
+	"sha256sum": partition_lib.ExportSha256sum,
+	"bsd":       partition_lib.ExportBsd,
+	"hashdeep":  partition_lib.ExportHashdeep,
+}
+
+func exportCommand(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	formatName := flags.String("format", "sha1sum", "")
+	outputPath := flags.String("output", "", "")
+
+	positional := parseFlags(flags, args)
+
+	if len(positional) != 1 {
+		printUsageAndExit("export requires exactly 1 arg")
+	}
+
+	format, known := exportFormats[*formatName]
+
+	if !known {
+		printUsageAndExit(fmt.Sprintf("unknown export format %s", *formatName))
+	}
+
+	partition, err := loadPartitionAt(positional[0])
+
+	if err != nil {
+		return err
+	}
+
+	if *outputPath == "" {
+		return partition.Export(context.Background(), os.Stdout, format)
+	}
+
+	file, err := os.Create(*outputPath)
+
+	if err != nil {
+		return err
+	}
+
+	if err := partition.Export(context.Background(), file, format); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	return file.Close()
+}
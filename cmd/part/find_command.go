@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+)
+
+func findCommand(rootDirs []string) (int, error) {
+	partitionDirs, conflictsCount, err := discoverPartitions(rootDirs)
+
+	if err != nil {
+		return 1, err
+	}
+
+	for _, dir := range partitionDirs {
+		fmt.Println(dir)
+	}
+
+	if conflictsCount > 0 {
+		return 1, nil
+	}
+
+	return 0, nil
+}
+
+// Finds all partitions under given root dirs. Prints nested partition
+// conflicts to stdout and returns their count
+func discoverPartitions(rootDirs []string) ([]string, int, error) {
+	partitionDirs := make([]string, 0)
+
+	for _, root := range rootDirs {
+		dirs, err := partition_lib.FindPartitions(context.Background(), root)
+
+		if err != nil {
+			return nil, 0, err
+		}
+
+		partitionDirs = append(partitionDirs, dirs...)
+	}
+
+	conflicts, err := partition_lib.FindNestedPartitionConflicts(partitionDirs)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for _, c := range conflicts {
+		fmt.Println(sprintNestedPartitionConflict(c))
+	}
+
+	return partitionDirs, len(conflicts), nil
+}
+
+func sprintNestedPartitionConflict(c partition_lib.NestedPartitionConflict) string {
+	return fmt.Sprintf("!nested %s %s covered=%d", c.ParentDir, c.ChildDir, c.CoveredFiles)
+}
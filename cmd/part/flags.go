@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Like flags.Parse(), but allows flags to appear after positional args,
+// e.g. `part hash <dir> --only <subpath>`. Returns positional args
+//
+// Exits with usage message if flags are invalid
+func parseFlags(flags *flag.FlagSet, args []string) []string {
+	flags.SetOutput(io.Discard)
+	positional := make([]string, 0)
+
+	for {
+		if err := flags.Parse(args); err != nil {
+			printUsageAndExit(err.Error())
+		}
+
+		args = flags.Args()
+
+		if len(args) == 0 {
+			return positional
+		}
+
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// Parses sizes like 200G, 1.5TB, 4096. Units are powers of 1024
+func parseByteSize(s string) (int64, error) {
+	units := []struct {
+		suffix     string
+		multiplier float64
+	}{
+		{"T", 1 << 40},
+		{"G", 1 << 30},
+		{"M", 1 << 20},
+		{"K", 1 << 10},
+		{"B", 1},
+	}
+
+	trimmed := strings.TrimSuffix(strings.ToUpper(s), "B")
+	multiplier := 1.0
+
+	for _, u := range units {
+		if strings.HasSuffix(trimmed, u.suffix) {
+			trimmed = strings.TrimSuffix(trimmed, u.suffix)
+			multiplier = u.multiplier
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(trimmed, 64)
+
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %s", s)
+	}
+
+	return int64(value * multiplier), nil
+}
+
+// Like time.ParseDuration(), but also accepts days, e.g. 90d
+func parseDuration(s string) (time.Duration, error) {
+	days, isDays := strings.CutSuffix(s, "d")
+
+	if !isDays {
+		return time.ParseDuration(s)
+	}
+
+	value, err := strconv.ParseFloat(days, 64)
+
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %s", s)
+	}
+
+	return time.Duration(value * float64(24*time.Hour)), nil
+}
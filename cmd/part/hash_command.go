@@ -2,19 +2,90 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
+	"time"
 
 	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+	"github.com/azerum/data-storage-suite/pkg/registry"
 )
 
-func hashCommand(partitionDir string) error {
-	partition, err := partition_lib.LoadPartition(partitionDir)
+func hashCommand(args []string) error {
+	flags := flag.NewFlagSet("hash", flag.ContinueOnError)
+	noChunks := flags.Bool("no-chunks", false, "")
+	only := flags.String("only", "", "")
+	useCache := flags.Bool("cache", false, "")
+	xattr := flags.Bool("xattr", false, "")
+	var chunking *partition_lib.ChunkingOptions
+
+	flags.Func("chunk-size", "", func(s string) error {
+		size, err := parseByteSize(s)
+
+		if chunking == nil {
+			chunking = &partition_lib.ChunkingOptions{}
+		}
+
+		chunking.ChunkSize = size
+		return err
+	})
+
+	flags.Func("chunk-min-file-size", "", func(s string) error {
+		size, err := parseByteSize(s)
+
+		if chunking == nil {
+			chunking = &partition_lib.ChunkingOptions{}
+		}
+
+		chunking.MinFileSize = size
+		return err
+	})
+
+	positional := parseFlags(flags, args)
+
+	if len(positional) != 1 {
+		printUsageAndExit("hash requires exactly 1 arg")
+	}
+
+	if *noChunks && chunking != nil {
+		printUsageAndExit("hash --no-chunks cannot be used with --chunk-size")
+	}
+
+	partitionDir := positional[0]
+	partition, err := loadPartitionAt(partitionDir)
 
 	if err != nil {
-		return nil
+		return err
+	}
+
+	if *noChunks {
+		if err := partition.SetChunking(nil); err != nil {
+			return err
+		}
+	}
+
+	if chunking != nil {
+		if err := partition.SetChunking(chunking); err != nil {
+			return err
+		}
+	}
+
+	options := partition_lib.HashOptions{Only: *only, Xattr: *xattr}
+
+	if *useCache {
+		cacheDir, err := partition_lib.DefaultHashCacheDir()
+
+		if err != nil {
+			return err
+		}
+
+		options.Cache, err = partition_lib.NewHashCache(cacheDir)
+
+		if err != nil {
+			return err
+		}
 	}
 
-	changes := partition.Hash(context.Background())
+	changes := partition.HashWithOptions(context.Background(), options)
 
 	// Do not apply changes immediately, as Hash() reads from partition.manifest.Files,
 	// and concurrent read+write is not safe
@@ -41,7 +112,34 @@ func hashCommand(partitionDir string) error {
 		partition.ApplyChange(c)
 	}
 
-	return partition.Save()
+	if err := partition.Save(); err != nil {
+		return err
+	}
+
+	// Partial hash does not make the whole manifest up to date
+	if *only != "" {
+		return nil
+	}
+
+	return recordHashTime(partitionDir)
+}
+
+// Updates last hash time of the partition if it is registered
+func recordHashTime(partitionDir string) error {
+	r, err := registry.LoadDefault()
+
+	if err != nil {
+		return err
+	}
+
+	entry := r.FindByPath(partitionDir)
+
+	if entry == nil {
+		return nil
+	}
+
+	entry.LastHashTime = time.Now().Unix()
+	return r.Save()
 }
 
 func sprintManifestChange(change partition_lib.ManifestChange) string {
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+)
+
+func importCommand(partitionDir string, checksumFiles []string) (int, error) {
+	partition, err := partition_lib.LoadPartition(partitionDir)
+
+	if err != nil {
+		return 1, err
+	}
+
+	verifiedCount := 0
+	failedCount := 0
+
+	for _, checksumFile := range checksumFiles {
+		outcomes := partition.ImportChecksums(context.Background(), checksumFile)
+
+		for o := range outcomes.Channel {
+			switch c := o.(type) {
+			case partition_lib.ImportVerified:
+				fmt.Printf("+ %s\n", c.ManifestPath)
+				verifiedCount++
+
+			case partition_lib.ImportChecksumMismatch:
+				fmt.Printf("!* %s %s actual=%s expected=%s\n", c.ManifestPath, c.Algorithm, c.Actual, c.Expected)
+				failedCount++
+
+			case partition_lib.ImportFileMissing:
+				fmt.Printf("!- %s\n", c.ManifestPath)
+				failedCount++
+
+			case partition_lib.ImportSkipped:
+				fmt.Fprintf(os.Stderr, "skipped %s: %s\n", c.ManifestPath, c.Reason)
+
+			default:
+				panic(fmt.Sprintf("Unknown ImportOutcome: %+v", o))
+			}
+		}
+
+		if outcomes.Err != nil {
+			return 1, outcomes.Err
+		}
+	}
+
+	if err := partition.Save(); err != nil {
+		return 1, err
+	}
+
+	fmt.Fprintf(os.Stderr, "imported %d files, %d failed or absent\n", verifiedCount, failedCount)
+
+	if failedCount > 0 {
+		return 1, nil
+	}
+
+	return 0, nil
+}
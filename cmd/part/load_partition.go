@@ -0,0 +1,28 @@
+package main
+
+import (
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+	"github.com/azerum/data-storage-suite/pkg/s3fs"
+)
+
+// Loads partition from a directory, or from object storage if location is
+// s3://bucket/prefix URL. See s3fs.ConfigFromEnv() for S3 settings
+func loadPartitionAt(location string) (*partition_lib.Partition, error) {
+	if !s3fs.IsURL(location) {
+		return partition_lib.LoadPartition(location)
+	}
+
+	bucket, prefix, err := s3fs.ParseURL(location)
+
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := s3fs.New(s3fs.ConfigFromEnv(), bucket, prefix)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return partition_lib.LoadPartitionFromFileSystem(files, location)
+}
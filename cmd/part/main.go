@@ -14,20 +14,190 @@ func main() {
 
 	switch subcommand {
 	case "hash":
+		err := hashCommand(os.Args[2:])
+
+		if err != nil {
+			panic(err)
+		}
+
+	case "check":
+		exitCode, err := checkCommand(os.Args[2:])
+
+		if err != nil {
+			panic(err)
+		}
+
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+
+	case "verify":
+		exitCode, err := verifyCommand(os.Args[2:])
+
+		if err != nil {
+			panic(err)
+		}
+
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+
+	case "scrub":
+		exitCode, err := scrubCommand(os.Args[2:])
+
+		if err != nil {
+			panic(err)
+		}
+
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+
+	case "repair":
+		exitCode, err := repairCommand(os.Args[2:])
+
+		if err != nil {
+			panic(err)
+		}
+
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+
+	case "protect":
+		exitCode, err := protectCommand(os.Args[2:])
+
+		if err != nil {
+			panic(err)
+		}
+
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+
+	case "copy":
+		if len(os.Args) != 4 {
+			printUsageAndExit("copy requires exactly 2 args")
+		}
+
+		exitCode, err := copyCommand(os.Args[2], os.Args[3])
+
+		if err != nil {
+			panic(err)
+		}
+
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+
+	case "sync":
+		exitCode, err := syncCommand(os.Args[2:])
+
+		if err != nil {
+			panic(err)
+		}
+
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+
+	case "dupes":
+		exitCode, err := dupesCommand(os.Args[2:])
+
+		if err != nil {
+			panic(err)
+		}
+
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+
+	case "where":
+		exitCode, err := whereCommand(os.Args[2:])
+
+		if err != nil {
+			panic(err)
+		}
+
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+
+	case "export":
+		err := exportCommand(os.Args[2:])
+
+		if err != nil {
+			panic(err)
+		}
+
+	case "import":
+		if len(os.Args) < 4 {
+			printUsageAndExit("import requires at least 2 args")
+		}
+
+		exitCode, err := importCommand(os.Args[2], os.Args[3:])
+
+		if err != nil {
+			panic(err)
+		}
+
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+
+	case "bag":
+		exitCode, err := bagCommand(os.Args[2:])
+
+		if err != nil {
+			panic(err)
+		}
+
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+
+	case "mtree":
 		if len(os.Args) != 3 {
-			printUsageAndExit("hash requires exactly 1 arg")
+			printUsageAndExit("mtree requires exactly 1 arg")
 		}
 
-		partitionDir := os.Args[2]
-		err := hashCommand(partitionDir)
+		err := mtreeCommand(os.Args[2])
 
 		if err != nil {
 			panic(err)
 		}
 
-	case "check":
-		partitionDirs := os.Args[2:]
-		exitCode, err := checkCommand(partitionDirs)
+	case "pack":
+		if len(os.Args) != 4 {
+			printUsageAndExit("pack requires exactly 2 args")
+		}
+
+		err := packCommand(os.Args[2], os.Args[3])
+
+		if err != nil {
+			panic(err)
+		}
+
+	case "watch":
+		err := watchCommand(os.Args[2:])
+
+		if err != nil {
+			panic(err)
+		}
+
+	case "serve":
+		err := serveCommand(os.Args[2:])
+
+		if err != nil {
+			panic(err)
+		}
+
+	case "find":
+		if len(os.Args) < 3 {
+			printUsageAndExit("find requires at least 1 arg")
+		}
+
+		exitCode, err := findCommand(os.Args[2:])
 
 		if err != nil {
 			panic(err)
@@ -37,6 +207,35 @@ func main() {
 			os.Exit(exitCode)
 		}
 
+	case "add":
+		err := addCommand(os.Args[2:])
+
+		if err != nil {
+			panic(err)
+		}
+
+	case "remove":
+		if len(os.Args) != 3 {
+			printUsageAndExit("remove requires exactly 1 arg")
+		}
+
+		err := removeCommand(os.Args[2])
+
+		if err != nil {
+			panic(err)
+		}
+
+	case "list":
+		if len(os.Args) != 2 {
+			printUsageAndExit("list accepts no args")
+		}
+
+		err := listCommand()
+
+		if err != nil {
+			panic(err)
+		}
+
 	default:
 		printUsageAndExit(fmt.Sprintf("Unknown subcommand %s", subcommand))
 	}
@@ -47,8 +246,85 @@ func printUsageAndExit(specificMessage string) {
 
 	fmt.Print(
 		"Usage:\n\n" +
-			"- check <partition_dirs>... - check hashes of given partition directories\n" +
-			"- hash <partition_dir> - (re)hash given partition directory. Incremental\n\n",
+			"- check <partition_dirs>... - check hashes of given partition directories. Also accepts .tar/.zip\n" +
+			"  archives created by pack, and s3://bucket/prefix partitions (see S3 partitions below)\n" +
+			"- check --all - check all registered partitions. Skips partitions that are not mounted (their\n" +
+			"  dir has no manifest, e.g. empty mount point of an unplugged drive)\n" +
+			"- check --sample <5%|1000> [--seed <n>] <partition_dirs>... - quick check: verify existence & size\n" +
+			"  of all files, hash only a random sample of them. Same seed selects the same sample\n" +
+			"- check --stop-at-bad-chunk <partition_dirs>... - stop reading chunked file at its first corrupted chunk\n" +
+			"- check --mtree <spec> <dirs>... - check directories against mtree spec instead of manifests.\n" +
+			"  Also reports differing type, mode & time as ?~ dir path keyword actual= expected=\n" +
+			"- check reports ?x dir path xattr= expected= if hash in file's user.part.hash xattr (see hash --xattr)\n" +
+			"  was computed for the same mtime & size as in the manifest, but differs from it\n" +
+			"- check --recursive <root_dirs>... - find and check all partitions under given directories\n" +
+			"- verify <partition_dir> [<files>...] [--glob <pattern>...] - check only given files, or files in\n" +
+			"  the manifest matching the pattern (e.g. '2024/album/*.jpg'; * does not match /)\n" +
+			"- scrub <partition_dirs>... [--all] [--interval 90d] [--max-bytes 200G] [--max-duration 8h] -\n" +
+			"  verify files not verified within interval, oldest first, within given budget\n" +
+			"- repair <partition_dir> --from <replica_dir>... - restore missing & corrupted files from replica\n" +
+			"  partitions. Copies are verified against the manifest before replacing the damaged file\n" +
+			"- repair <partition_dir> --self [--from <replica_dir>...] - restore files from partition's parity.\n" +
+			"  Files parity cannot restore are looked up in replicas, if any\n" +
+			"- protect <partition_dir> [--redundancy 10%] - compute parity, so damaged files can be restored\n" +
+			"  with repair --self. Refuses if files do not match the manifest. Rerun after each hash\n" +
+			"- copy <partition_dir> <dst_dir> - copy partition, verifying each file against the manifest while\n" +
+			"  copying. Preserves mtimes. Writes manifest to dst only if all files match. Rerun to resume\n" +
+			"- sync <src_partition_dir> <dst_partition_dir> [--delete] [--dry-run] - apply adds, modifications\n" +
+			"  and, with --delete, deletes & moves to dst, computed from both manifests. Transferred files are\n" +
+			"  verified against src manifest. Prints operations: + add, * modify, > move, - delete\n" +
+			"- dupes <partition_dirs>... [--all] - list files with the same hash & size within and across\n" +
+			"  partitions, with reclaimable bytes. Uses manifests only\n" +
+			"- dupes <partition_dirs>... --hardlink|--reflink|--delete - keep the first file of each group, replace\n" +
+			"  the rest with hardlinks or reflinks (Btrfs/XFS), or delete them. Files are verified first\n" +
+			"- where <file|sha1> [partition_dirs...] - list partitions & paths of files with the same contents.\n" +
+			"  Searches registered partitions if no dirs are given. Uses an index of manifests, kept in\n" +
+			"  $PART_INDEX_DIR or the user cache dir\n" +
+			"- export <partition_dir> [--format sha1sum|sha256sum|bsd|hashdeep] [--output <file>] - print the\n" +
+			"  manifest as a checksum list, with paths relative to partition dir (so `sha1sum -c` works\n" +
+			"  inside it). sha256sum reads all files, verifying them against the manifest\n" +
+			"- import <partition_dir> <checksum_files>... - verify files listed in SHA1SUMS, .md5, .sfv (or\n" +
+			"  BSD-style) checksum files and add verified files to the manifest. Prints + verified,\n" +
+			"  !* checksum mismatch, !- absent\n" +
+			"- bag <partition_dir> <bag_dir> - create BagIt bag with copies of partition files, verified\n" +
+			"  against the manifest while copying\n" +
+			"- bag validate <bag_dir> - check bag payload & tag files against its manifests. Prints mismatches\n" +
+			"  like check does, with paths relative to the bag dir\n" +
+			"- mtree <partition_dir> - print mtree spec of the partition with type, mode, size, time and\n" +
+			"  sha256digest. Files are verified against the manifest while computing SHA-256\n" +
+			"- pack <partition_dir> <archive.tar|archive.zip> - pack partition files & manifest into an archive,\n" +
+			"  verifying files against the manifest while packing. Check the archive with check <archive>\n" +
+			"- find <root_dirs>... - list all partitions under given directories. Reports nested partition\n" +
+			"  conflicts: parent partition manifest covering files of a child partition\n" +
+			"- hash <partition_dir> - (re)hash given partition directory. Incremental\n" +
+			"- hash <partition_dir> --chunk-size 64M [--chunk-min-file-size 1G] - also hash files of at least\n" +
+			"  given size in chunks, so check can report corrupted byte ranges. Setting is remembered\n" +
+			"- hash <partition_dir> --only <subdir> - hash only given subtree (path relative to partition dir).\n" +
+			"  The rest of the manifest is left untouched\n" +
+			"- hash <partition_dir> --no-chunks - stop chunking newly hashed files\n" +
+			"- hash <partition_dir> --cache - take hashes of files unchanged since they were last hashed (in any\n" +
+			"  partition) from a cache shared by all partitions, keyed by device, inode, size, mtime & ctime.\n" +
+			"  Kept in $PART_HASH_CACHE_DIR or the user cache dir. Entries unused for 30 days are pruned\n" +
+			"- hash <partition_dir> --xattr - also store hash, algorithm, mtime & size of each file in its\n" +
+			"  user.part.hash xattr, so copies made with xattrs carry it along. Files whose xattr matches\n" +
+			"  their mtime & size are not read\n" +
+			"- watch <partition_dir> [--debounce 2s] [--checkpoint 1m] - keep the manifest current: watch the\n" +
+			"  partition for changes (inotify on Linux), hash changed files once they had no changes for the\n" +
+			"  debounce period, and save the manifest at most once per checkpoint interval while changes keep\n" +
+			"  coming. Rehashes everything at start and if events were lost. Prints changes like hash does,\n" +
+			"  prefixed with time. Stop with Ctrl+C\n" +
+			"- serve [--listen 127.0.0.1:8750 | --socket <path>] - run HTTP/JSON API on a loopback address or\n" +
+			"  a unix socket: GET /partitions (registered partitions with last hash & check results),\n" +
+			"  POST /partitions/<label>/hash|check|scrub (queue a job), GET /jobs/<id>, and GET\n" +
+			"  /jobs/<id>/events (job's changes & mismatches as server-sent events). Jobs of the same\n" +
+			"  partition run one at a time\n" +
+			"- add <partition_dir> [--label <label>] - register partition. Label defaults to dir name\n" +
+			"- remove <label|partition_dir> - unregister partition\n" +
+			"- list - list registered partitions with their last hash & check results\n\n" +
+			"S3 partitions: hash, check and export accept s3://bucket/prefix in place of partition_dir. The\n" +
+			"manifest is stored as object prefix/.manifest.json. Configured with AWS_ENDPOINT_URL_S3 (or\n" +
+			"AWS_ENDPOINT_URL, e.g. http://localhost:9000 for MinIO), AWS_REGION, AWS_ACCESS_KEY_ID and\n" +
+			"AWS_SECRET_ACCESS_KEY\n\n",
 	)
 
 	os.Exit(1)
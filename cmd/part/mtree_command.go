@@ -0,0 +1,18 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+)
+
+func mtreeCommand(partitionDir string) error {
+	partition, err := partition_lib.LoadPartition(partitionDir)
+
+	if err != nil {
+		return err
+	}
+
+	return partition.WriteMtree(context.Background(), os.Stdout)
+}
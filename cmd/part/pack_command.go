@@ -0,0 +1,17 @@
+package main
+
+import (
+	"context"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+)
+
+func packCommand(partitionDir string, archivePath string) error {
+	partition, err := partition_lib.LoadPartition(partitionDir)
+
+	if err != nil {
+		return err
+	}
+
+	return partition.Pack(context.Background(), archivePath)
+}
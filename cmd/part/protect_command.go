@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+)
+
+func protectCommand(args []string) (int, error) {
+	flags := flag.NewFlagSet("protect", flag.ContinueOnError)
+	redundancyString := flags.String("redundancy", "10%", "")
+
+	positional := parseFlags(flags, args)
+
+	if len(positional) != 1 {
+		printUsageAndExit("protect requires exactly 1 arg")
+	}
+
+	redundancy, err := parsePercentage(*redundancyString)
+
+	if err != nil {
+		printUsageAndExit(fmt.Sprintf("invalid --redundancy %s", *redundancyString))
+	}
+
+	partitionDir := positional[0]
+	partition, err := partition_lib.LoadPartition(partitionDir)
+
+	if err != nil {
+		return 1, err
+	}
+
+	// Parity protects files as they are, so corrupted files must not
+	// get protected
+	mismatches, err := partition.Check(context.Background()).Drain()
+
+	if err != nil {
+		return 1, err
+	}
+
+	hasFileMismatches := false
+
+	for _, m := range mismatches {
+		switch m.(type) {
+		case partition_lib.ParityOutdated, partition_lib.ParityDamaged, partition_lib.XattrDoesNotMatch:
+			continue
+		}
+
+		fmt.Println(sprintManifestMismatch(partitionDir, m))
+		hasFileMismatches = true
+	}
+
+	if hasFileMismatches {
+		fmt.Fprintf(os.Stderr, "not protecting %s: files do not match the manifest. Run hash or repair first\n", partitionDir)
+		return 1, nil
+	}
+
+	if err := partition.Protect(context.Background(), redundancy); err != nil {
+		return 1, err
+	}
+
+	return 0, nil
+}
+
+// Parses either percentage (10%) or fraction (0.1) into a fraction in (0, 1]
+func parsePercentage(s string) (float64, error) {
+	percent, isPercent := strings.CutSuffix(s, "%")
+	value, err := strconv.ParseFloat(percent, 64)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if isPercent {
+		value /= 100
+	}
+
+	if value <= 0 || value > 1 {
+		return 0, fmt.Errorf("%s is out of range", s)
+	}
+
+	return value, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/azerum/data-storage-suite/pkg/registry"
+)
+
+func addCommand(args []string) error {
+	flags := flag.NewFlagSet("add", flag.ContinueOnError)
+	label := flags.String("label", "", "")
+
+	positional := parseFlags(flags, args)
+
+	if len(positional) != 1 {
+		printUsageAndExit("add requires exactly 1 arg")
+	}
+
+	partitionDir := positional[0]
+
+	if *label == "" {
+		absolutePath, err := filepath.Abs(partitionDir)
+
+		if err != nil {
+			return err
+		}
+
+		*label = filepath.Base(absolutePath)
+	}
+
+	r, err := registry.LoadDefault()
+
+	if err != nil {
+		return err
+	}
+
+	entry, err := r.Add(*label, partitionDir)
+
+	if err != nil {
+		return err
+	}
+
+	if err := r.Save(); err != nil {
+		return err
+	}
+
+	fmt.Printf("added %s %s\n", entry.Label, entry.Path)
+	return nil
+}
+
+func removeCommand(labelOrPath string) error {
+	r, err := registry.LoadDefault()
+
+	if err != nil {
+		return err
+	}
+
+	if err := r.Remove(labelOrPath); err != nil {
+		return err
+	}
+
+	return r.Save()
+}
+
+func listCommand() error {
+	r, err := registry.LoadDefault()
+
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LABEL\tPATH\tMOUNTED\tLAST HASH\tLAST CHECK\tRESULT")
+
+	for _, e := range r.Partitions {
+		fmt.Fprintf(
+			w,
+			"%s\t%s\t%s\t%s\t%s\t%s\n",
+			e.Label,
+			e.Path,
+			sprintYesNo(e.IsMounted()),
+			sprintUnixTime(e.LastHashTime),
+			sprintUnixTime(e.LastCheckTime),
+			sprintCheckResult(e),
+		)
+	}
+
+	return w.Flush()
+}
+
+func sprintYesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+
+	return "no"
+}
+
+func sprintUnixTime(t int64) string {
+	if t == 0 {
+		return "never"
+	}
+
+	return time.Unix(t, 0).Format("2006-01-02 15:04")
+}
+
+func sprintCheckResult(e *registry.Entry) string {
+	if e.LastCheckTime == 0 {
+		return "-"
+	}
+
+	if e.LastCheckMismatches == 0 {
+		return "ok"
+	}
+
+	return fmt.Sprintf("%d mismatches", e.LastCheckMismatches)
+}
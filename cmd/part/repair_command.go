@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+	"github.com/azerum/data-storage-suite/pkg/utils"
+)
+
+func repairCommand(args []string) (int, error) {
+	flags := flag.NewFlagSet("repair", flag.ContinueOnError)
+	replicaDirs := make([]string, 0)
+
+	flags.Func("from", "", func(s string) error {
+		replicaDirs = append(replicaDirs, s)
+		return nil
+	})
+
+	self := flags.Bool("self", false, "")
+
+	positional := parseFlags(flags, args)
+
+	if len(positional) != 1 {
+		printUsageAndExit("repair requires exactly 1 arg")
+	}
+
+	if len(replicaDirs) == 0 && !*self {
+		printUsageAndExit("repair requires --self or at least one --from")
+	}
+
+	partitionDir := positional[0]
+	partition, err := partition_lib.LoadPartition(partitionDir)
+
+	if err != nil {
+		return 1, err
+	}
+
+	replicas := make([]*partition_lib.Partition, 0, len(replicaDirs))
+
+	for _, dir := range replicaDirs {
+		r, err := partition_lib.LoadPartition(dir)
+
+		if err != nil {
+			return 1, err
+		}
+
+		replicas = append(replicas, r)
+	}
+
+	mismatches, err := partition.Check(context.Background()).Drain()
+
+	if err != nil {
+		return 1, err
+	}
+
+	repairedCount := 0
+	notRepairedCount := 0
+
+	var outcomes *utils.ChanWithError[partition_lib.RepairOutcome]
+
+	if *self {
+		outcomes = partition.RepairFromParityAndReplicas(context.Background(), mismatches, replicas)
+	} else {
+		outcomes = partition.Repair(context.Background(), mismatches, replicas)
+	}
+
+	for o := range outcomes.Channel {
+		fmt.Println(sprintRepairOutcome(partitionDir, o))
+		countRepairOutcome(o, &repairedCount, &notRepairedCount)
+	}
+
+	if outcomes.Err != nil {
+		return 1, outcomes.Err
+	}
+
+	fmt.Fprintf(os.Stderr, "repaired %d files, could not repair %d files\n", repairedCount, notRepairedCount)
+
+	if notRepairedCount > 0 {
+		return 1, nil
+	}
+
+	return 0, nil
+}
+
+func countRepairOutcome(outcome partition_lib.RepairOutcome, repairedCount *int, notRepairedCount *int) {
+	if _, ok := outcome.(partition_lib.FileNotRepaired); ok {
+		*notRepairedCount++
+	} else {
+		*repairedCount++
+	}
+}
+
+func sprintRepairOutcome(partitionDir string, outcome partition_lib.RepairOutcome) string {
+	switch o := outcome.(type) {
+	case partition_lib.FileRepaired:
+		return fmt.Sprintf("repaired %s %s from %s %s", partitionDir, o.ManifestPath, o.ReplicaDir, o.ReplicaManifestPath)
+
+	case partition_lib.FileRepairedFromParity:
+		return fmt.Sprintf("repaired %s %s from parity", partitionDir, o.ManifestPath)
+
+	case partition_lib.FileNotRepaired:
+		return fmt.Sprintf("!repair %s %s: %s", partitionDir, o.ManifestPath, o.Reason)
+
+	default:
+		panic(fmt.Sprintf("Unknown RepairOutcome: %+v", outcome))
+	}
+}
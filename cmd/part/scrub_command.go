@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+)
+
+func scrubCommand(args []string) (int, error) {
+	flags := flag.NewFlagSet("scrub", flag.ContinueOnError)
+	all := flags.Bool("all", false, "")
+
+	options := partition_lib.ScrubOptions{
+		Interval: 90 * 24 * time.Hour,
+	}
+
+	flags.Func("interval", "", func(s string) error {
+		d, err := parseDuration(s)
+		options.Interval = d
+		return err
+	})
+
+	flags.Func("max-bytes", "", func(s string) error {
+		size, err := parseByteSize(s)
+		options.MaxBytes = size
+		return err
+	})
+
+	flags.Func("max-duration", "", func(s string) error {
+		d, err := parseDuration(s)
+		options.MaxDuration = d
+		return err
+	})
+
+	partitionDirs := parseFlags(flags, args)
+
+	if *all {
+		if len(partitionDirs) != 0 {
+			printUsageAndExit("scrub --all does not accept partition dirs")
+		}
+
+		dirs, err := mountedRegisteredPartitionDirs()
+
+		if err != nil {
+			return 1, err
+		}
+
+		partitionDirs = dirs
+	}
+
+	// Partitions are scrubbed one by one, so the budget is shared
+	// between them
+	startTime := time.Now()
+	hadAtLeastOneMismatch := false
+
+	for _, dir := range partitionDirs {
+		remainingOptions := options
+
+		if options.MaxDuration > 0 {
+			remainingOptions.MaxDuration = options.MaxDuration - time.Since(startTime)
+
+			if remainingOptions.MaxDuration <= 0 {
+				break
+			}
+		}
+
+		stats, mismatchesCount, err := scrubPartition(dir, remainingOptions)
+
+		if err != nil {
+			return 1, err
+		}
+
+		if mismatchesCount > 0 {
+			hadAtLeastOneMismatch = true
+		}
+
+		fmt.Fprintf(
+			os.Stderr,
+			"scrubbed %s: verified %d files (%d bytes), %d files still due\n",
+			dir,
+			stats.VerifiedFiles,
+			stats.VerifiedBytes,
+			stats.RemainingDueFiles,
+		)
+
+		if options.MaxBytes > 0 {
+			options.MaxBytes -= stats.VerifiedBytes
+
+			if options.MaxBytes <= 0 {
+				break
+			}
+		}
+	}
+
+	if hadAtLeastOneMismatch {
+		return 1, nil
+	}
+
+	return 0, nil
+}
+
+func scrubPartition(
+	partitionDir string,
+	options partition_lib.ScrubOptions,
+) (*partition_lib.ScrubStats, int, error) {
+	partition, err := partition_lib.LoadPartition(partitionDir)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	mismatches, stats := partition.Scrub(context.Background(), options)
+	mismatchesCount := 0
+
+	for m := range mismatches.Channel {
+		fmt.Println(sprintManifestMismatch(partitionDir, m))
+		mismatchesCount++
+	}
+
+	if mismatches.Err != nil {
+		return nil, 0, mismatches.Err
+	}
+
+	return stats, mismatchesCount, nil
+}
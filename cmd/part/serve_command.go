@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/azerum/data-storage-suite/pkg/daemon"
+	"github.com/azerum/data-storage-suite/pkg/registry"
+)
+
+func serveCommand(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	socket := flags.String("socket", "", "")
+	address := flags.String("listen", "127.0.0.1:8750", "")
+
+	positional := parseFlags(flags, args)
+
+	if len(positional) != 0 {
+		printUsageAndExit("serve accepts no args")
+	}
+
+	registryPath, err := registry.DefaultPath()
+
+	if err != nil {
+		return err
+	}
+
+	var listener net.Listener
+
+	if *socket != "" {
+		listener, err = daemon.ListenUnix(*socket)
+	} else {
+		listener, err = daemon.ListenLocalhost(*address)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	server := daemon.New(registryPath)
+	httpServer := http.Server{Handler: server}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	served := make(chan error, 1)
+
+	go func() {
+		served <- httpServer.Serve(listener)
+	}()
+
+	fmt.Fprintf(os.Stderr, "serving on %s\n", listener.Addr())
+
+	select {
+	case err := <-served:
+		server.Close()
+		return err
+
+	case <-ctx.Done():
+	}
+
+	// Cancel jobs first, so event streams of running jobs end
+	server.Close()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := httpServer.Shutdown(shutdownCtx); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+)
+
+func syncCommand(args []string) (int, error) {
+	flags := flag.NewFlagSet("sync", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "")
+	deleteExtra := flags.Bool("delete", false, "")
+
+	positional := parseFlags(flags, args)
+
+	if len(positional) != 2 {
+		printUsageAndExit("sync requires exactly 2 args")
+	}
+
+	src, err := partition_lib.LoadPartition(positional[0])
+
+	if err != nil {
+		return 1, err
+	}
+
+	dst, err := partition_lib.LoadPartition(positional[1])
+
+	if err != nil {
+		return 1, err
+	}
+
+	ops, err := src.PlanSync(dst, partition_lib.SyncOptions{Delete: *deleteExtra})
+
+	if err != nil {
+		return 1, err
+	}
+
+	if *dryRun {
+		for _, op := range ops {
+			fmt.Println(sprintSyncOperation(op))
+		}
+
+		return 0, nil
+	}
+
+	outcomes := src.ApplySync(context.Background(), dst, ops)
+	failedCount := 0
+
+	for o := range outcomes.Channel {
+		switch c := o.(type) {
+		case partition_lib.SyncApplied:
+			fmt.Println(sprintSyncOperation(c.Operation))
+
+		case partition_lib.SyncFailed:
+			fmt.Printf("!%s: %s\n", sprintSyncOperation(c.Operation), c.Reason)
+			failedCount++
+
+		default:
+			panic(fmt.Sprintf("Unknown SyncOutcome: %+v", o))
+		}
+	}
+
+	if outcomes.Err != nil {
+		return 1, outcomes.Err
+	}
+
+	if failedCount > 0 {
+		fmt.Fprintf(os.Stderr, "could not sync %d files\n", failedCount)
+		return 1, nil
+	}
+
+	return 0, nil
+}
+
+func sprintSyncOperation(op partition_lib.SyncOperation) string {
+	switch o := op.(type) {
+	case partition_lib.SyncAdd:
+		return fmt.Sprintf("+ %s", o.ManifestPath)
+
+	case partition_lib.SyncModify:
+		return fmt.Sprintf("* %s", o.ManifestPath)
+
+	case partition_lib.SyncMove:
+		return fmt.Sprintf("> %s %s", o.FromManifestPath, o.ManifestPath)
+
+	case partition_lib.SyncDelete:
+		return fmt.Sprintf("- %s", o.ManifestPath)
+
+	default:
+		panic(fmt.Sprintf("Unknown SyncOperation: %+v", op))
+	}
+}
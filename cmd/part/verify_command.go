@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+)
+
+func verifyCommand(args []string) (int, error) {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	globs := make([]string, 0)
+
+	flags.Func("glob", "", func(s string) error {
+		globs = append(globs, s)
+		return nil
+	})
+
+	positional := parseFlags(flags, args)
+
+	if len(positional) < 1 {
+		printUsageAndExit("verify requires partition dir")
+	}
+
+	if len(positional) == 1 && len(globs) == 0 {
+		printUsageAndExit("verify requires files or --glob")
+	}
+
+	partitionDir := positional[0]
+	partition, err := partition_lib.LoadPartition(partitionDir)
+
+	if err != nil {
+		return 1, err
+	}
+
+	manifestPaths := make([]string, 0)
+
+	for _, file := range positional[1:] {
+		p, err := manifestPathOfFile(partitionDir, file)
+
+		if err != nil {
+			return 1, err
+		}
+
+		manifestPaths = append(manifestPaths, p)
+	}
+
+	for _, glob := range globs {
+		matching, err := partition.ManifestPathsMatching(glob)
+
+		if err != nil {
+			return 1, err
+		}
+
+		// Likely a typo. Checking nothing must not look like success
+		if len(matching) == 0 {
+			return 1, fmt.Errorf("no files in the manifest match %s", glob)
+		}
+
+		manifestPaths = append(manifestPaths, matching...)
+	}
+
+	slices.Sort(manifestPaths)
+	manifestPaths = slices.Compact(manifestPaths)
+
+	mismatches := partition.CheckPaths(context.Background(), manifestPaths)
+	mismatchesCount := 0
+
+	for m := range mismatches.Channel {
+		fmt.Println(sprintManifestMismatch(partitionDir, m))
+		mismatchesCount++
+	}
+
+	if mismatches.Err != nil {
+		return 1, mismatches.Err
+	}
+
+	if mismatchesCount > 0 {
+		return 1, nil
+	}
+
+	return 0, nil
+}
+
+// Converts path of file (relative to the current dir, or absolute) to
+// its manifest path in the partition
+func manifestPathOfFile(partitionDir string, file string) (string, error) {
+	absoluteDir, err := filepath.Abs(partitionDir)
+
+	if err != nil {
+		return "", err
+	}
+
+	absoluteFile, err := filepath.Abs(file)
+
+	if err != nil {
+		return "", err
+	}
+
+	p, err := filepath.Rel(absoluteDir, absoluteFile)
+
+	if err != nil {
+		return "", err
+	}
+
+	if p == "." || p == ".." || strings.HasPrefix(p, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is not inside partition %s", file, partitionDir)
+	}
+
+	return filepath.ToSlash(p), nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+)
+
+func watchCommand(args []string) error {
+	flags := flag.NewFlagSet("watch", flag.ContinueOnError)
+	debounce := flags.Duration("debounce", partition_lib.DefaultWatchOptions.Debounce, "")
+	checkpoint := flags.Duration("checkpoint", partition_lib.DefaultWatchOptions.CheckpointInterval, "")
+
+	positional := parseFlags(flags, args)
+
+	if len(positional) != 1 {
+		printUsageAndExit("watch requires exactly 1 arg")
+	}
+
+	partition, err := partition_lib.LoadPartition(positional[0])
+
+	if err != nil {
+		return err
+	}
+
+	// Manifest is saved on exit, so stop gracefully
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	changes := partition.Watch(ctx, partition_lib.WatchOptions{
+		Debounce:           *debounce,
+		CheckpointInterval: *checkpoint,
+	})
+
+	for c := range changes.Channel {
+		line := sprintManifestChange(c)
+
+		if line != "" {
+			fmt.Printf("%s %s\n", time.Now().Format(time.RFC3339), line)
+		}
+	}
+
+	return changes.Err
+}
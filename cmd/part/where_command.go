@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+)
+
+var sha1Regexp = regexp.MustCompile("^[0-9a-fA-F]{40}$")
+
+func whereCommand(args []string) (int, error) {
+	flags := flag.NewFlagSet("where", flag.ContinueOnError)
+	positional := parseFlags(flags, args)
+
+	if len(positional) < 1 {
+		printUsageAndExit("where requires at least 1 arg")
+	}
+
+	hash, err := hashToFind(positional[0])
+
+	if err != nil {
+		return 1, err
+	}
+
+	partitionDirs := positional[1:]
+
+	if len(partitionDirs) == 0 {
+		partitionDirs, err = mountedRegisteredPartitionDirs()
+
+		if err != nil {
+			return 1, err
+		}
+	}
+
+	indexDir, err := partition_lib.DefaultHashIndexDir()
+
+	if err != nil {
+		return 1, err
+	}
+
+	index := partition_lib.NewHashIndex(indexDir)
+	foundCount := 0
+
+	for _, dir := range partitionDirs {
+		paths, err := index.Lookup(dir, hash)
+
+		if err != nil {
+			return 1, err
+		}
+
+		for _, p := range paths {
+			fmt.Printf("%s %s\n", dir, p)
+		}
+
+		foundCount += len(paths)
+	}
+
+	if foundCount == 0 {
+		fmt.Fprintf(os.Stderr, "%s not found\n", hash)
+		return 1, nil
+	}
+
+	return 0, nil
+}
+
+// Argument is either a file to hash or a SHA-1. Existing file wins, in
+// case a file is named like a hash
+func hashToFind(fileOrHash string) (string, error) {
+	_, err := os.Stat(fileOrHash)
+
+	if err == nil {
+		return partition_lib.HashFile(fileOrHash)
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+
+	if !sha1Regexp.MatchString(fileOrHash) {
+		return "", fmt.Errorf("%s is neither an existing file nor a SHA-1", fileOrHash)
+	}
+
+	return strings.ToLower(fileOrHash), nil
+}
@@ -2,10 +2,17 @@ module github.com/azerum/data-storage-suite
 
 go 1.25.1
 
+require (
+	github.com/fsnotify/fsnotify v1.9.0
+	github.com/klauspost/reedsolomon v1.10.0
+	github.com/onsi/gomega v1.39.0
+	golang.org/x/sys v0.35.0
+)
+
 require (
 	github.com/google/go-cmp v0.7.0 // indirect
+	github.com/klauspost/cpuid/v2 v2.2.11 // indirect
 	github.com/natefinch/atomic v1.0.1 // indirect
-	github.com/onsi/gomega v1.39.0 // indirect
 	go.yaml.in/yaml/v3 v3.0.4 // indirect
 	golang.org/x/net v0.43.0 // indirect
 	golang.org/x/text v0.28.0 // indirect
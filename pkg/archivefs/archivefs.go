@@ -0,0 +1,51 @@
+// Package archivefs exposes files of tar and zip archives as io/fs.FS, so
+// partitions packed into an archive can be checked without extracting them
+package archivefs
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// IsArchive reports whether the file at path has the extension of a
+// supported archive format (.tar or .zip)
+func IsArchive(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".tar", ".zip":
+		return true
+
+	default:
+		return false
+	}
+}
+
+// Open opens the archive at path. Format is chosen by the extension, see
+// IsArchive(). Returned closer must be closed once fsys is no longer used
+func Open(path string) (fs.FS, io.Closer, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".tar":
+		t, err := openTar(path)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return t, t, nil
+
+	case ".zip":
+		z, err := zip.OpenReader(path)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return &z.Reader, z, nil
+
+	default:
+		return nil, nil, fmt.Errorf("%s is not a .tar or .zip archive", path)
+	}
+}
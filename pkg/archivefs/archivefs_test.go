@@ -0,0 +1,167 @@
+package archivefs_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/azerum/data-storage-suite/pkg/archivefs"
+	. "github.com/onsi/gomega"
+)
+
+var testFiles = []struct {
+	name     string
+	contents string
+}{
+	{"a", "A"},
+	{"./b", "B"},
+	{"c/d", "D"},
+	{"c/e/f", "F"},
+	{"empty", ""},
+}
+
+func Test_tar_archive_is_valid_fs(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	archivePath := filepath.Join(t.TempDir(), "test.tar")
+
+	writeArchive(archivePath, func(w io.Writer) {
+		tw := tar.NewWriter(w)
+
+		for _, f := range testFiles {
+			header := tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     f.name,
+				Size:     int64(len(f.contents)),
+				Mode:     0o644,
+				ModTime:  time.Unix(1_700_000_000, 0),
+			}
+
+			if err := tw.WriteHeader(&header); err != nil {
+				panic(err)
+			}
+
+			if _, err := tw.Write([]byte(f.contents)); err != nil {
+				panic(err)
+			}
+		}
+
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "link", Linkname: "a"}); err != nil {
+			panic(err)
+		}
+
+		if err := tw.Close(); err != nil {
+			panic(err)
+		}
+	})
+
+	fsys := openArchive(t, archivePath)
+
+	g.Expect(fstest.TestFS(fsys, "a", "b", "c/d", "c/e/f", "empty")).To(Succeed())
+
+	contents, err := fs.ReadFile(fsys, "c/e/f")
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(string(contents)).To(Equal("F"))
+
+	_, err = fs.Stat(fsys, "link")
+	g.Expect(err).To(MatchError(fs.ErrNotExist))
+}
+
+func Test_tar_archive_with_file_and_directory_of_the_same_name_is_rejected(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	for _, names := range [][]string{{"a", "a/b"}, {"a/b", "a"}} {
+		archivePath := filepath.Join(t.TempDir(), "test.tar")
+
+		writeArchive(archivePath, func(w io.Writer) {
+			tw := tar.NewWriter(w)
+
+			for _, name := range names {
+				if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0o644}); err != nil {
+					panic(err)
+				}
+			}
+
+			if err := tw.Close(); err != nil {
+				panic(err)
+			}
+		})
+
+		_, _, err := archivefs.Open(archivePath)
+		g.Expect(err).To(MatchError(ContainSubstring("a is both a file and a directory")), "%v", names)
+	}
+}
+
+func Test_zip_archive_is_valid_fs(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	archivePath := filepath.Join(t.TempDir(), "test.zip")
+
+	writeArchive(archivePath, func(w io.Writer) {
+		zw := zip.NewWriter(w)
+
+		for _, f := range testFiles {
+			fw, err := zw.Create(filepath.Clean(f.name))
+
+			if err != nil {
+				panic(err)
+			}
+
+			if _, err := fw.Write([]byte(f.contents)); err != nil {
+				panic(err)
+			}
+		}
+
+		if err := zw.Close(); err != nil {
+			panic(err)
+		}
+	})
+
+	fsys := openArchive(t, archivePath)
+
+	g.Expect(fstest.TestFS(fsys, "a", "b", "c/d", "c/e/f", "empty")).To(Succeed())
+}
+
+func Test_IsArchive_checks_extension(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	g.Expect(archivefs.IsArchive("x/backup.tar")).To(BeTrue())
+	g.Expect(archivefs.IsArchive("backup.ZIP")).To(BeTrue())
+	g.Expect(archivefs.IsArchive("backup.tar.gz")).To(BeFalse())
+	g.Expect(archivefs.IsArchive("backup")).To(BeFalse())
+}
+
+func writeArchive(archivePath string, write func(w io.Writer)) {
+	file, err := os.Create(archivePath)
+
+	if err != nil {
+		panic(err)
+	}
+
+	write(file)
+
+	if err := file.Close(); err != nil {
+		panic(err)
+	}
+}
+
+func openArchive(t *testing.T, archivePath string) fs.FS {
+	fsys, closer, err := archivefs.Open(archivePath)
+
+	if err != nil {
+		panic(err)
+	}
+
+	t.Cleanup(func() { _ = closer.Close() })
+	return fsys
+}
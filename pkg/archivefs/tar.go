@@ -0,0 +1,255 @@
+package archivefs
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// tarFS indexes regular files of a tar archive by their offsets, so they
+// can be read in any order without rescanning the archive. Directories are
+// synthesized from file paths, since archives often omit them
+type tarFS struct {
+	file    *os.File
+	entries map[string]*tarEntry
+}
+
+type tarEntry struct {
+	name    string
+	offset  int64
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+
+	// nil for files
+	children []*tarEntry
+}
+
+func openTar(filePath string) (*tarFS, error) {
+	file, err := os.Open(filePath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	t := tarFS{
+		file:    file,
+		entries: map[string]*tarEntry{".": {name: ".", mode: fs.ModeDir | 0o755, children: []*tarEntry{}}},
+	}
+
+	if err := t.index(); err != nil {
+		_ = file.Close()
+		return nil, errors.Join(fmt.Errorf("failed to read tar archive %s", filePath), err)
+	}
+
+	return &t, nil
+}
+
+func (t *tarFS) index() error {
+	reader := tar.NewReader(t.file)
+
+	for {
+		header, err := reader.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		name := path.Clean(strings.TrimPrefix(header.Name, "./"))
+
+		if name == "." {
+			continue
+		}
+
+		if !fs.ValidPath(name) {
+			return fmt.Errorf("unsafe path in archive: %s", header.Name)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			dir, err := t.dir(name)
+
+			if err != nil {
+				return err
+			}
+
+			dir.mode = fs.ModeDir | header.FileInfo().Mode().Perm()
+			dir.modTime = header.ModTime
+
+		case tar.TypeReg:
+			if isSparse(header) {
+				return fmt.Errorf("sparse files are not supported: %s", header.Name)
+			}
+
+			// tar.Reader reads headers in whole blocks, so after Next()
+			// the file is positioned at the start of contents
+			offset, err := t.file.Seek(0, io.SeekCurrent)
+
+			if err != nil {
+				return err
+			}
+
+			entry := tarEntry{
+				name:    name,
+				offset:  offset,
+				size:    header.Size,
+				mode:    header.FileInfo().Mode().Perm(),
+				modTime: header.ModTime,
+			}
+
+			parent, err := t.dir(path.Dir(name))
+
+			if err != nil {
+				return err
+			}
+
+			if previous := t.entries[name]; previous != nil {
+				if previous.children != nil {
+					return fmt.Errorf("%s is both a file and a directory", header.Name)
+				}
+
+				// Later entries replace earlier ones, as with `tar -x`
+				*previous = entry
+				continue
+			}
+
+			t.entries[name] = &entry
+			parent.children = append(parent.children, &entry)
+
+		case tar.TypeGNUSparse:
+			return fmt.Errorf("sparse files are not supported: %s", header.Name)
+
+		default:
+			// Links, devices, etc. cannot be part of a partition
+		}
+	}
+
+	for _, e := range t.entries {
+		if e.children != nil {
+			slices.SortFunc(e.children, func(a, b *tarEntry) int {
+				return strings.Compare(a.name, b.name)
+			})
+		}
+	}
+
+	return nil
+}
+
+func isSparse(header *tar.Header) bool {
+	for key := range header.PAXRecords {
+		if strings.HasPrefix(key, "GNU.sparse.") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns directory entry, creating it and its parents if needed. Fails if
+// there is a file with this name
+func (t *tarFS) dir(name string) (*tarEntry, error) {
+	if e := t.entries[name]; e != nil {
+		if e.children == nil {
+			return nil, fmt.Errorf("%s is both a file and a directory", name)
+		}
+
+		return e, nil
+	}
+
+	parent, err := t.dir(path.Dir(name))
+
+	if err != nil {
+		return nil, err
+	}
+
+	dir := tarEntry{name: name, mode: fs.ModeDir | 0o755, children: []*tarEntry{}}
+	t.entries[name] = &dir
+	parent.children = append(parent.children, &dir)
+
+	return &dir, nil
+}
+
+func (t *tarFS) Close() error {
+	return t.file.Close()
+}
+
+func (t *tarFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	e := t.entries[name]
+
+	if e == nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	if e.children != nil {
+		return &tarDir{entry: e}, nil
+	}
+
+	return &tarFile{entry: e, SectionReader: io.NewSectionReader(t.file, e.offset, e.size)}, nil
+}
+
+func (e *tarEntry) Name() string               { return path.Base(e.name) }
+func (e *tarEntry) Size() int64                { return e.size }
+func (e *tarEntry) Mode() fs.FileMode          { return e.mode }
+func (e *tarEntry) ModTime() time.Time         { return e.modTime }
+func (e *tarEntry) IsDir() bool                { return e.children != nil }
+func (e *tarEntry) Sys() any                   { return nil }
+func (e *tarEntry) Type() fs.FileMode          { return e.mode.Type() }
+func (e *tarEntry) Info() (fs.FileInfo, error) { return e, nil }
+
+type tarFile struct {
+	entry *tarEntry
+	*io.SectionReader
+}
+
+func (f *tarFile) Stat() (fs.FileInfo, error) { return f.entry, nil }
+func (f *tarFile) Close() error               { return nil }
+
+type tarDir struct {
+	entry *tarEntry
+
+	// Number of children returned by ReadDir()
+	read int
+}
+
+func (d *tarDir) Stat() (fs.FileInfo, error) { return d.entry, nil }
+func (d *tarDir) Close() error               { return nil }
+
+func (d *tarDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.entry.name, Err: errors.New("is a directory")}
+}
+
+func (d *tarDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	remaining := d.entry.children[d.read:]
+
+	if n > 0 {
+		if len(remaining) == 0 {
+			return nil, io.EOF
+		}
+
+		remaining = remaining[:min(n, len(remaining))]
+	}
+
+	entries := make([]fs.DirEntry, len(remaining))
+
+	for i, e := range remaining {
+		entries[i] = e
+	}
+
+	d.read += len(remaining)
+	return entries, nil
+}
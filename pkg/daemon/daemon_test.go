@@ -0,0 +1,467 @@
+package daemon_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/azerum/data-storage-suite/pkg/daemon"
+	"github.com/azerum/data-storage-suite/pkg/registry"
+	. "github.com/onsi/gomega"
+	gs "github.com/onsi/gomega/gstruct"
+)
+
+type testServer struct {
+	url          string
+	client       *http.Client
+	partitionDir string
+}
+
+// Starts server with one registered partition "p" with files a and b/c,
+// which are not hashed yet
+func setupServer(t *testing.T) *testServer {
+	partitionDir := t.TempDir()
+
+	writeFile(filepath.Join(partitionDir, "a"), "A")
+	writeFile(filepath.Join(partitionDir, "b", "c"), "C")
+
+	registryPath := filepath.Join(t.TempDir(), "registry.json")
+	r, err := registry.Load(registryPath)
+
+	if err != nil {
+		panic(err)
+	}
+
+	if _, err := r.Add("p", partitionDir); err != nil {
+		panic(err)
+	}
+
+	if err := r.Save(); err != nil {
+		panic(err)
+	}
+
+	server := daemon.New(registryPath)
+	httpServer := httptest.NewServer(server)
+
+	t.Cleanup(func() {
+		server.Close()
+		httpServer.Close()
+	})
+
+	return &testServer{
+		url:          httpServer.URL,
+		client:       httpServer.Client(),
+		partitionDir: partitionDir,
+	}
+}
+
+func writeFile(path string, contents string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		panic(err)
+	}
+
+	if err := os.WriteFile(path, ([]byte)(contents), 0o600); err != nil {
+		panic(err)
+	}
+}
+
+// Decodes JSON response into out. Returns status code
+func (s *testServer) request(method string, path string, out any) int {
+	req, err := http.NewRequest(method, s.url+path, nil)
+
+	if err != nil {
+		panic(err)
+	}
+
+	resp, err := s.client.Do(req)
+
+	if err != nil {
+		panic(err)
+	}
+
+	defer resp.Body.Close()
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			panic(err)
+		}
+	}
+
+	return resp.StatusCode
+}
+
+func (s *testServer) startJob(label string, kind string) daemon.JobStatus {
+	var status daemon.JobStatus
+
+	if code := s.request(http.MethodPost, "/partitions/"+label+"/"+kind, &status); code != http.StatusAccepted {
+		panic(code)
+	}
+
+	return status
+}
+
+type sseEvent struct {
+	id   string
+	name string
+	data string
+}
+
+// Reads event stream of the job until "end" event. Returns events before it
+// and the final job status
+func (s *testServer) waitForJob(id string, lastEventID string) ([]sseEvent, daemon.JobStatus) {
+	req, err := http.NewRequest(http.MethodGet, s.url+"/jobs/"+id+"/events", nil)
+
+	if err != nil {
+		panic(err)
+	}
+
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+
+	resp, err := s.client.Do(req)
+
+	if err != nil {
+		panic(err)
+	}
+
+	defer resp.Body.Close()
+
+	events := make([]sseEvent, 0)
+	current := sseEvent{}
+	scanner := bufio.NewScanner(resp.Body)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if line == "" {
+			if current.name == "end" {
+				var status daemon.JobStatus
+
+				if err := json.Unmarshal(([]byte)(current.data), &status); err != nil {
+					panic(err)
+				}
+
+				return events, status
+			}
+
+			events = append(events, current)
+			current = sseEvent{}
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ": ")
+
+		switch field {
+		case "id":
+			current.id = value
+
+		case "event":
+			current.name = value
+
+		case "data":
+			current.data = value
+		}
+	}
+
+	panic("event stream ended without end event")
+}
+
+func decodeEvents(events []sseEvent) []daemon.Event {
+	decoded := make([]daemon.Event, 0, len(events))
+
+	for _, e := range events {
+		var event daemon.Event
+
+		if err := json.Unmarshal(([]byte)(e.data), &event); err != nil {
+			panic(err)
+		}
+
+		decoded = append(decoded, event)
+	}
+
+	return decoded
+}
+
+func Test_hash_job_streams_changes_and_records_hash_time(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	s := setupServer(t)
+	job := s.startJob("p", "hash")
+
+	g.Expect(job.Partition).To(Equal("p"))
+	g.Expect(job.Kind).To(Equal(daemon.JobHash))
+
+	events, status := s.waitForJob(job.ID, "")
+
+	g.Expect(status.State).To(Equal(daemon.JobSucceeded))
+	g.Expect(status.Changes).To(Equal(2))
+
+	g.Expect(decodeEvents(events)).To(ConsistOf(
+		daemon.Event{Kind: "change", Type: "added", Path: "a"},
+		daemon.Event{Kind: "change", Type: "added", Path: "b/c"},
+	))
+
+	g.Expect(filepath.Join(s.partitionDir, ".manifest.json")).To(BeAnExistingFile())
+
+	var partitions []daemon.PartitionStatus
+	g.Expect(s.request(http.MethodGet, "/partitions", &partitions)).To(Equal(http.StatusOK))
+
+	g.Expect(partitions).To(ConsistOf(
+		gs.MatchFields(gs.IgnoreExtras, gs.Fields{
+			"Entry": gs.MatchFields(gs.IgnoreExtras, gs.Fields{
+				"Label":        Equal("p"),
+				"LastHashTime": BeNumerically(">", 0),
+			}),
+
+			"Mounted":    BeTrue(),
+			"ActiveJobs": BeEmpty(),
+		}),
+	))
+}
+
+func Test_check_job_streams_mismatches_and_records_results(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	s := setupServer(t)
+	s.waitForJob(s.startJob("p", "hash").ID, "")
+
+	writeFile(filepath.Join(s.partitionDir, "d"), "D")
+
+	if err := os.Remove(filepath.Join(s.partitionDir, "a")); err != nil {
+		panic(err)
+	}
+
+	events, status := s.waitForJob(s.startJob("p", "check").ID, "")
+
+	g.Expect(status.State).To(Equal(daemon.JobSucceeded))
+	g.Expect(status.Mismatches).To(Equal(2))
+
+	g.Expect(decodeEvents(events)).To(ConsistOf(
+		daemon.Event{Kind: "mismatch", Type: "notHashed", Path: "d"},
+		daemon.Event{Kind: "mismatch", Type: "missing", Path: "a"},
+	))
+
+	var partition daemon.PartitionStatus
+	g.Expect(s.request(http.MethodGet, "/partitions/p", &partition)).To(Equal(http.StatusOK))
+
+	g.Expect(partition.LastCheckTime).To(BeNumerically(">", 0))
+	g.Expect(partition.LastCheckMismatches).To(Equal(2))
+}
+
+func Test_event_stream_resumes_after_Last_Event_ID(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	s := setupServer(t)
+	job := s.startJob("p", "hash")
+
+	all, _ := s.waitForJob(job.ID, "")
+	g.Expect(all).To(HaveLen(2))
+
+	rest, _ := s.waitForJob(job.ID, all[0].id)
+	g.Expect(rest).To(Equal(all[1:]))
+
+	req, err := http.NewRequest(http.MethodGet, s.url+"/jobs/"+job.ID+"/events", nil)
+
+	if err != nil {
+		panic(err)
+	}
+
+	req.Header.Set("Last-Event-ID", "3")
+	resp, err := s.client.Do(req)
+
+	if err != nil {
+		panic(err)
+	}
+
+	resp.Body.Close()
+	g.Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+}
+
+func Test_jobs_of_the_same_partition_run_one_after_another(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	s := setupServer(t)
+
+	// Check and scrub need a manifest when queued
+	s.waitForJob(s.startJob("p", "hash").ID, "")
+
+	ids := make([]string, 0)
+
+	for _, kind := range []string{"hash", "check", "scrub", "check"} {
+		ids = append(ids, s.startJob("p", kind).ID)
+	}
+
+	statuses := make([]daemon.JobStatus, 0)
+
+	for _, id := range ids {
+		_, status := s.waitForJob(id, "")
+		g.Expect(status.State).To(Equal(daemon.JobSucceeded))
+
+		statuses = append(statuses, status)
+	}
+
+	for i := 1; i < len(statuses); i++ {
+		g.Expect(statuses[i].StartedTime.Before(*statuses[i-1].FinishedTime)).To(BeFalse())
+	}
+
+	// Check jobs see the manifest saved by the hash job queued before them
+	g.Expect(statuses[1].Mismatches).To(Equal(0))
+
+	g.Expect(statuses[2].Scrub).To(gs.PointTo(gs.MatchFields(gs.IgnoreExtras, gs.Fields{
+		"VerifiedFiles": Equal(2),
+	})))
+
+	var jobs []daemon.JobStatus
+	g.Expect(s.request(http.MethodGet, "/jobs", &jobs)).To(Equal(http.StatusOK))
+	g.Expect(jobs).To(HaveLen(5))
+}
+
+func Test_unknown_partitions_jobs_and_job_kinds_are_not_found(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	s := setupServer(t)
+
+	g.Expect(s.request(http.MethodGet, "/partitions/nope", nil)).To(Equal(http.StatusNotFound))
+	g.Expect(s.request(http.MethodPost, "/partitions/nope/hash", nil)).To(Equal(http.StatusNotFound))
+	g.Expect(s.request(http.MethodPost, "/partitions/p/protect", nil)).To(Equal(http.StatusNotFound))
+	g.Expect(s.request(http.MethodGet, "/jobs/42", nil)).To(Equal(http.StatusNotFound))
+}
+
+func Test_job_of_unmounted_partition_is_refused(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	s := setupServer(t)
+
+	// Never hashed partition can only be hashed
+	g.Expect(s.request(http.MethodPost, "/partitions/p/check", nil)).To(Equal(http.StatusConflict))
+	g.Expect(s.request(http.MethodPost, "/partitions/p/scrub", nil)).To(Equal(http.StatusConflict))
+
+	if err := os.RemoveAll(s.partitionDir); err != nil {
+		panic(err)
+	}
+
+	g.Expect(s.request(http.MethodPost, "/partitions/p/hash", nil)).To(Equal(http.StatusConflict))
+}
+
+func Test_requests_with_non_loopback_host_are_refused(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	s := setupServer(t)
+
+	req, err := http.NewRequest(http.MethodGet, s.url+"/partitions", nil)
+
+	if err != nil {
+		panic(err)
+	}
+
+	req.Host = "attacker.example:80"
+	resp, err := s.client.Do(req)
+
+	if err != nil {
+		panic(err)
+	}
+
+	resp.Body.Close()
+	g.Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+}
+
+func Test_requests_from_web_pages_are_refused(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	s := setupServer(t)
+
+	// Like a form on another site submitted to the API
+	form := strings.NewReader("x=1")
+	req, err := http.NewRequest(http.MethodPost, s.url+"/partitions/p/hash", form)
+
+	if err != nil {
+		panic(err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://attacker.example")
+
+	resp, err := s.client.Do(req)
+
+	if err != nil {
+		panic(err)
+	}
+
+	resp.Body.Close()
+	g.Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+
+	var jobs []daemon.JobStatus
+	g.Expect(s.request(http.MethodGet, "/jobs", &jobs)).To(Equal(http.StatusOK))
+	g.Expect(jobs).To(BeEmpty())
+}
+
+func Test_ListenLocalhost_accepts_only_loopback_addresses(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	for _, address := range []string{"0.0.0.0:0", ":0", "192.0.2.1:0"} {
+		_, err := daemon.ListenLocalhost(address)
+		g.Expect(err).To(HaveOccurred(), address)
+	}
+
+	listener, err := daemon.ListenLocalhost("127.0.0.1:0")
+	g.Expect(err).ToNot(HaveOccurred())
+	listener.Close()
+}
+
+func Test_ListenUnix_serves_API_on_socket_and_replaces_stale_socket(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	socketPath := filepath.Join(t.TempDir(), "part.sock")
+
+	// Left over by a previous run
+	stale, err := daemon.ListenUnix(socketPath)
+
+	if err != nil {
+		panic(err)
+	}
+
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	listener, err := daemon.ListenUnix(socketPath)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	info, err := os.Stat(socketPath)
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(info.Mode().Perm()).To(Equal(os.FileMode(0o600)))
+
+	server := daemon.New(filepath.Join(t.TempDir(), "registry.json"))
+	defer server.Close()
+
+	go http.Serve(listener, server)
+	defer listener.Close()
+
+	client := http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _network, _address string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}
+
+	resp, err := client.Get("http://localhost/partitions")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	defer resp.Body.Close()
+	g.Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+	_, err = daemon.ListenUnix(t.TempDir())
+	g.Expect(err).To(HaveOccurred())
+}
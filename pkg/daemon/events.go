@@ -0,0 +1,121 @@
+package daemon
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+)
+
+// Event is a ManifestChange or ManifestMismatch reported by a job, as sent
+// to clients
+type Event struct {
+	// "change" or "mismatch"
+	Kind string `json:"kind"`
+
+	// Type of the change (added, modified, deleted, spuriousMtimeChange) or
+	// mismatch (notHashed, missing, sizeDoesNotMatch, hashDoesNotMatch,
+	// metadataDoesNotMatch, xattrDoesNotMatch, parityOutdated, parityDamaged)
+	Type string `json:"type"`
+
+	// Manifest path of the file. Empty for parity mismatches
+	Path string `json:"path,omitempty"`
+
+	// Keyword of metadataDoesNotMatch, e.g. mode
+	Keyword string `json:"keyword,omitempty"`
+
+	Actual   string `json:"actual,omitempty"`
+	Expected string `json:"expected,omitempty"`
+
+	// Ranges of corrupted chunks of hashDoesNotMatch, if the file is chunked
+	CorruptedRanges []ByteRange `json:"corruptedRanges,omitempty"`
+
+	// Reason of parityDamaged
+	Reason string `json:"reason,omitempty"`
+}
+
+type ByteRange struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+func changeEvent(change partition_lib.ManifestChange) Event {
+	switch c := change.(type) {
+	case partition_lib.FileAdded:
+		return Event{Kind: "change", Type: "added", Path: c.ManifestPath}
+
+	case partition_lib.FileModified:
+		return Event{Kind: "change", Type: "modified", Path: c.ManifestPath}
+
+	case partition_lib.FileDeleted:
+		return Event{Kind: "change", Type: "deleted", Path: c.ManifestPath}
+
+	case partition_lib.SpuriousMtimeChange:
+		return Event{Kind: "change", Type: "spuriousMtimeChange", Path: c.ManifestPath}
+
+	default:
+		panic(fmt.Sprintf("Unknown ManifestChange: %+v", change))
+	}
+}
+
+func mismatchEvent(mismatch partition_lib.ManifestMismatch) Event {
+	switch m := mismatch.(type) {
+	case partition_lib.FileNotHashed:
+		return Event{Kind: "mismatch", Type: "notHashed", Path: m.ManifestPath}
+
+	case partition_lib.FileMissing:
+		return Event{Kind: "mismatch", Type: "missing", Path: m.ManifestPath}
+
+	case partition_lib.SizeDoesNotMatch:
+		return Event{
+			Kind:     "mismatch",
+			Type:     "sizeDoesNotMatch",
+			Path:     m.ManifestPath,
+			Actual:   strconv.FormatInt(m.ActualSize, 10),
+			Expected: strconv.FormatInt(m.ExpectedSize, 10),
+		}
+
+	case partition_lib.HashDoesNotMatch:
+		e := Event{
+			Kind:     "mismatch",
+			Type:     "hashDoesNotMatch",
+			Path:     m.ManifestPath,
+			Actual:   m.ActualHash,
+			Expected: m.ExpectedHash,
+		}
+
+		for _, r := range m.CorruptedRanges {
+			e.CorruptedRanges = append(e.CorruptedRanges, ByteRange{Offset: r.Offset, Length: r.Length})
+		}
+
+		return e
+
+	case partition_lib.MetadataDoesNotMatch:
+		return Event{
+			Kind:     "mismatch",
+			Type:     "metadataDoesNotMatch",
+			Path:     m.ManifestPath,
+			Keyword:  m.Keyword,
+			Actual:   m.Actual,
+			Expected: m.Expected,
+		}
+
+	case partition_lib.XattrDoesNotMatch:
+		return Event{
+			Kind:     "mismatch",
+			Type:     "xattrDoesNotMatch",
+			Path:     m.ManifestPath,
+			Actual:   m.XattrHash,
+			Expected: m.ExpectedHash,
+		}
+
+	case partition_lib.ParityOutdated:
+		return Event{Kind: "mismatch", Type: "parityOutdated"}
+
+	case partition_lib.ParityDamaged:
+		return Event{Kind: "mismatch", Type: "parityDamaged", Reason: m.Reason}
+
+	default:
+		panic(fmt.Sprintf("Unknown ManifestMismatch: %+v", mismatch))
+	}
+}
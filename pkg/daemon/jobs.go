@@ -0,0 +1,359 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+	"github.com/azerum/data-storage-suite/pkg/registry"
+)
+
+type JobKind string
+
+const (
+	JobHash  JobKind = "hash"
+	JobCheck JobKind = "check"
+	JobScrub JobKind = "scrub"
+)
+
+type JobState string
+
+const (
+	// Waiting for the previous job of the same partition
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+)
+
+// JobStatus is a snapshot of a job, as sent to clients
+type JobStatus struct {
+	ID        string   `json:"id"`
+	Partition string   `json:"partition"`
+	Kind      JobKind  `json:"kind"`
+	State     JobState `json:"state"`
+
+	// Set if the job failed
+	Error string `json:"error,omitempty"`
+
+	QueuedTime   time.Time  `json:"queuedTime"`
+	StartedTime  *time.Time `json:"startedTime,omitempty"`
+	FinishedTime *time.Time `json:"finishedTime,omitempty"`
+
+	Changes    int `json:"changes"`
+	Mismatches int `json:"mismatches"`
+
+	// Set once scrub job finishes
+	Scrub *ScrubResult `json:"scrub,omitempty"`
+}
+
+type ScrubResult struct {
+	VerifiedFiles     int   `json:"verifiedFiles"`
+	VerifiedBytes     int64 `json:"verifiedBytes"`
+	RemainingDueFiles int   `json:"remainingDueFiles"`
+}
+
+type job struct {
+	// Absolute OS path of the partition dir. Jobs are serialised by it
+	partitionDir string
+
+	scrubOptions partition_lib.ScrubOptions
+
+	mutex  sync.Mutex
+	status JobStatus
+	events []Event
+
+	// Closed and replaced each time events or status change, so event
+	// streams can wait for updates
+	updated chan struct{}
+}
+
+func (j *job) snapshot() JobStatus {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	return j.status
+}
+
+func (j *job) isFinished() bool {
+	state := j.snapshot().State
+	return state == JobSucceeded || state == JobFailed
+}
+
+// Returns events after the first `from` ones, the current status, and
+// a channel closed on the next update
+func (j *job) eventsSince(from int) ([]Event, JobStatus, <-chan struct{}) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	return j.events[from:], j.status, j.updated
+}
+
+func (j *job) eventCount() int {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	return len(j.events)
+}
+
+func (j *job) update(modify func(status *JobStatus)) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	modify(&j.status)
+
+	close(j.updated)
+	j.updated = make(chan struct{})
+}
+
+func (j *job) emit(e Event) {
+	j.update(func(status *JobStatus) {
+		j.events = append(j.events, e)
+
+		if e.Kind == "change" {
+			status.Changes++
+		} else {
+			status.Mismatches++
+		}
+	})
+}
+
+// At most this many finished jobs are remembered, with their events
+const maxFinishedJobs = 100
+
+// Queues the job after other jobs of the same partition
+func (server *Server) enqueue(label string, partitionDir string, kind JobKind, scrubOptions partition_lib.ScrubOptions) (*job, error) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if server.ctx.Err() != nil {
+		return nil, errors.New("server is shutting down")
+	}
+
+	server.lastJobID++
+
+	j := &job{
+		partitionDir: partitionDir,
+		scrubOptions: scrubOptions,
+		updated:      make(chan struct{}),
+
+		status: JobStatus{
+			ID:         strconv.Itoa(server.lastJobID),
+			Partition:  label,
+			Kind:       kind,
+			State:      JobQueued,
+			QueuedTime: time.Now(),
+		},
+	}
+
+	server.jobs = append(server.jobs, j)
+
+	queue := server.queues[partitionDir]
+	server.queues[partitionDir] = append(queue, j)
+
+	if len(queue) == 0 {
+		server.start(j)
+	}
+
+	return j, nil
+}
+
+// Must be called with server.mutex held
+func (server *Server) start(j *job) {
+	server.running.Add(1)
+
+	go func() {
+		defer server.running.Done()
+
+		server.run(j)
+		server.dequeue(j)
+	}()
+}
+
+// Starts the next job of the partition, and forgets the oldest finished
+// jobs
+func (server *Server) dequeue(j *job) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	queue := server.queues[j.partitionDir][1:]
+
+	if len(queue) == 0 {
+		delete(server.queues, j.partitionDir)
+	} else {
+		server.queues[j.partitionDir] = queue
+		server.start(queue[0])
+	}
+
+	finishedCount := 0
+
+	for _, other := range server.jobs {
+		if other.isFinished() {
+			finishedCount++
+		}
+	}
+
+	kept := make([]*job, 0, len(server.jobs))
+
+	for _, other := range server.jobs {
+		if finishedCount > maxFinishedJobs && other.isFinished() {
+			finishedCount--
+			continue
+		}
+
+		kept = append(kept, other)
+	}
+
+	server.jobs = kept
+}
+
+func (server *Server) run(j *job) {
+	j.update(func(status *JobStatus) {
+		now := time.Now()
+
+		status.State = JobRunning
+		status.StartedTime = &now
+	})
+
+	var err error
+
+	kind := j.snapshot().Kind
+
+	switch kind {
+	case JobHash:
+		err = server.runHash(j)
+
+	case JobCheck:
+		err = server.runCheck(j)
+
+	case JobScrub:
+		err = server.runScrub(j)
+
+	default:
+		panic(fmt.Sprintf("Unknown JobKind: %s", kind))
+	}
+
+	j.update(func(status *JobStatus) {
+		now := time.Now()
+		status.FinishedTime = &now
+
+		if err != nil {
+			status.State = JobFailed
+			status.Error = err.Error()
+		} else {
+			status.State = JobSucceeded
+		}
+	})
+}
+
+func (server *Server) runHash(j *job) error {
+	partition, err := partition_lib.LoadPartition(j.partitionDir)
+
+	if err != nil {
+		return err
+	}
+
+	changes := partition.Hash(server.ctx)
+
+	// Hash() reads the manifest, so changes are applied once it finishes
+	changesList := make([]partition_lib.ManifestChange, 0)
+
+	for c := range changes.Channel {
+		changesList = append(changesList, c)
+		j.emit(changeEvent(c))
+	}
+
+	if changes.Err != nil {
+		return changes.Err
+	}
+
+	for _, c := range changesList {
+		partition.ApplyChange(c)
+	}
+
+	if err := partition.Save(); err != nil {
+		return err
+	}
+
+	return server.updateRegistryEntry(j.partitionDir, func(entry *registry.Entry) {
+		entry.LastHashTime = time.Now().Unix()
+	})
+}
+
+func (server *Server) runCheck(j *job) error {
+	partition, err := partition_lib.LoadPartition(j.partitionDir)
+
+	if err != nil {
+		return err
+	}
+
+	mismatches := partition.Check(server.ctx)
+	count := 0
+
+	for m := range mismatches.Channel {
+		count++
+		j.emit(mismatchEvent(m))
+	}
+
+	if mismatches.Err != nil {
+		return mismatches.Err
+	}
+
+	return server.updateRegistryEntry(j.partitionDir, func(entry *registry.Entry) {
+		entry.LastCheckTime = time.Now().Unix()
+		entry.LastCheckMismatches = count
+	})
+}
+
+func (server *Server) runScrub(j *job) error {
+	partition, err := partition_lib.LoadPartition(j.partitionDir)
+
+	if err != nil {
+		return err
+	}
+
+	mismatches, stats := partition.Scrub(server.ctx, j.scrubOptions)
+
+	for m := range mismatches.Channel {
+		j.emit(mismatchEvent(m))
+	}
+
+	if mismatches.Err != nil {
+		return mismatches.Err
+	}
+
+	j.update(func(status *JobStatus) {
+		status.Scrub = &ScrubResult{
+			VerifiedFiles:     stats.VerifiedFiles,
+			VerifiedBytes:     stats.VerifiedBytes,
+			RemainingDueFiles: stats.RemainingDueFiles,
+		}
+	})
+
+	return nil
+}
+
+// Reloads the registry, as `part` commands may have changed it since,
+// and updates the entry of the partition, if it is still registered
+func (server *Server) updateRegistryEntry(partitionDir string, modify func(entry *registry.Entry)) error {
+	server.registryMutex.Lock()
+	defer server.registryMutex.Unlock()
+
+	r, err := registry.Load(server.registryPath)
+
+	if err != nil {
+		return err
+	}
+
+	entry := r.FindByPath(partitionDir)
+
+	if entry == nil {
+		return nil
+	}
+
+	modify(entry)
+	return r.Save()
+}
@@ -0,0 +1,58 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+)
+
+// ListenUnix listens on unix socket at socketPath, accessible only to the
+// current user. Socket left over by a previous run is replaced
+func ListenUnix(socketPath string) (net.Listener, error) {
+	info, err := os.Lstat(socketPath)
+
+	switch {
+	case err == nil && info.Mode()&fs.ModeSocket != 0:
+		if err := os.Remove(socketPath); err != nil {
+			return nil, err
+		}
+
+	case err == nil:
+		return nil, fmt.Errorf("%s exists and is not a socket", socketPath)
+
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(socketPath, 0o600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+// ListenLocalhost listens on TCP address, which must be localhost or
+// a loopback IP, e.g. 127.0.0.1:8750. The API has no authentication, so it
+// must not be reachable from other machines
+func ListenLocalhost(address string) (net.Listener, error) {
+	host, _, err := net.SplitHostPort(address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !isLoopbackHost(host) {
+		return nil, fmt.Errorf("%s is not a loopback address", address)
+	}
+
+	return net.Listen("tcp", address)
+}
@@ -0,0 +1,419 @@
+// Package daemon implements `part serve`: a local HTTP/JSON API that
+// reports status of registered partitions and runs hash, check and scrub
+// jobs on them, streaming their events as server-sent events
+//
+// Endpoints:
+//
+//	GET  /partitions                  - registered partitions with their status
+//	GET  /partitions/{label}          - one partition
+//	POST /partitions/{label}/{kind}   - queue hash, check or scrub job. Scrub
+//	                                    accepts ?interval=, ?max-bytes= and
+//	                                    ?max-duration=
+//	GET  /jobs                        - remembered jobs, oldest first
+//	GET  /jobs/{id}                   - one job
+//	GET  /jobs/{id}/events            - SSE stream of job events: past ones,
+//	                                    then new ones as they happen. Ends with
+//	                                    an "end" event carrying job status
+//
+// Jobs of the same partition run one at a time, in the order they were
+// queued. Jobs of different partitions run concurrently. Check and scrub
+// need a manifest, while hash needs only the directory, so the first hash
+// of a new partition can be run
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+	"github.com/azerum/data-storage-suite/pkg/registry"
+)
+
+type Server struct {
+	registryPath string
+	mux          *http.ServeMux
+
+	// Cancelled by Close(), cancels running jobs
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	running sync.WaitGroup
+
+	// Guards fields below
+	mutex     sync.Mutex
+	lastJobID int
+
+	// Remembered jobs, oldest first
+	jobs []*job
+
+	// Queued and running jobs by partition dir. The first job of each
+	// queue is running
+	queues map[string][]*job
+
+	// Serialises load-modify-save of the registry file by jobs
+	registryMutex sync.Mutex
+}
+
+// PartitionStatus is a registered partition, as sent to clients
+type PartitionStatus struct {
+	registry.Entry
+
+	Mounted bool `json:"mounted"`
+
+	// IDs of queued and running jobs of the partition, the running one first
+	ActiveJobs []string `json:"activeJobs"`
+}
+
+// New creates server for partitions registered in the registry file at
+// registryPath. The file is re-read on each request, so partitions added
+// or removed while the server runs are picked up
+func New(registryPath string) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	server := &Server{
+		registryPath: registryPath,
+		mux:          http.NewServeMux(),
+		ctx:          ctx,
+		cancel:       cancel,
+		jobs:         make([]*job, 0),
+		queues:       make(map[string][]*job),
+	}
+
+	server.mux.HandleFunc("GET /partitions", server.listPartitions)
+	server.mux.HandleFunc("GET /partitions/{label}", server.getPartition)
+	server.mux.HandleFunc("POST /partitions/{label}/{kind}", server.startJob)
+	server.mux.HandleFunc("GET /jobs", server.listJobs)
+	server.mux.HandleFunc("GET /jobs/{id}", server.getJob)
+	server.mux.HandleFunc("GET /jobs/{id}/events", server.streamJobEvents)
+
+	return server
+}
+
+// Cancels running jobs and waits for them to finish. Queued jobs fail
+// once their turn comes
+func (server *Server) Close() {
+	server.mutex.Lock()
+	server.cancel()
+	server.mutex.Unlock()
+
+	server.running.Wait()
+}
+
+// Requests with Host other than localhost or a loopback IP are refused, so
+// web pages cannot reach the API via DNS rebinding. Requests with Origin
+// are refused too: browsers send it with cross-site requests (e.g. form
+// POSTs, which pass the Host check), while API clients do not
+func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !isLoopbackHost(r.Host) {
+		writeError(w, http.StatusForbidden, fmt.Errorf("host %s is not allowed", r.Host))
+		return
+	}
+
+	if origin := r.Header.Get("Origin"); origin != "" {
+		writeError(w, http.StatusForbidden, fmt.Errorf("requests from browsers (origin %s) are not allowed", origin))
+		return
+	}
+
+	server.mux.ServeHTTP(w, r)
+}
+
+func isLoopbackHost(hostPort string) bool {
+	host, _, err := net.SplitHostPort(hostPort)
+
+	if err != nil {
+		host = hostPort
+	}
+
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (server *Server) listPartitions(w http.ResponseWriter, r *http.Request) {
+	reg, err := registry.Load(server.registryPath)
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	statuses := make([]PartitionStatus, 0, len(reg.Partitions))
+
+	for _, e := range reg.Partitions {
+		statuses = append(statuses, server.partitionStatus(e))
+	}
+
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+func (server *Server) getPartition(w http.ResponseWriter, r *http.Request) {
+	entry, ok := server.findPartition(w, r.PathValue("label"))
+
+	if ok {
+		writeJSON(w, http.StatusOK, server.partitionStatus(entry))
+	}
+}
+
+// Writes 404 if there is no such registered partition
+func (server *Server) findPartition(w http.ResponseWriter, label string) (*registry.Entry, bool) {
+	reg, err := registry.Load(server.registryPath)
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return nil, false
+	}
+
+	for _, e := range reg.Partitions {
+		if e.Label == label {
+			return e, true
+		}
+	}
+
+	writeError(w, http.StatusNotFound, fmt.Errorf("partition %s is not registered", label))
+	return nil, false
+}
+
+func (server *Server) partitionStatus(entry *registry.Entry) PartitionStatus {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	activeJobs := make([]string, 0)
+
+	for _, j := range server.queues[entry.Path] {
+		activeJobs = append(activeJobs, j.snapshot().ID)
+	}
+
+	return PartitionStatus{
+		Entry:      *entry,
+		Mounted:    entry.IsMounted(),
+		ActiveJobs: activeJobs,
+	}
+}
+
+func (server *Server) startJob(w http.ResponseWriter, r *http.Request) {
+	kind := JobKind(r.PathValue("kind"))
+
+	if kind != JobHash && kind != JobCheck && kind != JobScrub {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown job kind %s", kind))
+		return
+	}
+
+	scrubOptions, err := parseScrubOptions(r)
+
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	entry, ok := server.findPartition(w, r.PathValue("label"))
+
+	if !ok {
+		return
+	}
+
+	// First hash of a new partition creates its manifest, so hash needs
+	// only the directory
+	if kind == JobHash {
+		if info, err := os.Stat(entry.Path); err != nil || !info.IsDir() {
+			writeError(w, http.StatusConflict, fmt.Errorf("%s is not a directory", entry.Path))
+			return
+		}
+	} else if !entry.IsMounted() {
+		writeError(w, http.StatusConflict, fmt.Errorf("%s is not mounted (has no manifest)", entry.Path))
+		return
+	}
+
+	j, err := server.enqueue(entry.Label, entry.Path, kind, scrubOptions)
+
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	status := j.snapshot()
+
+	w.Header().Set("Location", "/jobs/"+status.ID)
+	writeJSON(w, http.StatusAccepted, status)
+}
+
+// Defaults match `part scrub`
+func parseScrubOptions(r *http.Request) (partition_lib.ScrubOptions, error) {
+	options := partition_lib.ScrubOptions{
+		Interval: 90 * 24 * time.Hour,
+	}
+
+	query := r.URL.Query()
+
+	if s := query.Get("interval"); s != "" {
+		d, err := time.ParseDuration(s)
+
+		if err != nil {
+			return options, err
+		}
+
+		options.Interval = d
+	}
+
+	if s := query.Get("max-bytes"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+
+		if err != nil {
+			return options, err
+		}
+
+		options.MaxBytes = n
+	}
+
+	if s := query.Get("max-duration"); s != "" {
+		d, err := time.ParseDuration(s)
+
+		if err != nil {
+			return options, err
+		}
+
+		options.MaxDuration = d
+	}
+
+	return options, nil
+}
+
+func (server *Server) listJobs(w http.ResponseWriter, r *http.Request) {
+	server.mutex.Lock()
+	jobs := append([]*job(nil), server.jobs...)
+	server.mutex.Unlock()
+
+	statuses := make([]JobStatus, 0, len(jobs))
+
+	for _, j := range jobs {
+		statuses = append(statuses, j.snapshot())
+	}
+
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+func (server *Server) getJob(w http.ResponseWriter, r *http.Request) {
+	j, ok := server.findJob(w, r.PathValue("id"))
+
+	if ok {
+		writeJSON(w, http.StatusOK, j.snapshot())
+	}
+}
+
+// Writes 404 if there is no such job, or it was forgotten
+func (server *Server) findJob(w http.ResponseWriter, id string) (*job, bool) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	for _, j := range server.jobs {
+		if j.snapshot().ID == id {
+			return j, true
+		}
+	}
+
+	writeError(w, http.StatusNotFound, fmt.Errorf("job %s does not exist", id))
+	return nil, false
+}
+
+// Each event has id - its 1-based number, so clients that reconnect with
+// Last-Event-ID get only events they have not seen
+func (server *Server) streamJobEvents(w http.ResponseWriter, r *http.Request) {
+	j, ok := server.findJob(w, r.PathValue("id"))
+
+	if !ok {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming is not supported"))
+		return
+	}
+
+	sent := 0
+
+	if s := r.Header.Get("Last-Event-ID"); s != "" {
+		n, err := strconv.Atoi(s)
+
+		// Ids are positions, so an id past the end was not sent by this
+		// server
+		if err != nil || n < 0 || n > j.eventCount() {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid Last-Event-ID %s", s))
+			return
+		}
+
+		sent = n
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	for {
+		events, status, updated := j.eventsSince(sent)
+
+		for _, e := range events {
+			sent++
+
+			if err := writeEvent(w, strconv.Itoa(sent), e.Kind, e); err != nil {
+				return
+			}
+		}
+
+		if status.State == JobSucceeded || status.State == JobFailed {
+			_ = writeEvent(w, "", "end", status)
+			flusher.Flush()
+			return
+		}
+
+		flusher.Flush()
+
+		select {
+		case <-updated:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, id string, name string, data any) error {
+	bytes, err := json.Marshal(data)
+
+	if err != nil {
+		return err
+	}
+
+	if id != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, bytes)
+	return err
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, statusCode int, err error) {
+	writeJSON(w, statusCode, errorResponse{Error: err.Error()})
+}
@@ -0,0 +1,487 @@
+package partition_lib
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/azerum/data-storage-suite/pkg/utils"
+)
+
+// BagIt (RFC 8493) support. Payload is in data/ of the bag. Manifests list
+// `<checksum> <path>` lines, where path is relative to the bag dir
+
+const bagPayloadDir = "data"
+const bagDeclarationFileName = "bagit.txt"
+const bagInfoFileName = "bag-info.txt"
+
+// Payload manifest algorithms, in order of preference for validation
+var bagAlgorithms = []string{"sha512", "sha256", "sha1", "md5"}
+
+// CreateBag copies the partition files into data/ of a new bag at bagDir,
+// and writes bagit.txt, bag-info.txt, SHA-256 & SHA-1 payload manifests and
+// tag manifests
+//
+// Each file is verified against the partition manifest while being copied.
+// If any does not match, CreateBag fails, leaving incomplete bag behind.
+// bagDir must not exist or must be empty
+func (partition *Partition) CreateBag(ctx context.Context, bagDir string) error {
+	if partition.manifest == nil {
+		return fmt.Errorf("partition %s has no manifest", partition.AbsoluteDirOsPath)
+	}
+
+	entries, err := os.ReadDir(bagDir)
+
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if len(entries) > 0 {
+		return fmt.Errorf("%s is not empty", bagDir)
+	}
+
+	sha256Lines := make([]string, 0, len(partition.manifest.Files))
+	sha1Lines := make([]string, 0, len(partition.manifest.Files))
+	payloadBytes := int64(0)
+
+	for _, manifestPath := range sortedKeys(partition.manifest.Files) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		entry := partition.manifest.Files[manifestPath]
+		bagPath := bagPayloadDir + "/" + manifestPath
+
+		dstPath := filepath.Join(bagDir, filepath.FromSlash(bagPath))
+		sha256Hash, size, err := partition.copyToBag(manifestPath, entry, dstPath)
+
+		if errors.Is(err, errCopiedHashMismatch) {
+			return fmt.Errorf("%s does not match the manifest", manifestPath)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		sha256Lines = append(sha256Lines, sha256Hash+"  "+encodeBagPath(bagPath))
+		sha1Lines = append(sha1Lines, entry.Hash+"  "+encodeBagPath(bagPath))
+		payloadBytes += size
+	}
+
+	tagFiles := []struct {
+		name  string
+		lines []string
+	}{
+		{bagDeclarationFileName, []string{"BagIt-Version: 1.0", "Tag-File-Character-Encoding: UTF-8"}},
+		{"manifest-sha256.txt", sha256Lines},
+		{"manifest-sha1.txt", sha1Lines},
+		{bagInfoFileName, []string{
+			"Bagging-Date: " + time.Now().Format(time.DateOnly),
+			fmt.Sprintf("Payload-Oxum: %d.%d", payloadBytes, len(sha1Lines)),
+			"Bag-Software-Agent: part",
+		}},
+	}
+
+	tagSha256Lines := make([]string, 0, len(tagFiles))
+	tagSha1Lines := make([]string, 0, len(tagFiles))
+
+	for _, tagFile := range tagFiles {
+		contents := joinLines(tagFile.lines)
+
+		if err := os.WriteFile(filepath.Join(bagDir, tagFile.name), contents, 0o644); err != nil {
+			return err
+		}
+
+		tagSha256Lines = append(tagSha256Lines, fmt.Sprintf("%x  %s", sha256.Sum256(contents), tagFile.name))
+		tagSha1Lines = append(tagSha1Lines, fmt.Sprintf("%x  %s", sha1.Sum(contents), tagFile.name))
+	}
+
+	err = os.WriteFile(filepath.Join(bagDir, "tagmanifest-sha256.txt"), joinLines(tagSha256Lines), 0o644)
+
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(bagDir, "tagmanifest-sha1.txt"), joinLines(tagSha1Lines), 0o644)
+}
+
+func joinLines(lines []string) []byte {
+	if len(lines) == 0 {
+		return []byte{}
+	}
+
+	return ([]byte)(strings.Join(lines, "\n") + "\n")
+}
+
+// Returns SHA-256 and size of the copied file
+func (partition *Partition) copyToBag(manifestPath string, entry *fileEntry, dstPath string) (string, int64, error) {
+	src, err := os.Open(partition.toAbsoluteOsPath(manifestPath))
+
+	if err != nil {
+		return "", 0, err
+	}
+
+	defer src.Close()
+
+	info, err := src.Stat()
+
+	if err != nil {
+		return "", 0, err
+	}
+
+	sha256Hasher := sha256.New()
+	size := int64(0)
+
+	write := func(w io.Writer) error {
+		n, err := io.Copy(io.MultiWriter(w, sha256Hasher), src)
+		size = n
+
+		return err
+	}
+
+	err = installVerified(dstPath, write, entry.Hash, entry.Mtime, info.Mode().Perm())
+
+	if err != nil {
+		return "", 0, err
+	}
+
+	return fmt.Sprintf("%x", sha256Hasher.Sum(nil)), size, nil
+}
+
+// ValidateBag checks the bag at bagDir the way Check() checks a partition:
+// payload files not in the payload manifest are FileNotHashed, files listed
+// but absent are FileMissing, files (including tag files) whose checksum
+// differs are HashDoesNotMatch. Paths are relative to the bag dir, e.g.
+// data/a.jpg
+//
+// Every file in data/ is payload, including names a partition ignores and
+// nested partitions. Payload-Oxum of bag-info.txt, if present, is compared
+// with the actual payload size and file count (MetadataDoesNotMatch)
+//
+// All payload and tag manifests present are checked. Listed paths outside
+// of the bag are FileMissing, and are never opened. Fails if the bag has
+// no bagit.txt or no payload manifest
+func ValidateBag(ctx context.Context, bagDir string) *utils.ChanWithError[ManifestMismatch] {
+	out := utils.NewChanWithError[ManifestMismatch](1)
+	go validateBagWorker(bagDir, out, ctx)
+
+	return out
+}
+
+func validateBagWorker(bagDir string, out *utils.ChanWithError[ManifestMismatch], ctx context.Context) {
+	if _, err := os.Stat(filepath.Join(bagDir, bagDeclarationFileName)); err != nil {
+		out.CloseWithError(errors.Join(fmt.Errorf("%s is not a bag", bagDir), err))
+		return
+	}
+
+	payload, err := readBagManifests(bagDir, "manifest-")
+
+	if err != nil {
+		out.CloseWithError(err)
+		return
+	}
+
+	if len(payload) == 0 {
+		out.CloseWithError(fmt.Errorf("bag %s has no payload manifest", bagDir))
+		return
+	}
+
+	tags, err := readBagManifests(bagDir, "tagmanifest-")
+
+	if err != nil {
+		out.CloseWithError(err)
+		return
+	}
+
+	bag := Partition{AbsoluteDirOsPath: bagDir, files: NewOsFileSystem(bagDir)}
+	seenInPayload := make(map[string]struct{})
+	payloadBytes := int64(0)
+
+	// Not walkSubtree(): it skips files a partition ignores, which are
+	// still payload of a bag
+	walk := func(absoluteOsPath string, entry fs.DirEntry, err error) error {
+		// Bag without data/ has missing payload, reported below
+		if errors.Is(err, fs.ErrNotExist) && entry == nil {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			return nil
+		}
+
+		info, err := entry.Info()
+
+		if err != nil {
+			return err
+		}
+
+		manifestPath, err := toManifestPath(bagDir, absoluteOsPath)
+
+		if err != nil {
+			return err
+		}
+
+		seenInPayload[manifestPath] = struct{}{}
+		payloadBytes += info.Size()
+
+		mismatch, found, err := verifyBagFile(absoluteOsPath, manifestPath, payload)
+
+		if err != nil {
+			return err
+		}
+
+		if !found {
+			out.Channel <- FileNotHashed{ManifestPath: manifestPath}
+			return nil
+		}
+
+		if mismatch != nil {
+			out.Channel <- *mismatch
+		}
+
+		return nil
+	}
+
+	if err := filepath.WalkDir(filepath.Join(bagDir, bagPayloadDir), walk); err != nil {
+		out.CloseWithError(err)
+		return
+	}
+
+	for _, manifestPath := range bagManifestPaths(payload) {
+		if _, seen := seenInPayload[manifestPath]; !seen {
+			out.Channel <- FileMissing{ManifestPath: manifestPath}
+		}
+	}
+
+	expectedOxum, err := readBagInfoValue(bagDir, "Payload-Oxum")
+
+	if err != nil {
+		out.CloseWithError(err)
+		return
+	}
+
+	actualOxum := fmt.Sprintf("%d.%d", payloadBytes, len(seenInPayload))
+
+	if expectedOxum != "" && expectedOxum != actualOxum {
+		out.Channel <- MetadataDoesNotMatch{
+			ManifestPath: bagInfoFileName,
+			Keyword:      "Payload-Oxum",
+			Actual:       actualOxum,
+			Expected:     expectedOxum,
+		}
+	}
+
+	for _, manifestPath := range bagManifestPaths(tags) {
+		if err := ctx.Err(); err != nil {
+			out.CloseWithError(err)
+			return
+		}
+
+		// Hostile bag may list files outside of it, such as ../../etc/passwd.
+		// They are not in the bag, so they are missing. Payload paths are
+		// safe, as only files found in data/ are opened
+		if !fs.ValidPath(manifestPath) {
+			out.Channel <- FileMissing{ManifestPath: manifestPath}
+			continue
+		}
+
+		mismatch, _, err := verifyBagFile(bag.toAbsoluteOsPath(manifestPath), manifestPath, tags)
+
+		if errors.Is(err, os.ErrNotExist) {
+			out.Channel <- FileMissing{ManifestPath: manifestPath}
+			continue
+		}
+
+		if err != nil {
+			out.CloseWithError(err)
+			return
+		}
+
+		if mismatch != nil {
+			out.Channel <- *mismatch
+		}
+	}
+
+	out.CloseOk()
+}
+
+// Returns value of the first bag-info.txt line with the label, or "" if
+// there is no such line or no bag-info.txt. Labels are case-insensitive
+func readBagInfoValue(bagDir string, label string) (string, error) {
+	file, err := os.Open(filepath.Join(bagDir, bagInfoFileName))
+
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		l, value, found := strings.Cut(scanner.Text(), ":")
+
+		if found && strings.EqualFold(strings.TrimSpace(l), label) {
+			return strings.TrimSpace(value), nil
+		}
+	}
+
+	return "", scanner.Err()
+}
+
+// Maps algorithm to path to checksum
+type bagManifests map[string]map[string]string
+
+func readBagManifests(bagDir string, prefix string) (bagManifests, error) {
+	manifests := make(bagManifests)
+
+	for _, algorithm := range bagAlgorithms {
+		filePath := filepath.Join(bagDir, prefix+algorithm+".txt")
+		file, err := os.Open(filePath)
+
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		checksums, err := parseBagManifest(file)
+		_ = file.Close()
+
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("failed to parse %s", filePath), err)
+		}
+
+		manifests[algorithm] = checksums
+	}
+
+	return manifests, nil
+}
+
+func parseBagManifest(r io.Reader) (map[string]string, error) {
+	checksums := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		checksum, p, found := strings.Cut(line, " ")
+
+		if !found {
+			return nil, fmt.Errorf("cannot parse line %q", line)
+		}
+
+		p = strings.TrimLeft(p, " \t")
+
+		// Some tools prefix paths with * like sha1sum does
+		p = strings.TrimPrefix(p, "*")
+
+		checksums[decodeBagPath(p)] = strings.ToLower(checksum)
+	}
+
+	return checksums, scanner.Err()
+}
+
+func bagManifestPaths(manifests bagManifests) []string {
+	paths := make([]string, 0)
+
+	for _, checksums := range manifests {
+		for p := range checksums {
+			paths = append(paths, p)
+		}
+	}
+
+	slices.Sort(paths)
+	return slices.Compact(paths)
+}
+
+// Hashes file once with all algorithms of manifests that list it. Reports
+// mismatch of the first algorithm that differs
+func verifyBagFile(absoluteOsPath string, manifestPath string, manifests bagManifests) (*HashDoesNotMatch, bool, error) {
+	hashers := make(map[string]hash.Hash)
+	writers := make([]io.Writer, 0)
+
+	for algorithm, checksums := range manifests {
+		if _, listed := checksums[manifestPath]; listed {
+			hashers[algorithm] = newChecksumHasher(algorithm)
+			writers = append(writers, hashers[algorithm])
+		}
+	}
+
+	if len(hashers) == 0 {
+		return nil, false, nil
+	}
+
+	file, err := os.Open(absoluteOsPath)
+
+	if err != nil {
+		return nil, true, err
+	}
+
+	defer file.Close()
+
+	if _, err := io.Copy(io.MultiWriter(writers...), file); err != nil {
+		return nil, true, err
+	}
+
+	for _, algorithm := range bagAlgorithms {
+		hasher := hashers[algorithm]
+
+		if hasher == nil {
+			continue
+		}
+
+		actual := fmt.Sprintf("%x", hasher.Sum(nil))
+		expected := manifests[algorithm][manifestPath]
+
+		if actual != expected {
+			mismatch := HashDoesNotMatch{
+				ManifestPath: manifestPath,
+				ActualHash:   actual,
+				ExpectedHash: expected,
+			}
+
+			return &mismatch, true, nil
+		}
+	}
+
+	return nil, true, nil
+}
+
+// RFC 8493 requires percent-encoding of CR, LF and % in manifest paths
+func encodeBagPath(p string) string {
+	return strings.NewReplacer("%", "%25", "\n", "%0A", "\r", "%0D").Replace(p)
+}
+
+func decodeBagPath(p string) string {
+	return strings.NewReplacer("%25", "%", "%0A", "\n", "%0a", "\n", "%0D", "\r", "%0d", "\r").Replace(p)
+}
@@ -8,15 +8,29 @@ import (
 	"github.com/azerum/data-storage-suite/pkg/utils"
 )
 
+type CheckOptions struct {
+	// For chunked files, stop reading the file at the first corrupted
+	// chunk. See ChunkingOptions
+	StopAtFirstBadChunk bool
+}
+
 func (partition *Partition) Check(ctx context.Context) *utils.ChanWithError[ManifestMismatch] {
+	return partition.CheckWithOptions(ctx, CheckOptions{})
+}
+
+func (partition *Partition) CheckWithOptions(
+	ctx context.Context,
+	options CheckOptions,
+) *utils.ChanWithError[ManifestMismatch] {
 	out := utils.NewChanWithError[ManifestMismatch](1)
-	go checkWorker(partition, out, ctx)
+	go checkWorker(partition, options, out, ctx)
 
 	return out
 }
 
 func checkWorker(
 	partition *Partition,
+	options CheckOptions,
 	out *utils.ChanWithError[ManifestMismatch],
 	ctx context.Context,
 ) {
@@ -30,7 +44,7 @@ func checkWorker(
 
 	seenInPartition := make(map[string]struct{})
 
-	walk := func(absoluteOsPath string, manifestPath string, _entry fs.DirEntry) error {
+	walk := func(_absoluteOsPath string, manifestPath string, _entry fs.DirEntry) error {
 		err := ctx.Err()
 
 		if err != nil {
@@ -46,18 +60,29 @@ func checkWorker(
 			return nil
 		}
 
-		hash, err := HashFile(absoluteOsPath)
+		mismatch, err := verifyFile(
+			partition,
+			manifestPath,
+			manifestEntry,
+			options.StopAtFirstBadChunk,
+		)
+
+		if err != nil {
+			return err
+		}
+
+		if mismatch != nil {
+			out.Channel <- *mismatch
+		}
+
+		xattrMismatch, err := partition.checkHashXattr(manifestPath, manifestEntry)
 
 		if err != nil {
 			return err
 		}
 
-		if hash != manifestEntry.Hash {
-			out.Channel <- HashDoesNotMatch{
-				ManifestPath: manifestPath,
-				ActualHash:   hash,
-				ExpectedHash: manifestEntry.Hash,
-			}
+		if xattrMismatch != nil {
+			out.Channel <- xattrMismatch
 		}
 
 		return nil
@@ -78,6 +103,11 @@ func checkWorker(
 		}
 	}
 
+	if err := partition.checkParity(ctx, out.Channel); err != nil {
+		out.CloseWithError(err)
+		return
+	}
+
 	out.CloseOk()
 }
 
@@ -99,8 +129,15 @@ func (m FileNotHashed) isManifestMismatch() {}
 
 type HashDoesNotMatch struct {
 	ManifestPath string
-	ActualHash   string
+
+	// Empty if the file was not read completely, see
+	// CheckOptions.StopAtFirstBadChunk
+	ActualHash string
+
 	ExpectedHash string
+
+	// Ranges of corrupted chunks. nil if the file is not chunked
+	CorruptedRanges []ByteRange
 }
 
 func (m HashDoesNotMatch) isManifestMismatch() {}
@@ -0,0 +1,156 @@
+package partition_lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+
+	"github.com/azerum/data-storage-suite/pkg/utils"
+)
+
+// CheckPaths is like Check(), but hashes only files with given manifest
+// paths. Reports FileNotHashed, FileMissing and HashDoesNotMatch
+//
+// Path that is neither in the manifest nor in the partition is an error.
+// So are paths Check() does not cover: outside the partition, ignored
+// names and files of nested partitions. All paths are validated before any
+// file is checked
+func (partition *Partition) CheckPaths(
+	ctx context.Context,
+	manifestPaths []string,
+) *utils.ChanWithError[ManifestMismatch] {
+	out := utils.NewChanWithError[ManifestMismatch](1)
+	go checkPathsWorker(partition, manifestPaths, out, ctx)
+
+	return out
+}
+
+func checkPathsWorker(
+	partition *Partition,
+	manifestPaths []string,
+	out *utils.ChanWithError[ManifestMismatch],
+	ctx context.Context,
+) {
+	if partition.manifest == nil {
+		out.CloseWithError(
+			fmt.Errorf("partition %s has no manifest", partition.AbsoluteDirOsPath),
+		)
+
+		return
+	}
+
+	cleanedPaths := make([]string, 0, len(manifestPaths))
+
+	for _, manifestPath := range manifestPaths {
+		cleaned, err := cleanSubtreePath(manifestPath)
+
+		if err != nil {
+			out.CloseWithError(err)
+			return
+		}
+
+		if cleaned == "" {
+			out.CloseWithError(fmt.Errorf("%s is the partition itself, not a file", manifestPath))
+			return
+		}
+
+		// Check() never reports such files, neither should this
+		if isIgnoredFile(cleaned) {
+			out.CloseWithError(fmt.Errorf("%s is not part of the partition (ignored file name)", manifestPath))
+			return
+		}
+
+		nested, err := partition.nestedPartitionOf(cleaned)
+
+		if err != nil {
+			out.CloseWithError(err)
+			return
+		}
+
+		if nested != "" {
+			out.CloseWithError(fmt.Errorf("%s is in nested partition %s", manifestPath, nested))
+			return
+		}
+
+		cleanedPaths = append(cleanedPaths, cleaned)
+	}
+
+	for _, manifestPath := range cleanedPaths {
+		if err := ctx.Err(); err != nil {
+			out.CloseWithError(err)
+			return
+		}
+
+		mismatch, err := partition.checkPath(manifestPath)
+
+		if err != nil {
+			out.CloseWithError(err)
+			return
+		}
+
+		if mismatch != nil {
+			out.Channel <- mismatch
+		}
+	}
+
+	out.CloseOk()
+}
+
+func (partition *Partition) checkPath(manifestPath string) (ManifestMismatch, error) {
+	entry := partition.manifest.Files[manifestPath]
+	info, err := partition.files.Stat(manifestPath)
+
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	exists := err == nil && info.Mode().IsRegular()
+
+	if entry == nil {
+		if !exists {
+			return nil, fmt.Errorf("%s is neither in the manifest nor in the partition", manifestPath)
+		}
+
+		return FileNotHashed{ManifestPath: manifestPath}, nil
+	}
+
+	if !exists {
+		return FileMissing{ManifestPath: manifestPath}, nil
+	}
+
+	mismatch, err := verifyFile(partition, manifestPath, entry, false)
+
+	if err != nil || mismatch == nil {
+		return nil, err
+	}
+
+	return *mismatch, nil
+}
+
+// ManifestPathsMatching returns sorted manifest paths that match the
+// pattern, in path.Match() syntax. `*` does not match `/`, so e.g. `2024/*`
+// matches only files directly in 2024
+func (partition *Partition) ManifestPathsMatching(pattern string) ([]string, error) {
+	if partition.manifest == nil {
+		return nil, fmt.Errorf("partition %s has no manifest", partition.AbsoluteDirOsPath)
+	}
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	matching := make([]string, 0)
+
+	for p := range partition.manifest.Files {
+		// Error is checked above
+		if matches, _ := path.Match(pattern, p); matches {
+			matching = append(matching, p)
+		}
+	}
+
+	slices.Sort(matching)
+	return matching, nil
+}
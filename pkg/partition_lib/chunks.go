@@ -0,0 +1,332 @@
+package partition_lib
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// Large files may additionally be hashed in fixed-size chunks. If such file
+// gets corrupted, chunk hashes tell which byte ranges are affected, so only
+// those need to be restored
+type ChunkingOptions struct {
+	ChunkSize int64 `json:"chunkSize"`
+
+	// Files smaller than this are not chunked
+	MinFileSize int64 `json:"minFileSize"`
+}
+
+type chunkHashes struct {
+	// Chunk size at the moment of hashing. Kept per file, so changing
+	// ChunkingOptions does not invalidate existing entries
+	ChunkSize int64 `json:"chunkSize"`
+
+	Hashes []string `json:"hashes"`
+}
+
+// Half-open range [Offset, Offset + Length) of bytes in a file
+type ByteRange struct {
+	Offset int64
+	Length int64
+}
+
+func (options *ChunkingOptions) validate() error {
+	if options.ChunkSize <= 0 {
+		return errors.New(".chunkSize must be > 0")
+	}
+
+	if options.MinFileSize < 0 {
+		return errors.New(".minFileSize must be >= 0")
+	}
+
+	return nil
+}
+
+// Enables chunk hashing for files hashed from now on, or disables it if
+// options is nil. Existing entries get (re)chunked on the next Hash()
+func (partition *Partition) SetChunking(options *ChunkingOptions) error {
+	if options != nil {
+		if err := options.validate(); err != nil {
+			return err
+		}
+	}
+
+	if partition.manifest == nil {
+		partition.manifest = &manifest{
+			Files: make(map[string]*fileEntry),
+		}
+	}
+
+	partition.manifest.Chunking = options
+	return nil
+}
+
+// Whether file of given size should be chunked, but its entry has no
+// chunk hashes, or has hashes of chunks of different size
+func (manifest *manifest) needsRechunking(entry *fileEntry, size int64) bool {
+	if !manifest.shouldChunk(size) {
+		return false
+	}
+
+	return entry.Chunks == nil || entry.Chunks.ChunkSize != manifest.Chunking.ChunkSize
+}
+
+func (manifest *manifest) shouldChunk(size int64) bool {
+	return manifest.Chunking != nil && size >= manifest.Chunking.MinFileSize
+}
+
+// Hashes file the way its manifest entry should be hashed. Returned
+// chunks are nil if file should not be chunked
+func (partition *Partition) hashFile(manifestPath string, size int64) (string, *chunkHashes, error) {
+	manifest := partition.manifest
+
+	if !manifest.shouldChunk(size) {
+		if stored, ok := partition.files.(StoredSha1FileSystem); ok {
+			hash, err := stored.StoredSha1(manifestPath)
+
+			if err != nil || hash != "" {
+				return hash, nil, err
+			}
+		}
+
+		hasher := sha1.New()
+
+		if err := partition.copyFileTo(hasher, manifestPath); err != nil {
+			return "", nil, err
+		}
+
+		return fmt.Sprintf("%x", hasher.Sum(nil)), nil, nil
+	}
+
+	hasher := newChunkingHasher(manifest.Chunking.ChunkSize)
+
+	if err := partition.copyFileTo(hasher, manifestPath); err != nil {
+		return "", nil, err
+	}
+
+	chunks := chunkHashes{
+		ChunkSize: manifest.Chunking.ChunkSize,
+		Hashes:    hasher.finish(),
+	}
+
+	return hasher.sum(), &chunks, nil
+}
+
+// Compares file contents with manifest entry. Returns nil if contents
+// matches. For chunked entries, mismatch includes corrupted byte ranges
+//
+// If stopAtFirstBadChunk is set, stops reading chunked file at the first
+// corrupted chunk. Mismatch will then have no ActualHash and will have only
+// the first corrupted range
+func verifyFile(
+	partition *Partition,
+	manifestPath string,
+	entry *fileEntry,
+	stopAtFirstBadChunk bool,
+) (*HashDoesNotMatch, error) {
+	if entry.Chunks == nil {
+		hasher := sha1.New()
+
+		if err := partition.copyFileTo(hasher, manifestPath); err != nil {
+			return nil, err
+		}
+
+		hash := fmt.Sprintf("%x", hasher.Sum(nil))
+
+		if hash == entry.Hash {
+			return nil, nil
+		}
+
+		mismatch := HashDoesNotMatch{
+			ManifestPath: manifestPath,
+			ActualHash:   hash,
+			ExpectedHash: entry.Hash,
+		}
+
+		return &mismatch, nil
+	}
+
+	hasher := newVerifyingChunkingHasher(entry, stopAtFirstBadChunk)
+	err := partition.copyFileTo(hasher, manifestPath)
+
+	if errors.Is(err, errBadChunk) {
+		mismatch := HashDoesNotMatch{
+			ManifestPath:    manifestPath,
+			ExpectedHash:    entry.Hash,
+			CorruptedRanges: hasher.corruptedRanges,
+		}
+
+		return &mismatch, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	hasher.finish()
+	actualHash := hasher.sum()
+
+	if actualHash == entry.Hash {
+		return nil, nil
+	}
+
+	mismatch := HashDoesNotMatch{
+		ManifestPath:    manifestPath,
+		ActualHash:      actualHash,
+		ExpectedHash:    entry.Hash,
+		CorruptedRanges: hasher.corruptedRanges,
+	}
+
+	return &mismatch, nil
+}
+
+func (partition *Partition) copyFileTo(w io.Writer, manifestPath string) error {
+	file, err := partition.files.Open(manifestPath)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	_, err = io.Copy(w, file)
+	return err
+}
+
+var errBadChunk = errors.New("chunk hash does not match")
+
+// Computes hash of the whole file and hashes of its chunks in one pass. If
+// expected chunk hashes are given, records ranges of chunks that do not
+// match them
+type chunkingHasher struct {
+	chunkSize int64
+
+	whole hash.Hash
+	chunk hash.Hash
+
+	// Bytes written to the current chunk
+	chunkWritten int64
+
+	// Total bytes written
+	written int64
+
+	chunkHashes []string
+
+	// nil when only computing hashes, not verifying them
+	expectedChunkHashes []string
+	expectedSize        int64
+	corruptedRanges     []ByteRange
+	stopAtFirstBadChunk bool
+}
+
+func newChunkingHasher(chunkSize int64) *chunkingHasher {
+	return &chunkingHasher{
+		chunkSize:   chunkSize,
+		whole:       sha1.New(),
+		chunk:       sha1.New(),
+		chunkHashes: make([]string, 0),
+	}
+}
+
+func newVerifyingChunkingHasher(entry *fileEntry, stopAtFirstBadChunk bool) *chunkingHasher {
+	h := newChunkingHasher(entry.Chunks.ChunkSize)
+
+	h.expectedChunkHashes = entry.Chunks.Hashes
+	h.expectedSize = entry.Size
+	h.corruptedRanges = make([]ByteRange, 0)
+	h.stopAtFirstBadChunk = stopAtFirstBadChunk
+
+	return h
+}
+
+func (h *chunkingHasher) Write(p []byte) (int, error) {
+	n := 0
+
+	for len(p) > 0 {
+		toWrite := min(int64(len(p)), h.chunkSize-h.chunkWritten)
+
+		h.whole.Write(p[:toWrite])
+		h.chunk.Write(p[:toWrite])
+
+		h.chunkWritten += toWrite
+		h.written += toWrite
+		n += int(toWrite)
+		p = p[toWrite:]
+
+		if h.chunkWritten == h.chunkSize {
+			if err := h.endChunk(); err != nil {
+				return n, err
+			}
+		}
+	}
+
+	return n, nil
+}
+
+func (h *chunkingHasher) endChunk() error {
+	index := len(h.chunkHashes)
+	chunkHash := fmt.Sprintf("%x", h.chunk.Sum(nil))
+
+	h.chunkHashes = append(h.chunkHashes, chunkHash)
+
+	offset := int64(index) * h.chunkSize
+	length := h.chunkWritten
+
+	h.chunk.Reset()
+	h.chunkWritten = 0
+
+	if h.expectedChunkHashes == nil {
+		return nil
+	}
+
+	if index < len(h.expectedChunkHashes) && h.expectedChunkHashes[index] == chunkHash {
+		return nil
+	}
+
+	h.addCorruptedRange(ByteRange{Offset: offset, Length: length})
+
+	if h.stopAtFirstBadChunk {
+		return errBadChunk
+	}
+
+	return nil
+}
+
+// Ends the last, possibly incomplete, chunk. If file is shorter than
+// expected, the missing tail is reported as corrupted. Returns chunk hashes
+func (h *chunkingHasher) finish() []string {
+	// Empty file still has one (empty) chunk, so that its chunk hashes
+	// are never empty
+	if h.chunkWritten > 0 || len(h.chunkHashes) == 0 {
+		// Error only tells to stop reading the file, which is already read
+		_ = h.endChunk()
+	}
+
+	if h.expectedChunkHashes != nil && h.written < h.expectedSize {
+		h.addCorruptedRange(ByteRange{
+			Offset: h.written,
+			Length: h.expectedSize - h.written,
+		})
+	}
+
+	return h.chunkHashes
+}
+
+func (h *chunkingHasher) sum() string {
+	return fmt.Sprintf("%x", h.whole.Sum(nil))
+}
+
+// Merges adjacent ranges, so corruption spanning several chunks is
+// reported as one range
+func (h *chunkingHasher) addCorruptedRange(r ByteRange) {
+	last := len(h.corruptedRanges) - 1
+
+	if last >= 0 && h.corruptedRanges[last].Offset+h.corruptedRanges[last].Length == r.Offset {
+		h.corruptedRanges[last].Length += r.Length
+		return
+	}
+
+	h.corruptedRanges = append(h.corruptedRanges, r)
+}
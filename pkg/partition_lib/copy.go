@@ -0,0 +1,182 @@
+package partition_lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/azerum/data-storage-suite/pkg/utils"
+)
+
+type CopyOutcome interface {
+	isCopyOutcome()
+}
+
+type FileCopied struct {
+	ManifestPath string
+	Size         int64
+}
+
+func (o FileCopied) isCopyOutcome() {}
+
+// File was copied by a previous, interrupted CopyTo()
+type FileAlreadyCopied struct {
+	ManifestPath string
+}
+
+func (o FileAlreadyCopied) isCopyOutcome() {}
+
+type FileNotCopied struct {
+	ManifestPath string
+	Reason       string
+}
+
+func (o FileNotCopied) isCopyOutcome() {}
+
+// CopyTo copies files of the partition to dstDir, so that dstDir becomes
+// a partition provably identical to this one
+//
+// Each file is hashed while being copied and compared with the manifest.
+// Files are installed atomically and get mtime from the manifest. If all
+// files were copied, the manifest is copied too. Otherwise (FileNotCopied
+// was reported) dstDir gets no manifest
+//
+// Only files in the manifest are copied. Run Check() beforehand to find
+// files not hashed yet
+//
+// Interrupted copy can be resumed by calling CopyTo() again: files in
+// dstDir with the size, mtime and hash from the manifest are not copied
+// again
+//
+// Fails if dstDir is already a partition
+func (partition *Partition) CopyTo(ctx context.Context, dstDir string) *utils.ChanWithError[CopyOutcome] {
+	out := utils.NewChanWithError[CopyOutcome](1)
+	go copyWorker(partition, dstDir, out, ctx)
+
+	return out
+}
+
+func copyWorker(
+	partition *Partition,
+	dstDir string,
+	out *utils.ChanWithError[CopyOutcome],
+	ctx context.Context,
+) {
+	if partition.manifest == nil {
+		out.CloseWithError(
+			fmt.Errorf("partition %s has no manifest", partition.AbsoluteDirOsPath),
+		)
+
+		return
+	}
+
+	_, err := os.Stat(filepath.Join(dstDir, manifestFileName))
+
+	if err == nil {
+		out.CloseWithError(fmt.Errorf("%s is already a partition", dstDir))
+		return
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		out.CloseWithError(err)
+		return
+	}
+
+	dst := Partition{
+		AbsoluteDirOsPath: dstDir,
+		manifest:          partition.manifest,
+		files:             NewOsFileSystem(dstDir),
+	}
+
+	allCopied := true
+
+	for _, manifestPath := range sortedKeys(partition.manifest.Files) {
+		if err := ctx.Err(); err != nil {
+			out.CloseWithError(err)
+			return
+		}
+
+		entry := partition.manifest.Files[manifestPath]
+		dstPath := dst.toAbsoluteOsPath(manifestPath)
+
+		alreadyCopied, err := dst.isAlreadyCopied(manifestPath, entry)
+
+		if err != nil {
+			out.CloseWithError(err)
+			return
+		}
+
+		if alreadyCopied {
+			out.Channel <- FileAlreadyCopied{ManifestPath: manifestPath}
+			continue
+		}
+
+		srcPath := partition.toAbsoluteOsPath(manifestPath)
+		err = copyFileVerified(srcPath, dstPath, entry.Hash, entry.Mtime)
+
+		if errors.Is(err, errCopiedHashMismatch) || errors.Is(err, fs.ErrNotExist) {
+			allCopied = false
+
+			out.Channel <- FileNotCopied{
+				ManifestPath: manifestPath,
+				Reason:       err.Error(),
+			}
+
+			continue
+		}
+
+		if err != nil {
+			out.CloseWithError(err)
+			return
+		}
+
+		out.Channel <- FileCopied{ManifestPath: manifestPath, Size: entry.Size}
+	}
+
+	if allCopied {
+		if err := dst.Save(); err != nil {
+			out.CloseWithError(err)
+			return
+		}
+	}
+
+	out.CloseOk()
+}
+
+// Files already in dst are hashed, so a file truncated or corrupted after
+// it was copied, or an unrelated file with the same size & mtime, is copied
+// again instead of being certified by the dst manifest. Size & mtime are
+// compared first, to not read files that surely need copying
+//
+// Files with unknown size are always copied again
+func (dst *Partition) isAlreadyCopied(manifestPath string, entry *fileEntry) (bool, error) {
+	info, err := dst.files.Stat(manifestPath)
+
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	sizeAndMtimeMatch := info.Mode().IsRegular() &&
+		entry.Size != unknownSize &&
+		info.Size() == entry.Size &&
+		info.ModTime().Unix() == entry.Mtime
+
+	if !sizeAndMtimeMatch {
+		return false, nil
+	}
+
+	hash, _, err := dst.hashFile(manifestPath, entry.Size)
+
+	if err != nil {
+		return false, err
+	}
+
+	return hash == entry.Hash, nil
+}
@@ -0,0 +1,78 @@
+package partition_lib
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/azerum/data-storage-suite/pkg/utils"
+)
+
+// Returned by copyFileVerified() when the copied contents does not match
+// the expected hash
+var errCopiedHashMismatch = errors.New("copied contents does not match the expected hash")
+
+// Atomically replaces (or creates) dstPath with contents of srcPath, and
+// sets its mtime to the given unix time, so it matches the manifest entry
+//
+// Contents is hashed while copying. If hash differs from expectedHash,
+// dstPath is left untouched and errCopiedHashMismatch is returned
+func copyFileVerified(srcPath string, dstPath string, expectedHash string, mtime int64) error {
+	src, err := os.Open(srcPath)
+
+	if err != nil {
+		return err
+	}
+
+	defer src.Close()
+
+	info, err := src.Stat()
+
+	if err != nil {
+		return err
+	}
+
+	write := func(w io.Writer) error {
+		_, err := io.Copy(w, src)
+		return err
+	}
+
+	return installVerified(dstPath, write, expectedHash, mtime, info.Mode().Perm())
+}
+
+// Like copyFileVerified(), but contents is written by `write` callback
+func installVerified(
+	dstPath string,
+	write func(w io.Writer) error,
+	expectedHash string,
+	mtime int64,
+	perm fs.FileMode,
+) error {
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0o755); err != nil {
+		return err
+	}
+
+	return utils.OverwriteWith(dstPath, dstPath+partialFileSuffix, func(tmpFile *os.File) error {
+		hasher := sha1.New()
+
+		if err := write(io.MultiWriter(tmpFile, hasher)); err != nil {
+			return err
+		}
+
+		if fmt.Sprintf("%x", hasher.Sum(nil)) != expectedHash {
+			return errCopiedHashMismatch
+		}
+
+		if err := tmpFile.Chmod(perm); err != nil {
+			return err
+		}
+
+		t := time.Unix(mtime, 0)
+		return os.Chtimes(tmpFile.Name(), t, t)
+	})
+}
@@ -0,0 +1,121 @@
+package partition_lib
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// FindPartitions returns absolute OS paths of all directories under rootDir
+// (including rootDir itself) that contain a manifest file. Nested
+// partitions are included. Result is sorted
+func FindPartitions(ctx context.Context, rootDir string) ([]string, error) {
+	absoluteRootDir, err := filepath.Abs(rootDir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := make([]string, 0)
+
+	err = filepath.WalkDir(absoluteRootDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if !d.IsDir() && d.Name() == manifestFileName {
+			dirs = append(dirs, filepath.Dir(path))
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	slices.Sort(dirs)
+	return dirs, nil
+}
+
+// Parent partition's manifest has entries for files of a partition nested
+// inside it. Such files are tracked twice
+type NestedPartitionConflict struct {
+	ParentDir string
+	ChildDir  string
+
+	// Number of parent manifest entries that are inside ChildDir
+	CoveredFiles int
+}
+
+// FindNestedPartitionConflicts checks every pair of given partitions where
+// one is inside another. Partitions without manifest never conflict
+func FindNestedPartitionConflicts(partitionDirs []string) ([]NestedPartitionConflict, error) {
+	partitions := make([]*Partition, 0, len(partitionDirs))
+
+	for _, dir := range partitionDirs {
+		absoluteDir, err := filepath.Abs(dir)
+
+		if err != nil {
+			return nil, err
+		}
+
+		p, err := LoadPartition(absoluteDir)
+
+		if err != nil {
+			return nil, err
+		}
+
+		partitions = append(partitions, p)
+	}
+
+	conflicts := make([]NestedPartitionConflict, 0)
+
+	for _, parent := range partitions {
+		if parent.manifest == nil {
+			continue
+		}
+
+		for _, child := range partitions {
+			if parent == child {
+				continue
+			}
+
+			childManifestPath, err := toManifestPath(parent.AbsoluteDirOsPath, child.AbsoluteDirOsPath)
+
+			if err != nil {
+				return nil, err
+			}
+
+			// Child is not inside parent
+			if childManifestPath == "." || strings.HasPrefix(childManifestPath, "../") || childManifestPath == ".." {
+				continue
+			}
+
+			covered := 0
+			prefix := childManifestPath + "/"
+
+			for p := range parent.manifest.Files {
+				if strings.HasPrefix(p, prefix) {
+					covered++
+				}
+			}
+
+			if covered > 0 {
+				conflicts = append(conflicts, NestedPartitionConflict{
+					ParentDir:    parent.AbsoluteDirOsPath,
+					ChildDir:     child.AbsoluteDirOsPath,
+					CoveredFiles: covered,
+				})
+			}
+		}
+	}
+
+	return conflicts, nil
+}
@@ -0,0 +1,317 @@
+package partition_lib
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/azerum/data-storage-suite/pkg/utils"
+)
+
+type DuplicateFile struct {
+	Partition    *Partition
+	ManifestPath string
+}
+
+// Files with the same hash and size, according to the manifests
+type DuplicateGroup struct {
+	Hash string
+
+	// May be unknown (-1)
+	Size int64
+
+	// Sorted by partition dir, then by path. The first file is kept when
+	// deduplicating
+	Files []DuplicateFile
+}
+
+// Bytes freed if all files but one were deleted
+func (group *DuplicateGroup) ReclaimableBytes() int64 {
+	return max(0, group.Size) * int64(len(group.Files)-1)
+}
+
+// FindDuplicates groups files of the partitions (within and across them) by
+// hash and size. Only groups of at least 2 files are returned, most
+// reclaimable bytes first
+//
+// Only manifests are read. They may be stale, so Dedupe() verifies files
+// before touching them
+func FindDuplicates(partitions []*Partition) ([]DuplicateGroup, error) {
+	type key struct {
+		hash string
+		size int64
+	}
+
+	groups := make(map[key]*DuplicateGroup)
+
+	for _, p := range partitions {
+		if p.manifest == nil {
+			return nil, fmt.Errorf("partition %s has no manifest", p.AbsoluteDirOsPath)
+		}
+
+		for manifestPath, entry := range p.manifest.Files {
+			k := key{hash: entry.Hash, size: entry.Size}
+			group := groups[k]
+
+			if group == nil {
+				group = &DuplicateGroup{Hash: entry.Hash, Size: entry.Size}
+				groups[k] = group
+			}
+
+			group.Files = append(group.Files, DuplicateFile{Partition: p, ManifestPath: manifestPath})
+		}
+	}
+
+	result := make([]DuplicateGroup, 0)
+
+	for _, group := range groups {
+		if len(group.Files) < 2 {
+			continue
+		}
+
+		slices.SortFunc(group.Files, func(a DuplicateFile, b DuplicateFile) int {
+			return cmp.Or(
+				cmp.Compare(a.Partition.AbsoluteDirOsPath, b.Partition.AbsoluteDirOsPath),
+				cmp.Compare(a.ManifestPath, b.ManifestPath),
+			)
+		})
+
+		result = append(result, *group)
+	}
+
+	slices.SortFunc(result, func(a DuplicateGroup, b DuplicateGroup) int {
+		return cmp.Or(
+			cmp.Compare(b.ReclaimableBytes(), a.ReclaimableBytes()),
+			cmp.Compare(a.Hash, b.Hash),
+		)
+	})
+
+	return result, nil
+}
+
+type DedupeAction int
+
+const (
+	// Replace duplicate with a hardlink to the kept file. Works only within
+	// one filesystem. Duplicate gets mtime and permissions of the kept file
+	DedupeHardlink DedupeAction = iota
+
+	// Replace duplicate with a copy-on-write clone of the kept file (Btrfs,
+	// XFS). Duplicate keeps its mtime and permissions
+	DedupeReflink
+
+	DedupeDelete
+)
+
+type DedupeOutcome interface {
+	isDedupeOutcome()
+}
+
+type DuplicateReplaced struct {
+	File DuplicateFile
+	Kept DuplicateFile
+}
+
+func (o DuplicateReplaced) isDedupeOutcome() {}
+
+type DuplicateDeleted struct {
+	File DuplicateFile
+}
+
+func (o DuplicateDeleted) isDedupeOutcome() {}
+
+type DuplicateSkipped struct {
+	File   DuplicateFile
+	Reason string
+}
+
+func (o DuplicateSkipped) isDedupeOutcome() {}
+
+// Dedupe keeps the first file of each group and replaces or deletes the
+// rest, according to the action
+//
+// Live contents of both kept file and the duplicate is hashed first, as
+// manifests may be stale. If either does not match the group hash, the
+// duplicate is skipped. Manifests of changed partitions are updated and
+// saved, even if Dedupe() fails halfway
+func Dedupe(
+	ctx context.Context,
+	groups []DuplicateGroup,
+	action DedupeAction,
+) *utils.ChanWithError[DedupeOutcome] {
+	out := utils.NewChanWithError[DedupeOutcome](1)
+	go dedupeWorker(groups, action, out, ctx)
+
+	return out
+}
+
+func dedupeWorker(
+	groups []DuplicateGroup,
+	action DedupeAction,
+	out *utils.ChanWithError[DedupeOutcome],
+	ctx context.Context,
+) {
+	changedPartitions := make(map[*Partition]struct{})
+	err := dedupeGroups(groups, action, changedPartitions, out.Channel, ctx)
+
+	for p := range changedPartitions {
+		if saveErr := p.Save(); saveErr != nil {
+			err = errors.Join(err, saveErr)
+		}
+	}
+
+	if err != nil {
+		out.CloseWithError(err)
+		return
+	}
+
+	out.CloseOk()
+}
+
+func dedupeGroups(
+	groups []DuplicateGroup,
+	action DedupeAction,
+	changedPartitions map[*Partition]struct{},
+	out chan<- DedupeOutcome,
+	ctx context.Context,
+) error {
+	for _, group := range groups {
+		kept := group.Files[0]
+		keptPath := kept.Partition.toAbsoluteOsPath(kept.ManifestPath)
+
+		keptMatches, err := liveHashMatches(keptPath, group.Hash)
+
+		if err != nil {
+			return err
+		}
+
+		for _, dup := range group.Files[1:] {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			if !keptMatches {
+				out <- DuplicateSkipped{
+					File:   dup,
+					Reason: fmt.Sprintf("kept file %s does not match the manifest", keptPath),
+				}
+
+				continue
+			}
+
+			outcome, err := dedupeFile(kept, keptPath, dup, group.Hash, action)
+
+			if err != nil {
+				return err
+			}
+
+			if _, skipped := outcome.(DuplicateSkipped); !skipped {
+				changedPartitions[dup.Partition] = struct{}{}
+			}
+
+			out <- outcome
+		}
+	}
+
+	return nil
+}
+
+func dedupeFile(
+	kept DuplicateFile,
+	keptPath string,
+	dup DuplicateFile,
+	hash string,
+	action DedupeAction,
+) (DedupeOutcome, error) {
+	dupPath := dup.Partition.toAbsoluteOsPath(dup.ManifestPath)
+
+	keptInfo, err := os.Stat(keptPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	dupInfo, err := os.Stat(dupPath)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return DuplicateSkipped{File: dup, Reason: "file is missing"}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if os.SameFile(keptInfo, dupInfo) {
+		return DuplicateSkipped{File: dup, Reason: "already a hardlink of the kept file"}, nil
+	}
+
+	dupMatches, err := liveHashMatches(dupPath, hash)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !dupMatches {
+		return DuplicateSkipped{File: dup, Reason: "file does not match the manifest"}, nil
+	}
+
+	tmpPath := dupPath + partialFileSuffix
+
+	switch action {
+	case DedupeDelete:
+		if err := os.Remove(dupPath); err != nil {
+			return nil, err
+		}
+
+		delete(dup.Partition.manifest.Files, dup.ManifestPath)
+		return DuplicateDeleted{File: dup}, nil
+
+	case DedupeHardlink:
+		if err := os.Remove(tmpPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+
+		if err := os.Link(keptPath, tmpPath); err != nil {
+			return DuplicateSkipped{File: dup, Reason: err.Error()}, nil
+		}
+
+		if err := os.Rename(tmpPath, dupPath); err != nil {
+			return nil, err
+		}
+
+		dup.Partition.manifest.Files[dup.ManifestPath].Mtime = keptInfo.ModTime().Unix()
+
+	case DedupeReflink:
+		err := reflinkOver(keptPath, dupPath, dupInfo)
+
+		if errors.Is(err, errReflinkNotSupported) {
+			return DuplicateSkipped{File: dup, Reason: err.Error()}, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+	default:
+		panic(fmt.Sprintf("Unknown DedupeAction: %d", action))
+	}
+
+	return DuplicateReplaced{File: dup, Kept: kept}, nil
+}
+
+func liveHashMatches(absoluteOsPath string, hash string) (bool, error) {
+	actual, err := HashFile(absoluteOsPath)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return actual == hash, nil
+}
@@ -0,0 +1,131 @@
+package partition_lib
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type ExportFormat int
+
+const (
+	// `<sha1>  <path>`, as printed by sha1sum
+	ExportSha1sum ExportFormat = iota
+
+	// `<sha256>  <path>`, as printed by sha256sum. Files are read, as
+	// manifests store only SHA-1
+	ExportSha256sum
+
+	// `SHA1 (<path>) = <sha1>`, as printed by BSD sha1 and `sha1sum --tag`
+	ExportBsd
+
+	// hashdeep file with size and SHA-1, for `hashdeep -a -k`
+	ExportHashdeep
+)
+
+// Export writes the manifest as a checksum list in the given format, with
+// paths relative to the partition dir, sorted. The partition is not modified
+//
+// For ExportSha256sum, files are hashed with both SHA-256 and SHA-1, and
+// export fails if SHA-1 does not match the manifest, so the list never
+// vouches for corrupted contents
+func (partition *Partition) Export(ctx context.Context, w io.Writer, format ExportFormat) error {
+	if partition.manifest == nil {
+		return fmt.Errorf("partition %s has no manifest", partition.AbsoluteDirOsPath)
+	}
+
+	buffered := bufio.NewWriter(w)
+
+	if format == ExportHashdeep {
+		fmt.Fprint(buffered, "%%%% HASHDEEP-1.0\n%%%% size,sha1,filename\n##\n")
+	}
+
+	for _, manifestPath := range sortedKeys(partition.manifest.Files) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		entry := partition.manifest.Files[manifestPath]
+
+		switch format {
+		case ExportSha1sum:
+			fmt.Fprintln(buffered, sprintSumLine(entry.Hash, manifestPath))
+
+		case ExportSha256sum:
+			hash, err := partition.sha256Verified(manifestPath, entry)
+
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintln(buffered, sprintSumLine(hash, manifestPath))
+
+		case ExportBsd:
+			fmt.Fprintf(buffered, "SHA1 (%s) = %s\n", manifestPath, entry.Hash)
+
+		case ExportHashdeep:
+			size := entry.Size
+
+			if size == unknownSize {
+				info, err := partition.files.Stat(manifestPath)
+
+				if err != nil {
+					return err
+				}
+
+				size = info.Size()
+			}
+
+			fmt.Fprintf(buffered, "%d,%s,%s\n", size, entry.Hash, manifestPath)
+
+		default:
+			panic(fmt.Sprintf("Unknown ExportFormat: %d", format))
+		}
+	}
+
+	// bufio.Writer remembers the first error
+	return buffered.Flush()
+}
+
+// Like sha1sum, escapes `\` and newlines in the path and marks such line
+// with leading `\`
+func sprintSumLine(hash string, manifestPath string) string {
+	if !strings.ContainsAny(manifestPath, "\\\n") {
+		return hash + "  " + manifestPath
+	}
+
+	escaped := strings.NewReplacer("\\", "\\\\", "\n", "\\n").Replace(manifestPath)
+	return "\\" + hash + "  " + escaped
+}
+
+func (partition *Partition) sha256Verified(manifestPath string, entry *fileEntry) (string, error) {
+	file, err := partition.files.Open(manifestPath)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer file.Close()
+
+	sha1Hasher := sha1.New()
+	sha256Hasher := sha256.New()
+
+	if _, err := io.Copy(io.MultiWriter(sha1Hasher, sha256Hasher), file); err != nil {
+		return "", err
+	}
+
+	if actual := fmt.Sprintf("%x", sha1Hasher.Sum(nil)); actual != entry.Hash {
+		return "", fmt.Errorf(
+			"%s does not match the manifest: actual=%s expected=%s",
+			manifestPath,
+			actual,
+			entry.Hash,
+		)
+	}
+
+	return fmt.Sprintf("%x", sha256Hasher.Sum(nil)), nil
+}
@@ -0,0 +1,119 @@
+package partition_lib
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/azerum/data-storage-suite/pkg/utils"
+)
+
+// FileSystem is the storage files of a partition are read from and its
+// manifest is written to. Names are slash-separated paths relative to the
+// partition dir, as in io/fs ("." is the partition dir itself), so manifest
+// paths can be used as names directly
+//
+// Hashing, checking, loading and saving partitions go through FileSystem.
+// Features that modify partition files in place (repair, copy, sync,
+// dedupe, parity, scrub) still require a partition in an OS directory
+type FileSystem interface {
+	// Opens file for reading. Implements fs.FS, so io/fs helpers like
+	// fs.ReadFile() accept FileSystem
+	Open(name string) (fs.File, error)
+
+	// Follows symlinks, like os.Stat()
+	Stat(name string) (fs.FileInfo, error)
+
+	// Like fs.WalkDir(): calls fn for root and everything under it, in
+	// lexical order. Symlinks are not followed
+	WalkDir(root string, fn fs.WalkDirFunc) error
+
+	// Replaces file with contents produced by `write` atomically: readers
+	// see either old or new contents, and if `write` fails or the process
+	// crashes, the file is left untouched
+	WriteFileAtomic(name string, write func(w io.Writer) error) error
+}
+
+// Optional interface of FileSystem, for storages that keep SHA-1 of files
+// which can be trusted, e.g. checksums verified by the storage on upload.
+// Hash() uses it instead of reading files that are not chunked. Check()
+// always reads files
+type StoredSha1FileSystem interface {
+	FileSystem
+
+	// Returns "" if SHA-1 of the file is not stored
+	StoredSha1(name string) (string, error)
+}
+
+var errReadOnlyPartition = errors.New("partition is read-only")
+
+type osFileSystem struct {
+	dir string
+}
+
+// NewOsFileSystem returns FileSystem of OS directory dir. Atomic writes
+// go through a temporary file `<name>.tmp`, see utils.OverwriteWith()
+func NewOsFileSystem(dir string) FileSystem {
+	return &osFileSystem{dir: dir}
+}
+
+func (o *osFileSystem) osPath(name string) string {
+	return filepath.Join(o.dir, filepath.FromSlash(name))
+}
+
+func (o *osFileSystem) Open(name string) (fs.File, error) {
+	return os.Open(o.osPath(name))
+}
+
+func (o *osFileSystem) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(o.osPath(name))
+}
+
+func (o *osFileSystem) WalkDir(root string, fn fs.WalkDirFunc) error {
+	return filepath.WalkDir(o.osPath(root), func(osPath string, d fs.DirEntry, err error) error {
+		name, relErr := toManifestPath(o.dir, osPath)
+
+		if relErr != nil {
+			return relErr
+		}
+
+		return fn(name, d, err)
+	})
+}
+
+func (o *osFileSystem) WriteFileAtomic(name string, write func(w io.Writer) error) error {
+	osPath := o.osPath(name)
+
+	return utils.OverwriteWith(osPath, osPath+".tmp", func(tmpFile *os.File) error {
+		return write(tmpFile)
+	})
+}
+
+type readOnlyFileSystem struct {
+	fsys fs.FS
+}
+
+// NewReadOnlyFileSystem adapts fsys (e.g. an archive, see archivefs
+// package) to FileSystem. WriteFileAtomic() fails, so partitions in it
+// cannot be saved
+func NewReadOnlyFileSystem(fsys fs.FS) FileSystem {
+	return &readOnlyFileSystem{fsys: fsys}
+}
+
+func (r *readOnlyFileSystem) Open(name string) (fs.File, error) {
+	return r.fsys.Open(name)
+}
+
+func (r *readOnlyFileSystem) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(r.fsys, name)
+}
+
+func (r *readOnlyFileSystem) WalkDir(root string, fn fs.WalkDirFunc) error {
+	return fs.WalkDir(r.fsys, root, fn)
+}
+
+func (r *readOnlyFileSystem) WriteFileAtomic(name string, _write func(w io.Writer) error) error {
+	return &fs.PathError{Op: "write", Path: name, Err: errReadOnlyPartition}
+}
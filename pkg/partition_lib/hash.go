@@ -5,22 +5,71 @@ import (
 	"errors"
 	"fmt"
 	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
 
 	"github.com/azerum/data-storage-suite/pkg/utils"
 )
 
+type HashOptions struct {
+	// Manifest path of a directory (or a file). If set, only this subtree is
+	// walked, and FileDeleted is reported only for entries under it. The rest
+	// of the manifest is left untouched
+	Only string
+
+	// If set, hashes of files that did not change since they were hashed
+	// (in this or another partition) are taken from the cache. nil disables
+	// the cache
+	Cache *HashCache
+
+	// Write hash of each file into its extended attribute (see
+	// hashXattrName), and trust such attributes of files that need hashing
+	// if they were written for the current mtime and size of the file
+	Xattr bool
+}
+
 func (partition *Partition) Hash(ctx context.Context) *utils.ChanWithError[ManifestChange] {
+	return partition.HashWithOptions(ctx, HashOptions{})
+}
+
+func (partition *Partition) HashWithOptions(
+	ctx context.Context,
+	options HashOptions,
+) *utils.ChanWithError[ManifestChange] {
 	out := utils.NewChanWithError[ManifestChange](1)
-	go hashWorker(partition, out, ctx)
+	go hashWorker(partition, options, out, ctx)
 
 	return out
 }
 
 func hashWorker(
 	partition *Partition,
+	options HashOptions,
 	out *utils.ChanWithError[ManifestChange],
 	ctx context.Context,
 ) {
+	only, err := cleanSubtreePath(options.Only)
+
+	if err != nil {
+		out.CloseWithError(err)
+		return
+	}
+
+	// Files of nested partitions are not part of this one. Walk only checks
+	// directories it walks into, not parents of the subtree
+	nested, err := partition.nestedPartitionOf(only)
+
+	if err != nil {
+		out.CloseWithError(err)
+		return
+	}
+
+	if nested != "" {
+		out.CloseWithError(fmt.Errorf("%s is in nested partition %s", options.Only, nested))
+		return
+	}
+
 	seenInPartition := make(map[string]struct{})
 
 	if partition.manifest == nil {
@@ -29,7 +78,7 @@ func hashWorker(
 		}
 	}
 
-	walk := func(absoluteOsPath string, manifestPath string, entry fs.DirEntry) error {
+	walk := func(_absoluteOsPath string, manifestPath string, entry fs.DirEntry) error {
 		seenInPartition[manifestPath] = struct{}{}
 
 		info, err := entry.Info()
@@ -39,28 +88,20 @@ func hashWorker(
 		}
 
 		mtime := info.ModTime().Unix()
+		size := info.Size()
 
-		// If we have no manifest yet, everything is added
-		if partition.manifest == nil {
-			hash, err := HashFile(absoluteOsPath)
-
-			if err != nil {
-				return err
-			}
-
-			out.Channel <- FileAdded{
-				ManifestPath: manifestPath,
-				hash:         hash,
-				mtime:        mtime,
+		recordXattr := func(hash string) error {
+			if !options.Xattr {
+				return nil
 			}
 
-			return nil
+			return partition.writeHashXattr(manifestPath, info, hash)
 		}
 
 		manifestEntry := partition.manifest.Files[manifestPath]
 
 		if manifestEntry == nil {
-			hash, err := HashFile(absoluteOsPath)
+			hash, chunks, err := partition.hashFileWithOptions(manifestPath, info, options)
 
 			if err != nil {
 				return err
@@ -70,9 +111,11 @@ func hashWorker(
 				ManifestPath: manifestPath,
 				hash:         hash,
 				mtime:        mtime,
+				size:         size,
+				chunks:       chunks,
 			}
 
-			return nil
+			return recordXattr(hash)
 		}
 
 		// If file's mtime is the same as in the manifest, assume it has
@@ -84,12 +127,31 @@ func hashWorker(
 		// It is possible that mtime changed and hash didn't - we should update
 		// mtime in the manifest in such case, to avoid hashing this file
 		// next time
+		//
+		// Size changing while mtime did not means the contents has surely
+		// changed. Unknown size is filled in trusting mtime, the same way
+		// as contents is
+		//
+		// Files that should be chunked, but are not, are hashed even if
+		// they have not changed
 
 		if manifestEntry.Mtime == mtime {
-			return nil
+			if manifestEntry.Size == unknownSize {
+				out.Channel <- SpuriousMtimeChange{
+					ManifestPath: manifestPath,
+					mtime:        mtime,
+					size:         size,
+				}
+
+				return recordXattr(manifestEntry.Hash)
+			}
+
+			if manifestEntry.Size == size && !partition.manifest.needsRechunking(manifestEntry, size) {
+				return recordXattr(manifestEntry.Hash)
+			}
 		}
 
-		hash, err := HashFile(absoluteOsPath)
+		hash, chunks, err := partition.hashFileWithOptions(manifestPath, info, options)
 
 		if err != nil {
 			return err
@@ -99,21 +161,29 @@ func hashWorker(
 			out.Channel <- SpuriousMtimeChange{
 				ManifestPath: manifestPath,
 				mtime:        mtime,
+				size:         size,
+				chunks:       chunks,
 			}
 
-			return nil
+			return recordXattr(hash)
 		}
 
 		out.Channel <- FileModified{
 			ManifestPath: manifestPath,
 			hash:         hash,
 			mtime:        mtime,
+			size:         size,
+			chunks:       chunks,
 		}
 
-		return nil
+		return recordXattr(hash)
 	}
 
-	err := partition.Walk(walk, ctx)
+	if only == "" {
+		err = partition.Walk(walk, ctx)
+	} else {
+		err = partition.walkSubtree(only, walk, ctx)
+	}
 
 	if err != nil {
 		out.CloseWithError(err)
@@ -125,13 +195,101 @@ func hashWorker(
 	for p := range partition.manifest.Files {
 		_, seen := seenInPartition[p]
 
-		if !seen {
+		if !seen && isInSubtree(only, p) {
 			out.Channel <- FileDeleted{ManifestPath: p}
 		}
 	}
 
 	out.CloseOk()
+}
+
+// Like hashFile(), but first consults hash xattr and the cache, if enabled
+// by options, and stores computed hash in the cache. File is re-stat'ed
+// after hashing, so hash of contents that changed while it was read is not
+// cached under the old key. Neither is hash of a file that just got its
+// hash xattr, as that changes ctime
+//
+// Chunked files are always read, as chunk hashes are neither in xattrs nor
+// in the cache
+func (partition *Partition) hashFileWithOptions(
+	manifestPath string,
+	info fs.FileInfo,
+	options HashOptions,
+) (string, *chunkHashes, error) {
+	chunked := partition.manifest.shouldChunk(info.Size())
+
+	if options.Xattr && !chunked {
+		x, err := partition.readHashXattr(manifestPath)
 
+		if err != nil {
+			return "", nil, err
+		}
+
+		if x != nil && x.matches(info) {
+			return x.Hash, nil, nil
+		}
+	}
+
+	cache := options.Cache
+	key, cacheable := hashCacheKeyOf(info)
+
+	if cache == nil || !cacheable || chunked {
+		return partition.hashFile(manifestPath, info.Size())
+	}
+
+	if hash := cache.lookup(key); hash != "" {
+		return hash, nil, nil
+	}
+
+	hash, chunks, err := partition.hashFile(manifestPath, info.Size())
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Writing xattr changes ctime, so it is written before the re-stat. An
+	// entry stored under the old key would never be hit
+	if options.Xattr {
+		if err := partition.writeHashXattr(manifestPath, info, hash); err != nil {
+			return "", nil, err
+		}
+	}
+
+	infoAfter, err := partition.files.Stat(manifestPath)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Cache is only an optimization, so failing to store is not an error
+	if keyAfter, _ := hashCacheKeyOf(infoAfter); keyAfter == key {
+		_ = cache.store(key, hash)
+	}
+
+	return hash, chunks, nil
+}
+
+// Normalizes subtree manifest path. Empty result means the whole partition
+func cleanSubtreePath(subtree string) (string, error) {
+	if subtree == "" {
+		return "", nil
+	}
+
+	cleaned := path.Clean(filepath.ToSlash(subtree))
+
+	if path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("%s is not a path inside the partition", subtree)
+	}
+
+	if cleaned == "." {
+		return "", nil
+	}
+
+	return cleaned, nil
+}
+
+func isInSubtree(subtree string, manifestPath string) bool {
+	return subtree == "" || manifestPath == subtree || strings.HasPrefix(manifestPath, subtree+"/")
 }
 
 type ManifestChange interface {
@@ -142,6 +300,8 @@ type FileAdded struct {
 	ManifestPath string
 	hash         string
 	mtime        int64
+	size         int64
+	chunks       *chunkHashes
 }
 
 func (c FileAdded) apply(manifest *manifest) error {
@@ -155,8 +315,10 @@ func (c FileAdded) apply(manifest *manifest) error {
 	}
 
 	manifest.Files[c.ManifestPath] = &fileEntry{
-		Hash:  c.hash,
-		Mtime: c.mtime,
+		Hash:   c.hash,
+		Mtime:  c.mtime,
+		Size:   c.size,
+		Chunks: c.chunks,
 	}
 
 	return nil
@@ -166,6 +328,8 @@ type FileModified struct {
 	ManifestPath string
 	hash         string
 	mtime        int64
+	size         int64
+	chunks       *chunkHashes
 }
 
 func (c FileModified) apply(manifest *manifest) error {
@@ -180,6 +344,8 @@ func (c FileModified) apply(manifest *manifest) error {
 
 	entry.Hash = c.hash
 	entry.Mtime = c.mtime
+	entry.Size = c.size
+	entry.Chunks = c.chunks
 
 	return nil
 }
@@ -202,9 +368,15 @@ func (c FileDeleted) apply(manifest *manifest) error {
 	return nil
 }
 
+// File's mtime, size (if unknown) or chunk hashes in the manifest are
+// outdated, but the contents has not changed
 type SpuriousMtimeChange struct {
 	ManifestPath string
 	mtime        int64
+	size         int64
+
+	// nil means chunk hashes are unchanged
+	chunks *chunkHashes
 }
 
 func (c SpuriousMtimeChange) apply(manifest *manifest) error {
@@ -218,6 +390,12 @@ func (c SpuriousMtimeChange) apply(manifest *manifest) error {
 	}
 
 	entry.Mtime = c.mtime
+	entry.Size = c.size
+
+	if c.chunks != nil {
+		entry.Chunks = c.chunks
+	}
+
 	return nil
 }
 
@@ -0,0 +1,209 @@
+package partition_lib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// HashCache remembers SHA-1 of files by their identity on this machine:
+// (dev, inode, size, mtime_ns, ctime_ns). Any write to the file changes
+// ctime, so a matching key means the contents has not changed since it was
+// hashed. The same file seen by several partitions (hardlinks, bind mounts,
+// partitions registered at overlapping paths) is then read only once
+//
+// Each entry is a file named by SHA-256 of its key, written atomically via
+// rename, so the cache can be shared by concurrent processes without locks.
+// Entries not used within hashCacheMaxAge are pruned at most once per
+// hashCachePruneInterval, when the cache is opened
+//
+// Only files on OS filesystems are cached, on Linux. Chunked files are not
+// cached, as they need chunk hashes too
+type HashCache struct {
+	dir string
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+const hashCacheDirEnvVar = "PART_HASH_CACHE_DIR"
+
+const hashCacheMaxAge = 30 * 24 * time.Hour
+const hashCachePruneInterval = 24 * time.Hour
+
+// Entries are touched on hit if they were last touched this long ago, so
+// hits do not write every time
+const hashCacheTouchInterval = 24 * time.Hour
+
+// mtime of this file is the time of the last prune
+const hashCachePruneMarkerFileName = "last-prune"
+
+// DefaultHashCacheDir returns $PART_HASH_CACHE_DIR if set, otherwise
+// part/hash-cache inside the user cache dir
+func DefaultHashCacheDir() (string, error) {
+	if p := os.Getenv(hashCacheDirEnvVar); p != "" {
+		return p, nil
+	}
+
+	cacheDir, err := os.UserCacheDir()
+
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(cacheDir, "part", "hash-cache"), nil
+}
+
+// NewHashCache opens the cache in dir, creating dir if needed, and prunes
+// it if it is due
+func NewHashCache(dir string) (*HashCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	cache := HashCache{dir: dir}
+
+	if err := cache.pruneIfDue(time.Now()); err != nil {
+		return nil, errors.Join(errors.New("failed to prune hash cache"), err)
+	}
+
+	return &cache, nil
+}
+
+// Number of lookups that found (or did not find) the hash since the cache
+// was opened
+func (cache *HashCache) Hits() int64   { return cache.hits.Load() }
+func (cache *HashCache) Misses() int64 { return cache.misses.Load() }
+
+func (cache *HashCache) entryPath(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+
+	return filepath.Join(cache.dir, name[:2], name)
+}
+
+// Returns "" if there is no entry. Unreadable or malformed entries are
+// misses, as the cache is only an optimization
+func (cache *HashCache) lookup(key string) string {
+	file, err := os.Open(cache.entryPath(key))
+
+	if err != nil {
+		cache.misses.Add(1)
+		return ""
+	}
+
+	defer file.Close()
+
+	bytes, err := io.ReadAll(io.LimitReader(file, 2*sha1Size+1))
+	hash := strings.TrimSpace(string(bytes))
+
+	if err != nil || !isSha1Hex(hash) {
+		cache.misses.Add(1)
+		return ""
+	}
+
+	cache.hits.Add(1)
+
+	if info, err := file.Stat(); err == nil && time.Since(info.ModTime()) > hashCacheTouchInterval {
+		now := time.Now()
+		_ = os.Chtimes(file.Name(), now, now)
+	}
+
+	return hash
+}
+
+func (cache *HashCache) store(key string, hash string) error {
+	entryPath := cache.entryPath(key)
+
+	if err := os.MkdirAll(filepath.Dir(entryPath), 0o755); err != nil {
+		return err
+	}
+
+	// Temporary file is unique, so concurrent stores of the same entry do
+	// not write into the same file. Both write the same hash anyway
+	tmpFile, err := os.CreateTemp(filepath.Dir(entryPath), filepath.Base(entryPath)+".*"+partialFileSuffix)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tmpFile.WriteString(hash + "\n")
+
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), entryPath)
+	}
+
+	if err != nil {
+		_ = os.Remove(tmpFile.Name())
+	}
+
+	return err
+}
+
+func (cache *HashCache) pruneIfDue(now time.Time) error {
+	markerPath := filepath.Join(cache.dir, hashCachePruneMarkerFileName)
+	info, err := os.Stat(markerPath)
+
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	if err == nil && now.Sub(info.ModTime()) < hashCachePruneInterval {
+		return nil
+	}
+
+	// Touch first, so concurrent processes do not prune at the same time
+	if err := os.WriteFile(markerPath, nil, 0o644); err != nil {
+		return err
+	}
+
+	return filepath.WalkDir(cache.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Removed by a concurrent prune
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+
+			return err
+		}
+
+		if d.IsDir() || d.Name() == hashCachePruneMarkerFileName {
+			return nil
+		}
+
+		info, err := d.Info()
+
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		// Leftover temporary files are pruned the same way
+		if now.Sub(info.ModTime()) > hashCacheMaxAge {
+			if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func isSha1Hex(s string) bool {
+	decoded, err := hex.DecodeString(s)
+	return err == nil && len(decoded) == sha1Size
+}
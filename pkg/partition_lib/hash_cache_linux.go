@@ -0,0 +1,30 @@
+//go:build linux
+
+package partition_lib
+
+import (
+	"fmt"
+	"io/fs"
+	"syscall"
+)
+
+// Returns cache key of the file, or false if it cannot be cached, e.g.
+// because it is not on an OS filesystem
+func hashCacheKeyOf(info fs.FileInfo) (string, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+
+	if !ok || !info.Mode().IsRegular() {
+		return "", false
+	}
+
+	key := fmt.Sprintf(
+		"%d:%d:%d:%d:%d",
+		stat.Dev,
+		stat.Ino,
+		info.Size(),
+		info.ModTime().UnixNano(),
+		stat.Ctim.Nano(),
+	)
+
+	return key, true
+}
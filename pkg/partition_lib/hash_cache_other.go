@@ -0,0 +1,9 @@
+//go:build !linux
+
+package partition_lib
+
+import "io/fs"
+
+func hashCacheKeyOf(info fs.FileInfo) (string, bool) {
+	return "", false
+}
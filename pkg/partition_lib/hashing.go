@@ -19,6 +19,8 @@ func HashFile(absoluteOsPath string) (string, error) {
 		return "", err
 	}
 
+	defer file.Close()
+
 	hasher := sha1.New()
 
 	if _, err := io.Copy(hasher, file); err != nil {
@@ -0,0 +1,335 @@
+package partition_lib
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/azerum/data-storage-suite/pkg/utils"
+)
+
+type ImportOutcome interface {
+	isImportOutcome()
+}
+
+// File matches the checksum and was added to the manifest
+type ImportVerified struct {
+	ManifestPath string
+}
+
+func (o ImportVerified) isImportOutcome() {}
+
+type ImportChecksumMismatch struct {
+	ManifestPath string
+	Algorithm    string
+	Actual       string
+	Expected     string
+}
+
+func (o ImportChecksumMismatch) isImportOutcome() {}
+
+// File is listed in the checksum file, but is absent
+type ImportFileMissing struct {
+	ManifestPath string
+}
+
+func (o ImportFileMissing) isImportOutcome() {}
+
+type ImportSkipped struct {
+	ManifestPath string
+	Reason       string
+}
+
+func (o ImportSkipped) isImportOutcome() {}
+
+// ImportChecksums reads a checksum file, verifies each listed file and adds
+// verified files to the manifest, with their current mtime and size. The
+// manifest is created if the partition has none. Call Save() afterwards
+//
+// Supported formats:
+//
+//   - md5sum, sha1sum, sha256sum and sha512sum output (algorithm is
+//     inferred from the hash length), including escaped lines
+//   - BSD tags, e.g. `SHA1 (path) = hash`
+//   - SFV (`path crc32`, `;` comments), if the file has .sfv extension
+//
+// Paths are relative to the dir of the checksum file. Files outside of the
+// partition, files Hash() does not see (ignored names, files of nested
+// partitions), files already in the manifest, directories and unreadable
+// files are skipped
+func (partition *Partition) ImportChecksums(
+	ctx context.Context,
+	checksumFilePath string,
+) *utils.ChanWithError[ImportOutcome] {
+	out := utils.NewChanWithError[ImportOutcome](1)
+	go importWorker(partition, checksumFilePath, out, ctx)
+
+	return out
+}
+
+func importWorker(
+	partition *Partition,
+	checksumFilePath string,
+	out *utils.ChanWithError[ImportOutcome],
+	ctx context.Context,
+) {
+	lines, err := parseChecksumFile(checksumFilePath)
+
+	if err != nil {
+		out.CloseWithError(err)
+		return
+	}
+
+	if partition.manifest == nil {
+		partition.manifest = &manifest{
+			Files: make(map[string]*fileEntry),
+		}
+	}
+
+	checksumDir := filepath.Dir(checksumFilePath)
+
+	for _, line := range lines {
+		if err := ctx.Err(); err != nil {
+			out.CloseWithError(err)
+			return
+		}
+
+		outcome, err := partition.importChecksumLine(checksumDir, line)
+
+		if err != nil {
+			out.CloseWithError(err)
+			return
+		}
+
+		out.Channel <- outcome
+	}
+
+	out.CloseOk()
+}
+
+func (partition *Partition) importChecksumLine(checksumDir string, line checksumLine) (ImportOutcome, error) {
+	absoluteOsPath := filepath.Join(checksumDir, filepath.FromSlash(line.path))
+
+	absolutePartitionDir, err := filepath.Abs(partition.AbsoluteDirOsPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	absoluteFile, err := filepath.Abs(absoluteOsPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	manifestPath, err := toManifestPath(absolutePartitionDir, absoluteFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if manifestPath == "." || manifestPath == ".." || strings.HasPrefix(manifestPath, "../") {
+		return ImportSkipped{ManifestPath: line.path, Reason: "outside of the partition"}, nil
+	}
+
+	// Otherwise the next check would report them missing
+	if isIgnoredFile(manifestPath) {
+		return ImportSkipped{ManifestPath: manifestPath, Reason: "ignored file name"}, nil
+	}
+
+	nested, err := partition.nestedPartitionOf(manifestPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if nested != "" {
+		return ImportSkipped{ManifestPath: manifestPath, Reason: fmt.Sprintf("in nested partition %s", nested)}, nil
+	}
+
+	if partition.manifest.Files[manifestPath] != nil {
+		return ImportSkipped{ManifestPath: manifestPath, Reason: "already in the manifest"}, nil
+	}
+
+	file, err := os.Open(absoluteFile)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return ImportFileMissing{ManifestPath: manifestPath}, nil
+	}
+
+	if err != nil {
+		return ImportSkipped{ManifestPath: manifestPath, Reason: err.Error()}, nil
+	}
+
+	defer file.Close()
+
+	info, err := file.Stat()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return ImportSkipped{ManifestPath: manifestPath, Reason: "is a directory"}, nil
+	}
+
+	sha1Hasher := sha1.New()
+	listedHasher := newChecksumHasher(line.algorithm)
+
+	if _, err := io.Copy(io.MultiWriter(sha1Hasher, listedHasher), file); err != nil {
+		return ImportSkipped{ManifestPath: manifestPath, Reason: err.Error()}, nil
+	}
+
+	actual := fmt.Sprintf("%x", listedHasher.Sum(nil))
+
+	if !strings.EqualFold(actual, line.expected) {
+		mismatch := ImportChecksumMismatch{
+			ManifestPath: manifestPath,
+			Algorithm:    line.algorithm,
+			Actual:       actual,
+			Expected:     strings.ToLower(line.expected),
+		}
+
+		return mismatch, nil
+	}
+
+	partition.manifest.Files[manifestPath] = &fileEntry{
+		Hash:  fmt.Sprintf("%x", sha1Hasher.Sum(nil)),
+		Mtime: info.ModTime().Unix(),
+		Size:  info.Size(),
+	}
+
+	return ImportVerified{ManifestPath: manifestPath}, nil
+}
+
+type checksumLine struct {
+	// Slash-separated, relative to the checksum file dir
+	path      string
+	algorithm string
+	expected  string
+}
+
+var bsdChecksumRegexp = regexp.MustCompile(`^(MD5|SHA1|SHA256|SHA512|CRC32) ?\((.*)\) ?= ?([0-9a-fA-F]+)$`)
+var gnuChecksumRegexp = regexp.MustCompile(`^([0-9a-fA-F]+) [ *](.*)$`)
+var sfvChecksumRegexp = regexp.MustCompile(`^(.+?)\s+([0-9a-fA-F]{8})$`)
+
+var algorithmsByHexLength = map[int]string{
+	32:  "md5",
+	40:  "sha1",
+	64:  "sha256",
+	128: "sha512",
+}
+
+func parseChecksumFile(filePath string) ([]checksumLine, error) {
+	file, err := os.Open(filePath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	isSfv := strings.EqualFold(filepath.Ext(filePath), ".sfv")
+	lines := make([]checksumLine, 0)
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+
+	for scanner.Scan() {
+		lineNumber++
+		text := strings.TrimRight(scanner.Text(), "\r")
+
+		if strings.TrimSpace(text) == "" || strings.HasPrefix(text, ";") || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		line, err := parseChecksumLine(text, isSfv)
+
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", filePath, lineNumber, err)
+		}
+
+		line.path = path.Clean(line.path)
+		lines = append(lines, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return lines, nil
+}
+
+func parseChecksumLine(text string, isSfv bool) (checksumLine, error) {
+	if isSfv {
+		m := sfvChecksumRegexp.FindStringSubmatch(text)
+
+		if m == nil {
+			return checksumLine{}, fmt.Errorf("cannot parse SFV line %q", text)
+		}
+
+		// SFV files are often made on Windows
+		p := strings.ReplaceAll(m[1], "\\", "/")
+		return checksumLine{path: p, algorithm: "crc32", expected: m[2]}, nil
+	}
+
+	if m := bsdChecksumRegexp.FindStringSubmatch(text); m != nil {
+		return checksumLine{path: m[2], algorithm: strings.ToLower(m[1]), expected: m[3]}, nil
+	}
+
+	// Line with escaped path starts with `\`
+	escaped := strings.HasPrefix(text, "\\")
+	m := gnuChecksumRegexp.FindStringSubmatch(strings.TrimPrefix(text, "\\"))
+
+	if m == nil {
+		return checksumLine{}, fmt.Errorf("cannot parse line %q", text)
+	}
+
+	algorithm, known := algorithmsByHexLength[len(m[1])]
+
+	if !known {
+		return checksumLine{}, fmt.Errorf("unknown checksum of length %d", len(m[1]))
+	}
+
+	p := m[2]
+
+	if escaped {
+		p = strings.NewReplacer("\\\\", "\\", "\\n", "\n").Replace(p)
+	}
+
+	return checksumLine{path: p, algorithm: algorithm, expected: m[1]}, nil
+}
+
+func newChecksumHasher(algorithm string) hash.Hash {
+	switch algorithm {
+	case "md5":
+		return md5.New()
+
+	case "sha1":
+		return sha1.New()
+
+	case "sha256":
+		return sha256.New()
+
+	case "sha512":
+		return sha512.New()
+
+	case "crc32":
+		return crc32.NewIEEE()
+
+	default:
+		panic(fmt.Sprintf("Unknown checksum algorithm: %s", algorithm))
+	}
+}
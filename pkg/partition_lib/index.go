@@ -0,0 +1,285 @@
+package partition_lib
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+
+	"github.com/azerum/data-storage-suite/pkg/utils"
+)
+
+// HashIndex finds files by hash in partitions without reading their
+// manifests, which is slow for manifests with millions of entries
+//
+// There is one index file per partition. It is rebuilt on lookup if the
+// manifest file has changed since (by mtime & size)
+//
+// Index file layout (integers are big-endian uint64):
+//
+//	magic | manifest mtime (ns) | manifest size | records count | records | paths
+//
+// Record is 20 bytes of SHA-1 followed by offset of the manifest path in
+// paths section. Records are sorted by hash, so lookup is a binary search
+// of the file. Path is its length (uint64) followed by its bytes
+type HashIndex struct {
+	dir string
+}
+
+const hashIndexDirEnvVar = "PART_INDEX_DIR"
+
+var hashIndexMagic = []byte("PARTIDX1")
+
+const hashIndexHeaderSize = 8 + 3*8
+const hashIndexRecordSize = sha1Size + 8
+const sha1Size = 20
+
+// DefaultHashIndexDir returns $PART_INDEX_DIR if set, otherwise
+// part/index inside the user cache dir
+func DefaultHashIndexDir() (string, error) {
+	if p := os.Getenv(hashIndexDirEnvVar); p != "" {
+		return p, nil
+	}
+
+	cacheDir, err := os.UserCacheDir()
+
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(cacheDir, "part", "index"), nil
+}
+
+func NewHashIndex(dir string) *HashIndex {
+	return &HashIndex{dir: dir}
+}
+
+// Lookup returns sorted manifest paths of files with the given SHA-1 (hex)
+// in the partition. Partition with no manifest has no files
+func (index *HashIndex) Lookup(partitionDir string, hash string) ([]string, error) {
+	wanted, err := hex.DecodeString(hash)
+
+	if err != nil || len(wanted) != sha1Size {
+		return nil, fmt.Errorf("invalid SHA-1 %s", hash)
+	}
+
+	absoluteDir, err := filepath.Abs(partitionDir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	manifestInfo, err := os.Stat(filepath.Join(absoluteDir, manifestFileName))
+
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	indexPath := filepath.Join(index.dir, HashString(absoluteDir)+".idx")
+	file, err := openHashIndexFile(indexPath, manifestInfo)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if file == nil {
+		if err := buildHashIndex(absoluteDir, indexPath); err != nil {
+			return nil, errors.Join(fmt.Errorf("failed to index %s", absoluteDir), err)
+		}
+
+		// Manifest may have changed while indexing. Then it will be
+		// reindexed on the next lookup
+		file, err = os.Open(indexPath)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	defer file.Close()
+
+	return lookupInHashIndexFile(file, wanted)
+}
+
+// Returns nil if the index file is missing or outdated
+func openHashIndexFile(indexPath string, manifestInfo os.FileInfo) (*os.File, error) {
+	file, err := os.Open(indexPath)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, hashIndexHeaderSize)
+
+	_, err = io.ReadFull(file, header)
+
+	upToDate := err == nil &&
+		bytes.Equal(header[:8], hashIndexMagic) &&
+		int64(binary.BigEndian.Uint64(header[8:])) == manifestInfo.ModTime().UnixNano() &&
+		int64(binary.BigEndian.Uint64(header[16:])) == manifestInfo.Size()
+
+	if !upToDate {
+		_ = file.Close()
+		return nil, nil
+	}
+
+	return file, nil
+}
+
+func buildHashIndex(absoluteDir string, indexPath string) error {
+	manifestPath := filepath.Join(absoluteDir, manifestFileName)
+
+	// Stat before reading, so if manifest changes in-between, the index
+	// is considered outdated
+	manifestInfo, err := os.Stat(manifestPath)
+
+	if err != nil {
+		return err
+	}
+
+	partition, err := LoadPartition(absoluteDir)
+
+	if err != nil {
+		return err
+	}
+
+	type record struct {
+		hash         []byte
+		manifestPath string
+	}
+
+	records := make([]record, 0, len(partition.manifest.Files))
+
+	for p, entry := range partition.manifest.Files {
+		hash, err := hex.DecodeString(entry.Hash)
+
+		// Such hash cannot be looked up anyway
+		if err != nil || len(hash) != sha1Size {
+			continue
+		}
+
+		records = append(records, record{hash: hash, manifestPath: p})
+	}
+
+	slices.SortFunc(records, func(a record, b record) int {
+		return cmp.Or(bytes.Compare(a.hash, b.hash), cmp.Compare(a.manifestPath, b.manifestPath))
+	})
+
+	if err := os.MkdirAll(filepath.Dir(indexPath), 0o755); err != nil {
+		return err
+	}
+
+	return utils.OverwriteWith(indexPath, indexPath+".tmp", func(tmpFile *os.File) error {
+		w := bufio.NewWriter(tmpFile)
+
+		w.Write(hashIndexMagic)
+		w.Write(binary.BigEndian.AppendUint64(nil, uint64(manifestInfo.ModTime().UnixNano())))
+		w.Write(binary.BigEndian.AppendUint64(nil, uint64(manifestInfo.Size())))
+		w.Write(binary.BigEndian.AppendUint64(nil, uint64(len(records))))
+
+		pathOffset := uint64(0)
+
+		for _, r := range records {
+			w.Write(r.hash)
+			w.Write(binary.BigEndian.AppendUint64(nil, pathOffset))
+
+			pathOffset += 8 + uint64(len(r.manifestPath))
+		}
+
+		for _, r := range records {
+			w.Write(binary.BigEndian.AppendUint64(nil, uint64(len(r.manifestPath))))
+			w.WriteString(r.manifestPath)
+		}
+
+		// bufio.Writer remembers the first error
+		return w.Flush()
+	})
+}
+
+func lookupInHashIndexFile(file *os.File, wanted []byte) ([]string, error) {
+	header := make([]byte, hashIndexHeaderSize)
+
+	if _, err := file.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+
+	count := int(binary.BigEndian.Uint64(header[24:]))
+	pathsOffset := int64(hashIndexHeaderSize) + int64(count)*hashIndexRecordSize
+
+	record := make([]byte, hashIndexRecordSize)
+	var readErr error
+
+	readRecord := func(i int) []byte {
+		_, err := file.ReadAt(record, int64(hashIndexHeaderSize)+int64(i)*hashIndexRecordSize)
+
+		if err != nil {
+			readErr = err
+		}
+
+		return record
+	}
+
+	first := sort.Search(count, func(i int) bool {
+		return bytes.Compare(readRecord(i)[:sha1Size], wanted) >= 0
+	})
+
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	paths := make([]string, 0)
+
+	for i := first; i < count; i++ {
+		r := readRecord(i)
+
+		if readErr != nil {
+			return nil, readErr
+		}
+
+		if !bytes.Equal(r[:sha1Size], wanted) {
+			break
+		}
+
+		p, err := readIndexedPath(file, pathsOffset+int64(binary.BigEndian.Uint64(r[sha1Size:])))
+
+		if err != nil {
+			return nil, err
+		}
+
+		paths = append(paths, p)
+	}
+
+	return paths, nil
+}
+
+func readIndexedPath(file *os.File, offset int64) (string, error) {
+	length := make([]byte, 8)
+
+	if _, err := file.ReadAt(length, offset); err != nil {
+		return "", err
+	}
+
+	p := make([]byte, binary.BigEndian.Uint64(length))
+
+	if _, err := file.ReadAt(p, offset+8); err != nil {
+		return "", err
+	}
+
+	return string(p), nil
+}
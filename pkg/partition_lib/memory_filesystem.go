@@ -0,0 +1,263 @@
+package partition_lib
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryFileSystem is FileSystem that keeps files in memory, for fast and
+// deterministic tests: nothing touches the disk, and mtimes are whatever
+// WriteFile() is given. Directories are implied by paths of files, so
+// there are no empty directories
+//
+// Safe for concurrent use
+type MemoryFileSystem struct {
+	mutex sync.RWMutex
+
+	// Regular files by name
+	files map[string]*memoryFile
+}
+
+type memoryFile struct {
+	// Never modified in place, so open files can keep reading it
+	data    []byte
+	modTime time.Time
+}
+
+func NewMemoryFileSystem() *MemoryFileSystem {
+	return &MemoryFileSystem{files: make(map[string]*memoryFile)}
+}
+
+// WriteFile creates or replaces the file, creating its parent directories
+func (m *MemoryFileSystem) WriteFile(name string, data []byte, modTime time.Time) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrInvalid}
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.isDir(name) {
+		return &fs.PathError{Op: "write", Path: name, Err: errors.New("is a directory")}
+	}
+
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if m.files[dir] != nil {
+			return &fs.PathError{Op: "write", Path: name, Err: errors.New("not a directory")}
+		}
+	}
+
+	m.files[name] = &memoryFile{data: bytes.Clone(data), modTime: modTime}
+	return nil
+}
+
+func (m *MemoryFileSystem) Remove(name string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.files[name] == nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+
+	delete(m.files, name)
+	return nil
+}
+
+func (m *MemoryFileSystem) Open(name string) (fs.File, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	info, err := m.stat("open", name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if info.isDir {
+		return &memoryOpenDir{info: info, entries: m.readDir(name)}, nil
+	}
+
+	return &memoryOpenFile{info: info, Reader: bytes.NewReader(m.files[name].data)}, nil
+}
+
+func (m *MemoryFileSystem) Stat(name string) (fs.FileInfo, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return m.stat("stat", name)
+}
+
+// Implements fs.ReadDirFS, which fs.WalkDir() uses
+func (m *MemoryFileSystem) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	info, err := m.stat("readdir", name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.isDir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+
+	return m.readDir(name), nil
+}
+
+func (m *MemoryFileSystem) WalkDir(root string, fn fs.WalkDirFunc) error {
+	return fs.WalkDir(m, root, fn)
+}
+
+func (m *MemoryFileSystem) WriteFileAtomic(name string, write func(w io.Writer) error) error {
+	var buffer bytes.Buffer
+
+	if err := write(&buffer); err != nil {
+		return err
+	}
+
+	return m.WriteFile(name, buffer.Bytes(), time.Now())
+}
+
+// Must be called with the mutex held
+func (m *MemoryFileSystem) stat(op string, name string) (*memoryFileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	if file := m.files[name]; file != nil {
+		info := memoryFileInfo{
+			name:    path.Base(name),
+			size:    int64(len(file.data)),
+			modTime: file.modTime,
+		}
+
+		return &info, nil
+	}
+
+	if m.isDir(name) {
+		return &memoryFileInfo{name: path.Base(name), isDir: true}, nil
+	}
+
+	return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
+
+// Must be called with the mutex held
+func (m *MemoryFileSystem) isDir(name string) bool {
+	if name == "." {
+		return true
+	}
+
+	for fileName := range m.files {
+		if strings.HasPrefix(fileName, name+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Sorted by name. Must be called with the mutex held
+func (m *MemoryFileSystem) readDir(name string) []fs.DirEntry {
+	prefix := name + "/"
+
+	if name == "." {
+		prefix = ""
+	}
+
+	entries := make(map[string]*memoryFileInfo)
+
+	for fileName, file := range m.files {
+		rest, found := strings.CutPrefix(fileName, prefix)
+
+		if !found {
+			continue
+		}
+
+		if child, _, isNested := strings.Cut(rest, "/"); isNested {
+			entries[child] = &memoryFileInfo{name: child, isDir: true}
+			continue
+		}
+
+		entries[rest] = &memoryFileInfo{
+			name:    rest,
+			size:    int64(len(file.data)),
+			modTime: file.modTime,
+		}
+	}
+
+	result := make([]fs.DirEntry, 0, len(entries))
+
+	for _, childName := range sortedKeys(entries) {
+		result = append(result, entries[childName])
+	}
+
+	return result
+}
+
+type memoryFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+func (i *memoryFileInfo) Name() string               { return i.name }
+func (i *memoryFileInfo) Size() int64                { return i.size }
+func (i *memoryFileInfo) ModTime() time.Time         { return i.modTime }
+func (i *memoryFileInfo) IsDir() bool                { return i.isDir }
+func (i *memoryFileInfo) Sys() any                   { return nil }
+func (i *memoryFileInfo) Type() fs.FileMode          { return i.Mode().Type() }
+func (i *memoryFileInfo) Info() (fs.FileInfo, error) { return i, nil }
+
+func (i *memoryFileInfo) Mode() fs.FileMode {
+	if i.isDir {
+		return fs.ModeDir | 0o755
+	}
+
+	return 0o644
+}
+
+type memoryOpenFile struct {
+	info *memoryFileInfo
+	*bytes.Reader
+}
+
+func (f *memoryOpenFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memoryOpenFile) Close() error               { return nil }
+
+type memoryOpenDir struct {
+	info    *memoryFileInfo
+	entries []fs.DirEntry
+}
+
+func (d *memoryOpenDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *memoryOpenDir) Close() error               { return nil }
+
+func (d *memoryOpenDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errors.New("is a directory")}
+}
+
+func (d *memoryOpenDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+
+		return entries, nil
+	}
+
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	entries := d.entries[:min(n, len(d.entries))]
+	d.entries = d.entries[len(entries):]
+
+	return entries, nil
+}
@@ -0,0 +1,401 @@
+package partition_lib
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/azerum/data-storage-suite/pkg/utils"
+)
+
+// File metadata (type, mode or time) differs from the mtree spec, or bag
+// payload differs from its bag-info.txt
+type MetadataDoesNotMatch struct {
+	ManifestPath string
+
+	// mtree keyword, e.g. mode, or bag-info.txt label, e.g. Payload-Oxum
+	Keyword  string
+	Actual   string
+	Expected string
+}
+
+func (m MetadataDoesNotMatch) isManifestMismatch() {}
+
+// WriteMtree writes an mtree(5) spec of the partition, one full path per
+// line (like `mtree -C`), with type, mode, size, time and sha256digest of
+// each file, and type and mode of each directory
+//
+// Sizes and times are taken from the manifest, modes - from the files.
+// SHA-256 requires reading files, so each file is also verified against
+// its SHA-1 in the manifest, and WriteMtree fails if it does not match
+func (partition *Partition) WriteMtree(ctx context.Context, w io.Writer) error {
+	if partition.manifest == nil {
+		return fmt.Errorf("partition %s has no manifest", partition.AbsoluteDirOsPath)
+	}
+
+	buffered := bufio.NewWriter(w)
+	fmt.Fprintln(buffered, "#mtree")
+
+	dirs := map[string]struct{}{".": {}}
+
+	for manifestPath := range partition.manifest.Files {
+		for dir := path.Dir(manifestPath); dir != "."; dir = path.Dir(dir) {
+			dirs[dir] = struct{}{}
+		}
+	}
+
+	for _, dir := range sortedKeys(dirs) {
+		info, err := os.Stat(partition.toAbsoluteOsPath(dir))
+
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(buffered, "%s type=dir mode=%#o\n", mtreeName(dir), info.Mode().Perm())
+	}
+
+	for _, manifestPath := range sortedKeys(partition.manifest.Files) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		entry := partition.manifest.Files[manifestPath]
+
+		info, err := os.Stat(partition.toAbsoluteOsPath(manifestPath))
+
+		if err != nil {
+			return err
+		}
+
+		sha256Hash, err := partition.sha256Verified(manifestPath, entry)
+
+		if err != nil {
+			return err
+		}
+
+		size := entry.Size
+
+		if size == unknownSize {
+			size = info.Size()
+		}
+
+		fmt.Fprintf(
+			buffered,
+			"%s type=file mode=%#o size=%d time=%d.000000000 sha256digest=%s\n",
+			mtreeName(manifestPath),
+			info.Mode().Perm(),
+			size,
+			entry.Mtime,
+			sha256Hash,
+		)
+	}
+
+	// bufio.Writer remembers the first error
+	return buffered.Flush()
+}
+
+// CheckMtree verifies the partition files against an mtree spec, reporting
+// the same mismatches as Check(): files not in the spec are FileNotHashed,
+// files in the spec but absent are FileMissing. Size, digest (sha256digest,
+// sha1digest or md5digest) and type, mode & time (with 1s precision) of
+// files are compared, if present in the spec
+//
+// Only `type=file` entries (or ones with no type) are checked. The
+// partition manifest is not used
+func (partition *Partition) CheckMtree(ctx context.Context, spec io.Reader) *utils.ChanWithError[ManifestMismatch] {
+	out := utils.NewChanWithError[ManifestMismatch](1)
+	go checkMtreeWorker(partition, spec, out, ctx)
+
+	return out
+}
+
+func checkMtreeWorker(
+	partition *Partition,
+	spec io.Reader,
+	out *utils.ChanWithError[ManifestMismatch],
+	ctx context.Context,
+) {
+	entries, err := parseMtree(spec)
+
+	if err != nil {
+		out.CloseWithError(err)
+		return
+	}
+
+	seenInPartition := make(map[string]struct{})
+
+	walk := func(absoluteOsPath string, manifestPath string, entry fs.DirEntry) error {
+		seenInPartition[manifestPath] = struct{}{}
+		keywords := entries[manifestPath]
+
+		if keywords == nil {
+			out.Channel <- FileNotHashed{ManifestPath: manifestPath}
+			return nil
+		}
+
+		mismatch, err := checkMtreeEntry(absoluteOsPath, manifestPath, keywords)
+
+		if err != nil {
+			return err
+		}
+
+		if mismatch != nil {
+			out.Channel <- mismatch
+		}
+
+		return nil
+	}
+
+	if err := partition.Walk(walk, ctx); err != nil {
+		out.CloseWithError(err)
+		return
+	}
+
+	for _, p := range sortedKeys(entries) {
+		_, seen := seenInPartition[p]
+		fileType := entries[p]["type"]
+
+		if !seen && (fileType == "" || fileType == "file") {
+			out.Channel <- FileMissing{ManifestPath: p}
+		}
+	}
+
+	out.CloseOk()
+}
+
+func checkMtreeEntry(absoluteOsPath string, manifestPath string, keywords map[string]string) (ManifestMismatch, error) {
+	info, err := os.Lstat(absoluteOsPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	actualType := "file"
+
+	if !info.Mode().IsRegular() {
+		actualType = "link"
+	}
+
+	if expected, ok := keywords["type"]; ok && expected != actualType {
+		return MetadataDoesNotMatch{ManifestPath: manifestPath, Keyword: "type", Actual: actualType, Expected: expected}, nil
+	}
+
+	if expected, ok := keywords["size"]; ok {
+		expectedSize, err := strconv.ParseInt(expected, 10, 64)
+
+		if err == nil && expectedSize != info.Size() {
+			return SizeDoesNotMatch{ManifestPath: manifestPath, ActualSize: info.Size(), ExpectedSize: expectedSize}, nil
+		}
+	}
+
+	if expected, ok := keywords["mode"]; ok {
+		expectedMode, err := strconv.ParseUint(expected, 8, 32)
+
+		if err == nil && fs.FileMode(expectedMode).Perm() != info.Mode().Perm() {
+			actual := fmt.Sprintf("%#o", info.Mode().Perm())
+			return MetadataDoesNotMatch{ManifestPath: manifestPath, Keyword: "mode", Actual: actual, Expected: expected}, nil
+		}
+	}
+
+	if expected, ok := keywords["time"]; ok {
+		seconds, _, _ := strings.Cut(expected, ".")
+		expectedTime, err := strconv.ParseInt(seconds, 10, 64)
+
+		if err == nil && expectedTime != info.ModTime().Unix() {
+			actual := fmt.Sprintf("%d.000000000", info.ModTime().Unix())
+			return MetadataDoesNotMatch{ManifestPath: manifestPath, Keyword: "time", Actual: actual, Expected: expected}, nil
+		}
+	}
+
+	for _, keyword := range []string{"sha256digest", "sha1digest", "md5digest"} {
+		expected, ok := keywords[keyword]
+
+		if !ok {
+			continue
+		}
+
+		algorithm := strings.TrimSuffix(keyword, "digest")
+		actual, err := hashFileWith(absoluteOsPath, algorithm)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if actual != strings.ToLower(expected) {
+			return HashDoesNotMatch{ManifestPath: manifestPath, ActualHash: actual, ExpectedHash: expected}, nil
+		}
+
+		// The strongest digest is enough
+		break
+	}
+
+	return nil, nil
+}
+
+func hashFileWith(absoluteOsPath string, algorithm string) (string, error) {
+	file, err := os.Open(absoluteOsPath)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer file.Close()
+
+	hasher := newChecksumHasher(algorithm)
+
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
+}
+
+// Parses both full-path (`mtree -C`) and hierarchical (default `mtree -c`)
+// formats. Returns keywords by manifest path. Root dir is "."
+func parseMtree(spec io.Reader) (map[string]map[string]string, error) {
+	entries := make(map[string]map[string]string)
+	defaults := make(map[string]string)
+	cwd := make([]string, 0)
+
+	scanner := bufio.NewScanner(spec)
+	scanner.Buffer(nil, 1024*1024)
+
+	lineNumber := 0
+	continued := ""
+
+	for scanner.Scan() {
+		lineNumber++
+		line := continued + scanner.Text()
+		continued = ""
+
+		if strings.HasSuffix(line, "\\") {
+			continued = strings.TrimSuffix(line, "\\") + " "
+			continue
+		}
+
+		fields := strings.Fields(line)
+
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		switch fields[0] {
+		case "/set":
+			for _, kv := range fields[1:] {
+				k, v, _ := strings.Cut(kv, "=")
+				defaults[k] = v
+			}
+
+			continue
+
+		case "/unset":
+			for _, k := range fields[1:] {
+				if k == "all" {
+					clear(defaults)
+				}
+
+				delete(defaults, k)
+			}
+
+			continue
+
+		case "..":
+			if len(cwd) == 0 {
+				return nil, fmt.Errorf("line %d: .. above the root", lineNumber)
+			}
+
+			cwd = cwd[:len(cwd)-1]
+			continue
+		}
+
+		keywords := make(map[string]string)
+
+		for k, v := range defaults {
+			keywords[k] = v
+		}
+
+		for _, kv := range fields[1:] {
+			k, v, _ := strings.Cut(kv, "=")
+			keywords[k] = v
+		}
+
+		name := unvis(fields[0])
+		var manifestPath string
+
+		if strings.Contains(name, "/") {
+			manifestPath = path.Clean(name)
+		} else {
+			manifestPath = path.Clean(path.Join(append(slices.Clone(cwd), name)...))
+
+			// In hierarchical format, directory entry changes the current dir
+			if keywords["type"] == "dir" && manifestPath != "." {
+				cwd = append(cwd, name)
+			}
+		}
+
+		entries[manifestPath] = keywords
+	}
+
+	return entries, scanner.Err()
+}
+
+// mtree names are relative to the root and vis(3)-encoded
+func mtreeName(manifestPath string) string {
+	if manifestPath == "." {
+		return "."
+	}
+
+	return "./" + vis(manifestPath)
+}
+
+// Encodes whitespace, `\`, `#`, `*`, `?`, `[` and non-printable bytes as
+// \ooo
+func vis(s string) string {
+	b := strings.Builder{}
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		if c <= ' ' || c >= 0x7f || strings.IndexByte("\\#*?[", c) >= 0 {
+			fmt.Fprintf(&b, "\\%03o", c)
+			continue
+		}
+
+		b.WriteByte(c)
+	}
+
+	return b.String()
+}
+
+func unvis(s string) string {
+	b := strings.Builder{}
+
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && isOctal(s[i+1]) && isOctal(s[i+2]) && isOctal(s[i+3]) {
+			value, _ := strconv.ParseUint(s[i+1:i+4], 8, 8)
+			b.WriteByte(byte(value))
+			i += 3
+
+			continue
+		}
+
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+
+		b.WriteByte(s[i])
+	}
+
+	return b.String()
+}
+
+func isOctal(c byte) bool {
+	return c >= '0' && c <= '7'
+}
@@ -0,0 +1,194 @@
+package partition_lib
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/azerum/data-storage-suite/pkg/utils"
+)
+
+// Pack writes files of the partition and its manifest into a .tar or .zip
+// archive (format is chosen by the extension). Archive can be checked with
+// LoadPartitionFS() without extracting it
+//
+// Contents is verified against the manifest while being written. If any
+// file does not match, the archive is not created. Existing archive is
+// replaced atomically
+func (partition *Partition) Pack(ctx context.Context, archivePath string) error {
+	if partition.manifest == nil {
+		return fmt.Errorf("partition %s has no manifest", partition.AbsoluteDirOsPath)
+	}
+
+	manifestBytes, err := partition.Serialize()
+
+	if err != nil {
+		return err
+	}
+
+	var newArchive func(w io.Writer) archiveWriter
+
+	switch strings.ToLower(filepath.Ext(archivePath)) {
+	case ".tar":
+		newArchive = newTarArchive
+
+	case ".zip":
+		newArchive = newZipArchive
+
+	default:
+		return fmt.Errorf("%s is not a .tar or .zip archive", archivePath)
+	}
+
+	return utils.OverwriteWith(archivePath, archivePath+partialFileSuffix, func(tmpFile *os.File) error {
+		archive := newArchive(tmpFile)
+
+		manifestHeader := archiveFileHeader{
+			name:    manifestFileName,
+			size:    int64(len(manifestBytes)),
+			mode:    0o644,
+			modTime: time.Now(),
+		}
+
+		w, err := archive.create(manifestHeader)
+
+		if err != nil {
+			return err
+		}
+
+		if _, err := w.Write(manifestBytes); err != nil {
+			return err
+		}
+
+		for _, manifestPath := range sortedKeys(partition.manifest.Files) {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			if err := partition.packFile(archive, manifestPath); err != nil {
+				return errors.Join(fmt.Errorf("failed to pack %s", manifestPath), err)
+			}
+		}
+
+		return archive.close()
+	})
+}
+
+func (partition *Partition) packFile(archive archiveWriter, manifestPath string) error {
+	entry := partition.manifest.Files[manifestPath]
+	file, err := partition.files.Open(manifestPath)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	info, err := file.Stat()
+
+	if err != nil {
+		return err
+	}
+
+	if entry.Size != unknownSize && entry.Size != info.Size() {
+		return fmt.Errorf("size %d does not match the manifest (%d)", info.Size(), entry.Size)
+	}
+
+	header := archiveFileHeader{
+		name:    manifestPath,
+		size:    info.Size(),
+		mode:    info.Mode().Perm(),
+		modTime: time.Unix(entry.Mtime, 0),
+	}
+
+	w, err := archive.create(header)
+
+	if err != nil {
+		return err
+	}
+
+	hasher := sha1.New()
+
+	if _, err := io.Copy(io.MultiWriter(w, hasher), file); err != nil {
+		return err
+	}
+
+	if hash := fmt.Sprintf("%x", hasher.Sum(nil)); hash != entry.Hash {
+		return fmt.Errorf("hash %s does not match the manifest (%s)", hash, entry.Hash)
+	}
+
+	return nil
+}
+
+type archiveFileHeader struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+// Common subset of tar.Writer and zip.Writer
+type archiveWriter interface {
+	create(header archiveFileHeader) (io.Writer, error)
+	close() error
+}
+
+type tarArchive struct {
+	writer *tar.Writer
+}
+
+func newTarArchive(w io.Writer) archiveWriter {
+	return &tarArchive{writer: tar.NewWriter(w)}
+}
+
+func (a *tarArchive) create(header archiveFileHeader) (io.Writer, error) {
+	tarHeader := tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     header.name,
+		Size:     header.size,
+		Mode:     int64(header.mode),
+		ModTime:  header.modTime,
+		Format:   tar.FormatPAX,
+	}
+
+	if err := a.writer.WriteHeader(&tarHeader); err != nil {
+		return nil, err
+	}
+
+	return a.writer, nil
+}
+
+func (a *tarArchive) close() error {
+	return a.writer.Close()
+}
+
+type zipArchive struct {
+	writer *zip.Writer
+}
+
+func newZipArchive(w io.Writer) archiveWriter {
+	return &zipArchive{writer: zip.NewWriter(w)}
+}
+
+func (a *zipArchive) create(header archiveFileHeader) (io.Writer, error) {
+	zipHeader := zip.FileHeader{
+		Name:     header.name,
+		Method:   zip.Deflate,
+		Modified: header.modTime,
+	}
+
+	zipHeader.SetMode(header.mode)
+	return a.writer.CreateHeader(&zipHeader)
+}
+
+func (a *zipArchive) close() error {
+	return a.writer.Close()
+}
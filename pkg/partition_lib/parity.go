@@ -0,0 +1,491 @@
+package partition_lib
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+
+	"github.com/azerum/data-storage-suite/pkg/utils"
+	"github.com/klauspost/reedsolomon"
+)
+
+// Parity lets a partition repair itself without a replica, like par2
+//
+// Files of the partition, in the order of sorted manifest paths, are seen as
+// one stream of bytes, split into blocks. Blocks are spread over groups,
+// round-robin: block j belongs to group j % groups. For each group,
+// Reed-Solomon parity blocks are computed. Any parity blocks-many damaged
+// blocks of a group can be reconstructed. Thanks to round-robin, a long
+// damaged range (e.g. a whole lost file) is spread evenly over the groups
+//
+// Hashes of all blocks are stored, so damaged blocks can be found. Parity
+// is tied to the manifest it was computed for, and becomes outdated once
+// the manifest changes
+const parityFileName = ".parity.bin"
+const parityTmpFileName = parityFileName + ".tmp"
+const parityMetadataFileName = ".parity.json"
+const parityMetadataTmpFileName = parityMetadataFileName + ".tmp"
+
+// Reed-Solomon over GF(2^8) supports at most 256 shards
+const maxShardsPerGroup = 256
+
+// Bounds size of the parity metadata, which stores hash of each block
+const maxDataBlocks = 32768
+
+const minParityBlockSize = 64 * 1024
+
+// Blocks are read and encoded in slices of this size, to bound memory use
+const maxParitySliceSize = 1024 * 1024
+
+type parityMetadata struct {
+	// .dataHash of the manifest the parity was computed for
+	ManifestDataHash string `json:"manifestDataHash"`
+
+	BlockSize int64 `json:"blockSize"`
+
+	// Per group
+	DataShards   int `json:"dataShards"`
+	ParityShards int `json:"parityShards"`
+
+	Groups int `json:"groups"`
+
+	// Only blocks that contain data. Trailing blocks of the last round of
+	// groups are virtual, all zeros
+	DataBlockHashes []string `json:"dataBlockHashes"`
+
+	// Parity block for shard m of group g is at index g * ParityShards + m
+	ParityBlockHashes []string `json:"parityBlockHashes"`
+}
+
+// Parity was computed for a different manifest. Run Protect() again
+type ParityOutdated struct{}
+
+func (m ParityOutdated) isManifestMismatch() {}
+
+// Parity file is missing or some of its blocks do not match their hashes
+type ParityDamaged struct {
+	Reason string
+}
+
+func (m ParityDamaged) isManifestMismatch() {}
+
+// Protect computes parity for the partition files, so that up to
+// `redundancy` fraction (e.g. 0.1) of them can be reconstructed by
+// RepairFromParity(). Replaces previous parity, if any
+//
+// Files are assumed to match the manifest, so run Check() first: parity
+// protects files as they are
+//
+// Only for partitions in OS directories. Check() verifies parity of any
+// partition
+func (partition *Partition) Protect(ctx context.Context, redundancy float64) error {
+	if _, isOs := partition.files.(*osFileSystem); !isOs {
+		return fmt.Errorf("cannot protect %s: not an OS directory", partition.AbsoluteDirOsPath)
+	}
+
+	if partition.manifest == nil {
+		return fmt.Errorf("partition %s has no manifest", partition.AbsoluteDirOsPath)
+	}
+
+	if redundancy <= 0 || redundancy > 1 {
+		return fmt.Errorf("redundancy must be in (0, 1], got %v", redundancy)
+	}
+
+	stream, err := partition.newPartitionStream()
+
+	if err != nil {
+		return err
+	}
+
+	wrapper, err := partition.manifest.wrap()
+
+	if err != nil {
+		return err
+	}
+
+	metadata := newParityMetadata(wrapper.DataHash, stream.size, redundancy)
+
+	// Old metadata would not match the new parity file, so remove it
+	// first. Parity file without metadata is ignored
+	metadataPath := filepath.Join(partition.AbsoluteDirOsPath, parityMetadataFileName)
+
+	if err := os.Remove(metadataPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	err = utils.OverwriteWith(
+		filepath.Join(partition.AbsoluteDirOsPath, parityFileName),
+		filepath.Join(partition.AbsoluteDirOsPath, parityTmpFileName),
+		func(tmpFile *os.File) error {
+			return metadata.encode(ctx, stream, tmpFile)
+		},
+	)
+
+	if err != nil {
+		return err
+	}
+
+	metadataWrapper, err := wrapJson(metadata)
+
+	if err != nil {
+		return err
+	}
+
+	metadataBytes, err := json.Marshal(metadataWrapper)
+
+	if err != nil {
+		return err
+	}
+
+	return utils.Overwrite(
+		metadataPath,
+		filepath.Join(partition.AbsoluteDirOsPath, parityMetadataTmpFileName),
+		metadataBytes,
+	)
+}
+
+func newParityMetadata(manifestDataHash string, streamSize int64, redundancy float64) *parityMetadata {
+	blockSize := max(minParityBlockSize, divCeil(streamSize, maxDataBlocks))
+
+	// Round up to 4 KiB, the typical FS block size
+	blockSize = divCeil(blockSize, 4096) * 4096
+
+	dataBlocks := int(divCeil(streamSize, blockSize))
+
+	dataShards := int(math.Floor(maxShardsPerGroup / (1 + redundancy)))
+	dataShards = max(1, min(dataShards, dataBlocks))
+
+	parityShards := int(math.Ceil(float64(dataShards) * redundancy))
+
+	if dataShards+parityShards > maxShardsPerGroup {
+		dataShards = maxShardsPerGroup - parityShards
+	}
+
+	groups := max(1, int(divCeil(int64(dataBlocks), int64(dataShards))))
+
+	return &parityMetadata{
+		ManifestDataHash:  manifestDataHash,
+		BlockSize:         blockSize,
+		DataShards:        dataShards,
+		ParityShards:      parityShards,
+		Groups:            groups,
+		DataBlockHashes:   make([]string, dataBlocks),
+		ParityBlockHashes: make([]string, groups*parityShards),
+	}
+}
+
+// Computes parity blocks and writes them to parityFile. Fills in hashes
+// of data & parity blocks
+func (metadata *parityMetadata) encode(
+	ctx context.Context,
+	stream *partitionStream,
+	parityFile *os.File,
+) error {
+	encoder, err := reedsolomon.New(metadata.DataShards, metadata.ParityShards)
+
+	if err != nil {
+		return err
+	}
+
+	sliceSize := min(metadata.BlockSize, maxParitySliceSize)
+	shards := metadata.newShards(sliceSize)
+
+	for g := range metadata.Groups {
+		hashers := make([]io.Writer, len(shards))
+		sums := make([]func() string, len(shards))
+
+		for i := range shards {
+			h := sha1.New()
+			hashers[i] = h
+			sums[i] = func() string { return fmt.Sprintf("%x", h.Sum(nil)) }
+		}
+
+		for offset := int64(0); offset < metadata.BlockSize; offset += sliceSize {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			length := min(sliceSize, metadata.BlockSize-offset)
+
+			for k := range metadata.DataShards {
+				shards[k] = shards[k][:length]
+				blockOffset := metadata.dataBlockOffset(g, k)
+
+				if err := stream.readAt(shards[k], blockOffset+offset); err != nil {
+					return err
+				}
+			}
+
+			for m := range metadata.ParityShards {
+				shards[metadata.DataShards+m] = shards[metadata.DataShards+m][:length]
+			}
+
+			if err := encoder.Encode(shards); err != nil {
+				return err
+			}
+
+			for i, s := range shards {
+				hashers[i].Write(s)
+			}
+
+			for m := range metadata.ParityShards {
+				position := metadata.parityBlockOffset(g, m) + offset
+
+				if _, err := parityFile.WriteAt(shards[metadata.DataShards+m], position); err != nil {
+					return err
+				}
+			}
+		}
+
+		for k := range metadata.DataShards {
+			if j := metadata.dataBlockIndex(g, k); j < len(metadata.DataBlockHashes) {
+				metadata.DataBlockHashes[j] = sums[k]()
+			}
+		}
+
+		for m := range metadata.ParityShards {
+			metadata.ParityBlockHashes[g*metadata.ParityShards+m] = sums[metadata.DataShards+m]()
+		}
+	}
+
+	return nil
+}
+
+func (metadata *parityMetadata) newShards(sliceSize int64) [][]byte {
+	shards := make([][]byte, metadata.DataShards+metadata.ParityShards)
+
+	for i := range shards {
+		shards[i] = make([]byte, sliceSize)
+	}
+
+	return shards
+}
+
+func (metadata *parityMetadata) dataBlockIndex(group int, shard int) int {
+	return shard*metadata.Groups + group
+}
+
+func (metadata *parityMetadata) dataBlockOffset(group int, shard int) int64 {
+	return int64(metadata.dataBlockIndex(group, shard)) * metadata.BlockSize
+}
+
+func (metadata *parityMetadata) parityBlockOffset(group int, shard int) int64 {
+	return int64(group*metadata.ParityShards+shard) * metadata.BlockSize
+}
+
+// Returns nil if the partition has no parity
+func (partition *Partition) loadParityMetadata() (*parityMetadata, error) {
+	bytes, err := fs.ReadFile(partition.files, parityMetadataFileName)
+
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	var metadata parityMetadata
+
+	if err := unwrapJson(bytes, &metadata); err != nil {
+		fullErr := errors.Join(
+			errors.New("while loading parity metadata"),
+			err,
+		)
+
+		return nil, fullErr
+	}
+
+	return &metadata, nil
+}
+
+// Reports ParityOutdated or ParityDamaged, if the partition has parity
+func (partition *Partition) checkParity(ctx context.Context, out chan<- ManifestMismatch) error {
+	metadata, err := partition.loadParityMetadata()
+
+	if err != nil || metadata == nil {
+		return err
+	}
+
+	wrapper, err := partition.manifest.wrap()
+
+	if err != nil {
+		return err
+	}
+
+	if wrapper.DataHash != metadata.ManifestDataHash {
+		out <- ParityOutdated{}
+		return nil
+	}
+
+	damagedBlocks, err := partition.findDamagedParityBlocks(ctx, metadata)
+
+	if err != nil {
+		return err
+	}
+
+	if len(damagedBlocks) > 0 {
+		out <- ParityDamaged{
+			Reason: fmt.Sprintf("%d of %d parity blocks do not match", len(damagedBlocks), len(metadata.ParityBlockHashes)),
+		}
+	}
+
+	return nil
+}
+
+// Returns indexes of parity blocks that do not match their hashes.
+// Missing or short parity file makes the missing blocks damaged
+func (partition *Partition) findDamagedParityBlocks(
+	ctx context.Context,
+	metadata *parityMetadata,
+) ([]int, error) {
+	damaged := make([]int, 0)
+	file, err := partition.files.Open(parityFileName)
+
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+
+		for i := range metadata.ParityBlockHashes {
+			damaged = append(damaged, i)
+		}
+
+		return damaged, nil
+	}
+
+	defer file.Close()
+
+	for i, expectedHash := range metadata.ParityBlockHashes {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		hasher := sha1.New()
+		n, err := io.Copy(hasher, io.LimitReader(file, metadata.BlockSize))
+
+		if err != nil {
+			return nil, err
+		}
+
+		if n != metadata.BlockSize || fmt.Sprintf("%x", hasher.Sum(nil)) != expectedHash {
+			damaged = append(damaged, i)
+		}
+	}
+
+	return damaged, nil
+}
+
+// Files of the partition as one stream of bytes, in the order of sorted
+// manifest paths. Bytes past the end of the stream, as well as bytes of
+// missing or short files, read as zeros
+type partitionStream struct {
+	partition *Partition
+
+	manifestPaths []string
+
+	// Offset in the stream where each file starts
+	offsets []int64
+
+	size int64
+}
+
+func (partition *Partition) newPartitionStream() (*partitionStream, error) {
+	paths := make([]string, 0, len(partition.manifest.Files))
+
+	for p, entry := range partition.manifest.Files {
+		if entry.Size == unknownSize {
+			return nil, fmt.Errorf("size of %s is unknown. Re-hash the partition first", p)
+		}
+
+		paths = append(paths, p)
+	}
+
+	slices.Sort(paths)
+
+	offsets := make([]int64, len(paths))
+	size := int64(0)
+
+	for i, p := range paths {
+		offsets[i] = size
+		size += partition.manifest.Files[p].Size
+	}
+
+	stream := partitionStream{
+		partition:     partition,
+		manifestPaths: paths,
+		offsets:       offsets,
+		size:          size,
+	}
+
+	return &stream, nil
+}
+
+// Fills entire buf with bytes of the stream starting at offset
+func (stream *partitionStream) readAt(buf []byte, offset int64) error {
+	clear(buf)
+
+	// First file that ends after offset
+	i := sort.Search(len(stream.offsets), func(i int) bool {
+		return stream.fileEnd(i) > offset
+	})
+
+	for ; i < len(stream.manifestPaths) && len(buf) > 0; i++ {
+		start := stream.offsets[i]
+
+		if start >= offset+int64(len(buf)) {
+			break
+		}
+
+		// Part of buf covered by the file
+		bufFrom := max(0, start-offset)
+		bufTo := min(int64(len(buf)), stream.fileEnd(i)-offset)
+
+		err := stream.readFileAt(i, buf[bufFrom:bufTo], offset+bufFrom-start)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (stream *partitionStream) fileEnd(i int) int64 {
+	return stream.offsets[i] + stream.partition.manifest.Files[stream.manifestPaths[i]].Size
+}
+
+func (stream *partitionStream) readFileAt(i int, buf []byte, offset int64) error {
+	file, err := os.Open(stream.partition.toAbsoluteOsPath(stream.manifestPaths[i]))
+
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return err
+	}
+
+	defer file.Close()
+
+	_, err = file.ReadAt(buf, offset)
+
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+
+	return err
+}
+
+func divCeil(a int64, b int64) int64 {
+	return (a + b - 1) / b
+}
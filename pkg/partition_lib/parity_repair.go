@@ -0,0 +1,531 @@
+package partition_lib
+
+import (
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/azerum/data-storage-suite/pkg/utils"
+	"github.com/klauspost/reedsolomon"
+)
+
+// Holds reconstructed data blocks until damaged files are rebuilt from them
+const parityScratchFileName = ".parity-scratch" + partialFileSuffix
+
+type FileRepairedFromParity struct {
+	ManifestPath string
+}
+
+func (o FileRepairedFromParity) isRepairOutcome() {}
+
+// RepairFromParity is like Repair(), but reconstructs damaged files from
+// the partition's own parity (see Protect()) instead of replicas
+//
+// Fails if the partition has no parity, or parity is outdated. Only for
+// partitions in OS directories
+func (partition *Partition) RepairFromParity(
+	ctx context.Context,
+	mismatches []ManifestMismatch,
+) *utils.ChanWithError[RepairOutcome] {
+	out := utils.NewChanWithError[RepairOutcome](1)
+	go repairFromParityWorker(partition, mismatches, out, ctx)
+
+	return out
+}
+
+func repairFromParityWorker(
+	partition *Partition,
+	mismatches []ManifestMismatch,
+	out *utils.ChanWithError[RepairOutcome],
+	ctx context.Context,
+) {
+	if partition.manifest == nil {
+		out.CloseWithError(
+			fmt.Errorf("partition %s has no manifest", partition.AbsoluteDirOsPath),
+		)
+
+		return
+	}
+
+	r, err := partition.newParityRepair(ctx)
+
+	if err != nil {
+		out.CloseWithError(err)
+		return
+	}
+
+	defer r.close()
+
+	damagedPaths := make([]string, 0)
+
+	for _, m := range mismatches {
+		p, repairable := repairableManifestPath(m)
+
+		if !repairable || partition.manifest.Files[p] == nil {
+			continue
+		}
+
+		// Parity holds contents from before the edit
+		modified, err := partition.isModifiedSinceHash(m)
+
+		if err != nil {
+			out.CloseWithError(err)
+			return
+		}
+
+		if modified {
+			out.Channel <- fileModifiedSinceHash(p)
+			continue
+		}
+
+		damagedPaths = append(damagedPaths, p)
+	}
+
+	slices.Sort(damagedPaths)
+	damagedPaths = slices.Compact(damagedPaths)
+
+	groups := make(map[int]struct{})
+
+	for _, p := range damagedPaths {
+		for _, j := range r.fileBlocks(p) {
+			groups[j%r.metadata.Groups] = struct{}{}
+		}
+	}
+
+	for g := range groups {
+		if err := r.reconstructGroup(g); err != nil {
+			out.CloseWithError(err)
+			return
+		}
+	}
+
+	for _, p := range damagedPaths {
+		if err := ctx.Err(); err != nil {
+			out.CloseWithError(err)
+			return
+		}
+
+		outcome, err := r.rebuildFile(p)
+
+		if err != nil {
+			out.CloseWithError(err)
+			return
+		}
+
+		out.Channel <- outcome
+	}
+
+	out.CloseOk()
+}
+
+// RepairFromParityAndReplicas repairs files from parity first, as it does
+// not need other disks, then looks up files parity could not restore in the
+// replicas (see Repair()). Files modified since they were hashed are
+// reported once and repaired from neither
+func (partition *Partition) RepairFromParityAndReplicas(
+	ctx context.Context,
+	mismatches []ManifestMismatch,
+	replicas []*Partition,
+) *utils.ChanWithError[RepairOutcome] {
+	out := utils.NewChanWithError[RepairOutcome](1)
+	go repairFromParityAndReplicasWorker(partition, mismatches, replicas, out, ctx)
+
+	return out
+}
+
+func repairFromParityAndReplicasWorker(
+	partition *Partition,
+	mismatches []ManifestMismatch,
+	replicas []*Partition,
+	out *utils.ChanWithError[RepairOutcome],
+	ctx context.Context,
+) {
+	fromParity := partition.RepairFromParity(ctx, mismatches)
+	notRepairedPaths := make(map[string]struct{})
+
+	for o := range fromParity.Channel {
+		notRepaired, ok := o.(FileNotRepaired)
+
+		if ok && !notRepaired.ModifiedSinceHash && len(replicas) > 0 {
+			notRepairedPaths[notRepaired.ManifestPath] = struct{}{}
+			continue
+		}
+
+		out.Channel <- o
+	}
+
+	if fromParity.Err != nil {
+		out.CloseWithError(fromParity.Err)
+		return
+	}
+
+	// Original mismatches are passed on, so Repair() can tell damaged
+	// files from modified ones the same way
+	remaining := make([]ManifestMismatch, 0, len(notRepairedPaths))
+
+	for _, m := range mismatches {
+		p, _ := repairableManifestPath(m)
+
+		if _, notRepaired := notRepairedPaths[p]; notRepaired {
+			remaining = append(remaining, m)
+		}
+	}
+
+	if len(remaining) == 0 {
+		out.CloseOk()
+		return
+	}
+
+	fromReplicas := partition.Repair(ctx, remaining, replicas)
+
+	for o := range fromReplicas.Channel {
+		out.Channel <- o
+	}
+
+	if fromReplicas.Err != nil {
+		out.CloseWithError(fromReplicas.Err)
+		return
+	}
+
+	out.CloseOk()
+}
+
+type parityRepair struct {
+	ctx       context.Context
+	partition *Partition
+	metadata  *parityMetadata
+	stream    *partitionStream
+	encoder   reedsolomon.Encoder
+
+	parityFile *os.File
+
+	damagedParityBlocks map[int]struct{}
+
+	// Data blocks that do not match their hashes
+	erasedBlocks map[int]struct{}
+
+	// Groups with more erased blocks than parity blocks
+	unrecoverableGroups map[int]struct{}
+
+	// Maps index of reconstructed data block to its slot in scratch file
+	scratchSlots map[int]int64
+	scratchFile  *os.File
+}
+
+func (partition *Partition) newParityRepair(ctx context.Context) (*parityRepair, error) {
+	// Reconstructed blocks are kept in a scratch file next to the parity
+	if _, isOs := partition.files.(*osFileSystem); !isOs {
+		return nil, fmt.Errorf("cannot repair %s from parity: not an OS directory", partition.AbsoluteDirOsPath)
+	}
+
+	metadata, err := partition.loadParityMetadata()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if metadata == nil {
+		return nil, fmt.Errorf("partition %s has no parity", partition.AbsoluteDirOsPath)
+	}
+
+	wrapper, err := partition.manifest.wrap()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if wrapper.DataHash != metadata.ManifestDataHash {
+		return nil, fmt.Errorf("parity of %s is outdated", partition.AbsoluteDirOsPath)
+	}
+
+	stream, err := partition.newPartitionStream()
+
+	if err != nil {
+		return nil, err
+	}
+
+	encoder, err := reedsolomon.New(metadata.DataShards, metadata.ParityShards)
+
+	if err != nil {
+		return nil, err
+	}
+
+	damaged, err := partition.findDamagedParityBlocks(ctx, metadata)
+
+	if err != nil {
+		return nil, err
+	}
+
+	damagedParityBlocks := make(map[int]struct{})
+
+	for _, i := range damaged {
+		damagedParityBlocks[i] = struct{}{}
+	}
+
+	// Parity file may be missing entirely
+	parityFile, err := os.Open(filepath.Join(partition.AbsoluteDirOsPath, parityFileName))
+
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	scratchFile, err := os.Create(filepath.Join(partition.AbsoluteDirOsPath, parityScratchFileName))
+
+	if err != nil {
+		if parityFile != nil {
+			_ = parityFile.Close()
+		}
+
+		return nil, err
+	}
+
+	r := parityRepair{
+		ctx:                 ctx,
+		partition:           partition,
+		metadata:            metadata,
+		stream:              stream,
+		encoder:             encoder,
+		parityFile:          parityFile,
+		damagedParityBlocks: damagedParityBlocks,
+		erasedBlocks:        make(map[int]struct{}),
+		unrecoverableGroups: make(map[int]struct{}),
+		scratchSlots:        make(map[int]int64),
+		scratchFile:         scratchFile,
+	}
+
+	return &r, nil
+}
+
+func (r *parityRepair) close() {
+	if r.parityFile != nil {
+		_ = r.parityFile.Close()
+	}
+
+	_ = r.scratchFile.Close()
+	_ = os.Remove(r.scratchFile.Name())
+}
+
+// Indexes of data blocks that contain bytes of the file
+func (r *parityRepair) fileBlocks(manifestPath string) []int {
+	i, _ := slices.BinarySearch(r.stream.manifestPaths, manifestPath)
+
+	start := r.stream.offsets[i]
+	end := r.stream.fileEnd(i)
+
+	blocks := make([]int, 0)
+
+	for offset := start - start%r.metadata.BlockSize; offset < end; offset += r.metadata.BlockSize {
+		blocks = append(blocks, int(offset/r.metadata.BlockSize))
+	}
+
+	return blocks
+}
+
+// Finds erased data blocks of the group and, if there are few enough of
+// them, reconstructs them into the scratch file
+func (r *parityRepair) reconstructGroup(group int) error {
+	metadata := r.metadata
+	erasedShards := make([]bool, metadata.DataShards+metadata.ParityShards)
+	erasedCount := 0
+
+	for k := range metadata.DataShards {
+		j := metadata.dataBlockIndex(group, k)
+
+		// Virtual all-zero block
+		if j >= len(metadata.DataBlockHashes) {
+			continue
+		}
+
+		hash, err := r.hashDataBlock(j)
+
+		if err != nil {
+			return err
+		}
+
+		if hash != metadata.DataBlockHashes[j] {
+			erasedShards[k] = true
+			r.erasedBlocks[j] = struct{}{}
+			erasedCount++
+		}
+	}
+
+	for m := range metadata.ParityShards {
+		if _, damaged := r.damagedParityBlocks[group*metadata.ParityShards+m]; damaged {
+			erasedShards[metadata.DataShards+m] = true
+			erasedCount++
+		}
+	}
+
+	if erasedCount == 0 {
+		return nil
+	}
+
+	if erasedCount > metadata.ParityShards {
+		r.unrecoverableGroups[group] = struct{}{}
+		return nil
+	}
+
+	for k := range metadata.DataShards {
+		if erasedShards[k] {
+			j := metadata.dataBlockIndex(group, k)
+			r.scratchSlots[j] = int64(len(r.scratchSlots))
+		}
+	}
+
+	sliceSize := min(metadata.BlockSize, maxParitySliceSize)
+	buffers := metadata.newShards(sliceSize)
+	shards := make([][]byte, len(buffers))
+
+	for offset := int64(0); offset < metadata.BlockSize; offset += sliceSize {
+		if err := r.ctx.Err(); err != nil {
+			return err
+		}
+
+		length := min(sliceSize, metadata.BlockSize-offset)
+
+		for i := range shards {
+			if erasedShards[i] {
+				shards[i] = nil
+				continue
+			}
+
+			shards[i] = buffers[i][:length]
+
+			if i < metadata.DataShards {
+				blockOffset := metadata.dataBlockOffset(group, i)
+
+				if err := r.stream.readAt(shards[i], blockOffset+offset); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			position := metadata.parityBlockOffset(group, i-metadata.DataShards) + offset
+
+			if _, err := r.parityFile.ReadAt(shards[i], position); err != nil {
+				return err
+			}
+		}
+
+		if err := r.encoder.ReconstructData(shards); err != nil {
+			return err
+		}
+
+		for k := range metadata.DataShards {
+			if !erasedShards[k] {
+				continue
+			}
+
+			j := metadata.dataBlockIndex(group, k)
+			position := r.scratchSlots[j]*metadata.BlockSize + offset
+
+			if _, err := r.scratchFile.WriteAt(shards[k], position); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r *parityRepair) hashDataBlock(j int) (string, error) {
+	sliceSize := min(r.metadata.BlockSize, maxParitySliceSize)
+	buf := make([]byte, sliceSize)
+	hasher := sha1.New()
+
+	for offset := int64(0); offset < r.metadata.BlockSize; offset += sliceSize {
+		slice := buf[:min(sliceSize, r.metadata.BlockSize-offset)]
+
+		if err := r.stream.readAt(slice, int64(j)*r.metadata.BlockSize+offset); err != nil {
+			return "", err
+		}
+
+		hasher.Write(slice)
+	}
+
+	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
+}
+
+// Writes the file from its intact blocks and reconstructed blocks
+func (r *parityRepair) rebuildFile(manifestPath string) (RepairOutcome, error) {
+	for _, j := range r.fileBlocks(manifestPath) {
+		_, erased := r.erasedBlocks[j]
+		_, unrecoverable := r.unrecoverableGroups[j%r.metadata.Groups]
+
+		if erased || unrecoverable {
+			if _, reconstructed := r.scratchSlots[j]; !reconstructed {
+				notRepaired := FileNotRepaired{
+					ManifestPath: manifestPath,
+					Reason:       "too many damaged blocks to reconstruct from parity",
+				}
+
+				return notRepaired, nil
+			}
+		}
+	}
+
+	i, _ := slices.BinarySearch(r.stream.manifestPaths, manifestPath)
+	start := r.stream.offsets[i]
+	end := r.stream.fileEnd(i)
+
+	write := func(w io.Writer) error {
+		buf := make([]byte, min(r.metadata.BlockSize, maxParitySliceSize))
+
+		for offset := start; offset < end; {
+			j := int(offset / r.metadata.BlockSize)
+			inBlock := offset % r.metadata.BlockSize
+			length := min(int64(len(buf)), end-offset, r.metadata.BlockSize-inBlock)
+			slice := buf[:length]
+
+			if slot, reconstructed := r.scratchSlots[j]; reconstructed {
+				if _, err := r.scratchFile.ReadAt(slice, slot*r.metadata.BlockSize+inBlock); err != nil {
+					return err
+				}
+			} else {
+				if err := r.stream.readAt(slice, offset); err != nil {
+					return err
+				}
+			}
+
+			if _, err := w.Write(slice); err != nil {
+				return err
+			}
+
+			offset += length
+		}
+
+		return nil
+	}
+
+	entry := r.partition.manifest.Files[manifestPath]
+	dstPath := r.partition.toAbsoluteOsPath(manifestPath)
+	perm := fs.FileMode(0o644)
+
+	if info, err := os.Stat(dstPath); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	err := installVerified(dstPath, write, entry.Hash, entry.Mtime, perm)
+
+	if errors.Is(err, errCopiedHashMismatch) {
+		notRepaired := FileNotRepaired{
+			ManifestPath: manifestPath,
+			Reason:       "reconstructed contents does not match the manifest",
+		}
+
+		return notRepaired, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return FileRepairedFromParity{ManifestPath: manifestPath}, nil
+}
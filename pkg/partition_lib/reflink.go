@@ -0,0 +1,35 @@
+package partition_lib
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+
+	"github.com/azerum/data-storage-suite/pkg/utils"
+)
+
+var errReflinkNotSupported = errors.New("reflinks are not supported by this filesystem or OS")
+
+// Atomically replaces dstPath with a reflink (copy-on-write clone) of
+// srcPath. dstPath keeps mtime and permissions from dstInfo
+func reflinkOver(srcPath string, dstPath string, dstInfo fs.FileInfo) error {
+	src, err := os.Open(srcPath)
+
+	if err != nil {
+		return err
+	}
+
+	defer src.Close()
+
+	return utils.OverwriteWith(dstPath, dstPath+partialFileSuffix, func(tmpFile *os.File) error {
+		if err := reflink(src, tmpFile); err != nil {
+			return err
+		}
+
+		if err := tmpFile.Chmod(dstInfo.Mode().Perm()); err != nil {
+			return err
+		}
+
+		return os.Chtimes(tmpFile.Name(), dstInfo.ModTime(), dstInfo.ModTime())
+	})
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package partition_lib
+
+import "os"
+
+func reflink(src *os.File, dst *os.File) error {
+	return errReflinkNotSupported
+}
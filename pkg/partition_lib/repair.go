@@ -0,0 +1,246 @@
+package partition_lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+
+	"github.com/azerum/data-storage-suite/pkg/utils"
+)
+
+type RepairOutcome interface {
+	isRepairOutcome()
+}
+
+type FileRepaired struct {
+	ManifestPath string
+
+	// Where the good copy was taken from
+	ReplicaDir          string
+	ReplicaManifestPath string
+}
+
+func (o FileRepaired) isRepairOutcome() {}
+
+type FileNotRepaired struct {
+	ManifestPath string
+	Reason       string
+
+	// The file is not damaged, but was edited since it was hashed (see
+	// isModifiedSinceHash()). It must not be repaired from elsewhere either
+	ModifiedSinceHash bool
+}
+
+func (o FileNotRepaired) isRepairOutcome() {}
+
+// Repair restores damaged files of the partition from replica partitions
+//
+// For each FileMissing, HashDoesNotMatch or SizeDoesNotMatch mismatch
+// (others are ignored), except files modified since they were hashed
+// (see isModifiedSinceHash()), looks for a file with the expected hash in the
+// replicas' manifests: first at the same path, then at any path. Candidate
+// is hashed while being copied, so a corrupted replica file is never
+// installed. The damaged file is replaced atomically and gets the mtime from
+// the manifest, so the partition stays consistent with its manifest
+//
+// Replica read errors are not fatal: the next candidate is tried
+func (partition *Partition) Repair(
+	ctx context.Context,
+	mismatches []ManifestMismatch,
+	replicas []*Partition,
+) *utils.ChanWithError[RepairOutcome] {
+	out := utils.NewChanWithError[RepairOutcome](1)
+	go repairWorker(partition, mismatches, replicas, out, ctx)
+
+	return out
+}
+
+func repairWorker(
+	partition *Partition,
+	mismatches []ManifestMismatch,
+	replicas []*Partition,
+	out *utils.ChanWithError[RepairOutcome],
+	ctx context.Context,
+) {
+	if partition.manifest == nil {
+		out.CloseWithError(
+			fmt.Errorf("partition %s has no manifest", partition.AbsoluteDirOsPath),
+		)
+
+		return
+	}
+
+	pathsByHash := make([]map[string][]string, 0, len(replicas))
+
+	for _, r := range replicas {
+		if r.manifest == nil {
+			out.CloseWithError(
+				fmt.Errorf("replica %s has no manifest", r.AbsoluteDirOsPath),
+			)
+
+			return
+		}
+
+		pathsByHash = append(pathsByHash, r.manifest.pathsByHash())
+	}
+
+	repair := func(manifestPath string) RepairOutcome {
+		entry := partition.manifest.Files[manifestPath]
+		dstPath := partition.toAbsoluteOsPath(manifestPath)
+
+		var lastErr error
+
+		for i, replica := range replicas {
+			for _, candidate := range candidatePaths(manifestPath, pathsByHash[i][entry.Hash]) {
+				srcPath := replica.toAbsoluteOsPath(candidate)
+				err := copyFileVerified(srcPath, dstPath, entry.Hash, entry.Mtime)
+
+				if err == nil {
+					return FileRepaired{
+						ManifestPath:        manifestPath,
+						ReplicaDir:          replica.AbsoluteDirOsPath,
+						ReplicaManifestPath: candidate,
+					}
+				}
+
+				lastErr = fmt.Errorf("%s: %w", srcPath, err)
+			}
+		}
+
+		if lastErr == nil {
+			return FileNotRepaired{
+				ManifestPath: manifestPath,
+				Reason:       fmt.Sprintf("no replica has a file with hash %s", entry.Hash),
+			}
+		}
+
+		return FileNotRepaired{
+			ManifestPath: manifestPath,
+			Reason:       fmt.Sprintf("no good copy in replicas. Last error: %s", lastErr),
+		}
+	}
+
+	for _, m := range mismatches {
+		if err := ctx.Err(); err != nil {
+			out.CloseWithError(err)
+			return
+		}
+
+		manifestPath, repairable := repairableManifestPath(m)
+
+		if !repairable || partition.manifest.Files[manifestPath] == nil {
+			continue
+		}
+
+		modified, err := partition.isModifiedSinceHash(m)
+
+		if err != nil {
+			out.CloseWithError(err)
+			return
+		}
+
+		if modified {
+			out.Channel <- fileModifiedSinceHash(manifestPath)
+			continue
+		}
+
+		out.Channel <- repair(manifestPath)
+	}
+
+	out.CloseOk()
+}
+
+func repairableManifestPath(mismatch ManifestMismatch) (string, bool) {
+	switch m := mismatch.(type) {
+	case FileMissing:
+		return m.ManifestPath, true
+
+	case HashDoesNotMatch:
+		return m.ManifestPath, true
+
+	case SizeDoesNotMatch:
+		return m.ManifestPath, true
+
+	default:
+		return "", false
+	}
+}
+
+// Mismatch of a file whose mtime (or, for hash mismatches, size) differs
+// from the manifest is most likely a legitimate edit that was not hashed
+// yet, not damage. Such files must not be overwritten with the old contents
+//
+// Missing files are never modified
+func (partition *Partition) isModifiedSinceHash(mismatch ManifestMismatch) (bool, error) {
+	manifestPath, repairable := repairableManifestPath(mismatch)
+
+	if !repairable {
+		return false, nil
+	}
+
+	if _, isMissing := mismatch.(FileMissing); isMissing {
+		return false, nil
+	}
+
+	info, err := partition.files.Stat(manifestPath)
+
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	entry := partition.manifest.Files[manifestPath]
+
+	if info.ModTime().Unix() != entry.Mtime {
+		return true, nil
+	}
+
+	_, isHashMismatch := mismatch.(HashDoesNotMatch)
+	return isHashMismatch && entry.Size != unknownSize && info.Size() != entry.Size, nil
+}
+
+func fileModifiedSinceHash(manifestPath string) FileNotRepaired {
+	return FileNotRepaired{
+		ManifestPath: manifestPath,
+		Reason:       "modified since last hash; run hash first",
+
+		ModifiedSinceHash: true,
+	}
+}
+
+// Same path goes first, as it is the most likely to be the same file
+func candidatePaths(manifestPath string, pathsWithHash []string) []string {
+	if !slices.Contains(pathsWithHash, manifestPath) {
+		return pathsWithHash
+	}
+
+	candidates := []string{manifestPath}
+
+	for _, p := range pathsWithHash {
+		if p != manifestPath {
+			candidates = append(candidates, p)
+		}
+	}
+
+	return candidates
+}
+
+// Maps hash to sorted manifest paths of files with that hash
+func (manifest *manifest) pathsByHash() map[string][]string {
+	index := make(map[string][]string)
+
+	for p, entry := range manifest.Files {
+		index[entry.Hash] = append(index[entry.Hash], p)
+	}
+
+	for _, paths := range index {
+		slices.Sort(paths)
+	}
+
+	return index
+}
@@ -0,0 +1,208 @@
+package partition_lib
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"math"
+	"math/rand/v2"
+	"slices"
+
+	"github.com/azerum/data-storage-suite/pkg/utils"
+)
+
+type SampleOptions struct {
+	// Fraction of files to hash, in (0, 1]. Ignored if Count is set
+	Fraction float64
+
+	// Number of files to hash
+	Count int
+
+	// The same seed selects the same sample of the same manifest
+	Seed uint64
+}
+
+// Filled in by CheckSample(). Valid only after the returned channel is closed
+type SampleStats struct {
+	// Files that exist and have the expected size. The sample is taken
+	// from them
+	TotalFiles int
+	TotalBytes int64
+
+	SampledFiles int
+	SampledBytes int64
+}
+
+// Size of file in the partition differs from the size in the manifest
+type SizeDoesNotMatch struct {
+	ManifestPath string
+	ActualSize   int64
+	ExpectedSize int64
+}
+
+func (m SizeDoesNotMatch) isManifestMismatch() {}
+
+// CheckSample is a quick, partial alternative to Check. It walks the entire
+// partition, reporting FileNotHashed, FileMissing and SizeDoesNotMatch, but
+// hashes only a random sample of the remaining files
+//
+// See SampleStats.MaxCorruptedFiles() for what a clean result means
+func (partition *Partition) CheckSample(
+	ctx context.Context,
+	options SampleOptions,
+) (*utils.ChanWithError[ManifestMismatch], *SampleStats) {
+	out := utils.NewChanWithError[ManifestMismatch](1)
+	stats := SampleStats{}
+
+	go checkSampleWorker(partition, options, out, &stats, ctx)
+
+	return out, &stats
+}
+
+func checkSampleWorker(
+	partition *Partition,
+	options SampleOptions,
+	out *utils.ChanWithError[ManifestMismatch],
+	stats *SampleStats,
+	ctx context.Context,
+) {
+	if partition.manifest == nil {
+		out.CloseWithError(
+			fmt.Errorf("partition %s has no manifest", partition.AbsoluteDirOsPath),
+		)
+
+		return
+	}
+
+	seenInPartition := make(map[string]struct{})
+	sizes := make(map[string]int64)
+
+	walk := func(absoluteOsPath string, manifestPath string, entry fs.DirEntry) error {
+		seenInPartition[manifestPath] = struct{}{}
+
+		manifestEntry := partition.manifest.Files[manifestPath]
+
+		if manifestEntry == nil {
+			out.Channel <- FileNotHashed{ManifestPath: manifestPath}
+			return nil
+		}
+
+		info, err := entry.Info()
+
+		if err != nil {
+			return err
+		}
+
+		if manifestEntry.Size != unknownSize && manifestEntry.Size != info.Size() {
+			out.Channel <- SizeDoesNotMatch{
+				ManifestPath: manifestPath,
+				ActualSize:   info.Size(),
+				ExpectedSize: manifestEntry.Size,
+			}
+
+			return nil
+		}
+
+		sizes[manifestPath] = info.Size()
+		return nil
+	}
+
+	if err := partition.Walk(walk, ctx); err != nil {
+		out.CloseWithError(err)
+		return
+	}
+
+	for p := range partition.manifest.Files {
+		_, seen := seenInPartition[p]
+
+		if !seen {
+			out.Channel <- FileMissing{ManifestPath: p}
+		}
+	}
+
+	candidates := make([]string, 0, len(sizes))
+
+	for p, size := range sizes {
+		candidates = append(candidates, p)
+		stats.TotalFiles++
+		stats.TotalBytes += size
+	}
+
+	// Sort first, as map iteration order is random
+	slices.Sort(candidates)
+
+	random := rand.New(rand.NewPCG(options.Seed, options.Seed))
+	random.Shuffle(len(candidates), func(i int, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+
+	sample := candidates[:options.sampleSize(len(candidates))]
+
+	for _, manifestPath := range sample {
+		if err := ctx.Err(); err != nil {
+			out.CloseWithError(err)
+			return
+		}
+
+		mismatch, err := verifyFile(
+			partition,
+			manifestPath,
+			partition.manifest.Files[manifestPath],
+			false,
+		)
+
+		if err != nil {
+			out.CloseWithError(err)
+			return
+		}
+
+		stats.SampledFiles++
+		stats.SampledBytes += sizes[manifestPath]
+
+		if mismatch != nil {
+			out.Channel <- *mismatch
+		}
+	}
+
+	out.CloseOk()
+}
+
+func (options SampleOptions) sampleSize(totalFiles int) int {
+	size := options.Count
+
+	if size == 0 {
+		size = int(math.Ceil(options.Fraction * float64(totalFiles)))
+	}
+
+	return max(0, min(size, totalFiles))
+}
+
+// Assuming no hash mismatches were found in the sample, returns the
+// largest number of corrupted files the checked files may contain with
+// given confidence (e.g. 0.95)
+//
+// In other words, if there were more corrupted files, a random sample
+// would miss all of them with probability below 1 - confidence
+func (stats *SampleStats) MaxCorruptedFiles(confidence float64) int {
+	n := stats.TotalFiles
+	k := stats.SampledFiles
+	alpha := 1 - confidence
+
+	// Probability that sample of k files out of n misses all d corrupted
+	// files (hypergeometric distribution). For d = 0 it is 1
+	pMissAll := 1.0
+	d := 0
+
+	for d < n-k {
+		next := pMissAll * float64(n-d-k) / float64(n-d)
+
+		if next <= alpha {
+			break
+		}
+
+		pMissAll = next
+		d++
+	}
+
+	return d
+}
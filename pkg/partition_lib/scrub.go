@@ -0,0 +1,247 @@
+package partition_lib
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/azerum/data-storage-suite/pkg/utils"
+)
+
+// Scrub state is kept separately from the manifest: manifest describes
+// contents of the partition, while the scrub state is just a schedule.
+// Losing it is harmless - all files become due for verification
+const scrubStateFileName = ".scrub.json"
+const scrubStateTmpFileName = scrubStateFileName + ".tmp"
+
+type scrubState struct {
+	// Maps manifest path to unix time when file contents was last verified
+	// to match the manifest
+	Verified map[string]int64 `json:"verified"`
+}
+
+type ScrubOptions struct {
+	// Files verified less than Interval ago are skipped
+	Interval time.Duration
+
+	// Stop once this many bytes are verified. 0 means no limit
+	MaxBytes int64
+
+	// Stop once scrub runs for this long. 0 means no limit
+	MaxDuration time.Duration
+}
+
+// Filled in by Scrub(). Valid only after the returned channel is closed
+type ScrubStats struct {
+	VerifiedFiles int
+	VerifiedBytes int64
+
+	// Files that were due for verification, but were not verified as
+	// the budget has run out
+	RemainingDueFiles int
+}
+
+// Scrub is like Check, but verifies only manifest entries that were not
+// verified within options.Interval, oldest first, within the given budget.
+// Running it regularly with a budget spreads full verification of the
+// partition over multiple runs
+//
+// Does not look for files not in the manifest (FileNotHashed)
+//
+// Verification times are saved to the partition directory once scrub
+// finishes, even if it is cancelled via ctx. Files with mismatches are
+// not marked as verified
+func (partition *Partition) Scrub(
+	ctx context.Context,
+	options ScrubOptions,
+) (*utils.ChanWithError[ManifestMismatch], *ScrubStats) {
+	out := utils.NewChanWithError[ManifestMismatch](1)
+	stats := ScrubStats{}
+
+	go scrubWorker(partition, options, out, &stats, ctx)
+
+	return out, &stats
+}
+
+func scrubWorker(
+	partition *Partition,
+	options ScrubOptions,
+	out *utils.ChanWithError[ManifestMismatch],
+	stats *ScrubStats,
+	ctx context.Context,
+) {
+	if partition.manifest == nil {
+		out.CloseWithError(
+			fmt.Errorf("partition %s has no manifest", partition.AbsoluteDirOsPath),
+		)
+
+		return
+	}
+
+	state, err := partition.loadScrubState()
+
+	if err != nil {
+		out.CloseWithError(err)
+		return
+	}
+
+	startTime := time.Now()
+	dueBefore := startTime.Add(-options.Interval).Unix()
+
+	due := make([]string, 0)
+
+	for p := range partition.manifest.Files {
+		if state.Verified[p] <= dueBefore {
+			due = append(due, p)
+		}
+	}
+
+	// Oldest first. Sort by path too, so runs are reproducible
+	slices.SortFunc(due, func(a string, b string) int {
+		return cmp.Or(
+			cmp.Compare(state.Verified[a], state.Verified[b]),
+			strings.Compare(a, b),
+		)
+	})
+
+	verify := func(manifestPath string) (bool, error) {
+		absoluteOsPath := partition.toAbsoluteOsPath(manifestPath)
+		info, err := os.Stat(absoluteOsPath)
+
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				out.Channel <- FileMissing{ManifestPath: manifestPath}
+				return true, nil
+			}
+
+			return false, err
+		}
+
+		if options.MaxBytes > 0 &&
+			stats.VerifiedBytes > 0 &&
+			stats.VerifiedBytes+info.Size() > options.MaxBytes {
+			return false, nil
+		}
+
+		mismatch, err := verifyFile(
+			partition,
+			manifestPath,
+			partition.manifest.Files[manifestPath],
+			false,
+		)
+
+		if err != nil {
+			return false, err
+		}
+
+		stats.VerifiedFiles++
+		stats.VerifiedBytes += info.Size()
+
+		if mismatch != nil {
+			out.Channel <- *mismatch
+			return true, nil
+		}
+
+		state.Verified[manifestPath] = time.Now().Unix()
+		return true, nil
+	}
+
+	var verifyErr error
+	remaining := due
+
+	for len(remaining) > 0 {
+		if err := ctx.Err(); err != nil {
+			verifyErr = err
+			break
+		}
+
+		if options.MaxDuration > 0 && time.Since(startTime) >= options.MaxDuration {
+			break
+		}
+
+		withinBudget, err := verify(remaining[0])
+
+		if err != nil {
+			verifyErr = err
+			break
+		}
+
+		if !withinBudget {
+			break
+		}
+
+		remaining = remaining[1:]
+	}
+
+	stats.RemainingDueFiles = len(remaining)
+
+	// Save progress even if verification failed midway, so the next run
+	// does not repeat the work
+	saveErr := partition.saveScrubState(state)
+
+	if err := errors.Join(verifyErr, saveErr); err != nil {
+		out.CloseWithError(err)
+		return
+	}
+
+	out.CloseOk()
+}
+
+func (partition *Partition) loadScrubState() (*scrubState, error) {
+	state := scrubState{
+		Verified: make(map[string]int64),
+	}
+
+	bytes, err := os.ReadFile(filepath.Join(partition.AbsoluteDirOsPath, scrubStateFileName))
+
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &state, nil
+		}
+
+		return nil, err
+	}
+
+	if err := json.Unmarshal(bytes, &state); err != nil {
+		fullErr := errors.Join(
+			errors.New("while parsing scrub state"),
+			err,
+		)
+
+		return nil, fullErr
+	}
+
+	if state.Verified == nil {
+		state.Verified = make(map[string]int64)
+	}
+
+	return &state, nil
+}
+
+// Entries of files no longer in the manifest are dropped
+func (partition *Partition) saveScrubState(state *scrubState) error {
+	for p := range state.Verified {
+		if _, exists := partition.manifest.Files[p]; !exists {
+			delete(state.Verified, p)
+		}
+	}
+
+	bytes, err := json.Marshal(state)
+
+	if err != nil {
+		return err
+	}
+
+	return utils.Overwrite(
+		filepath.Join(partition.AbsoluteDirOsPath, scrubStateFileName),
+		filepath.Join(partition.AbsoluteDirOsPath, scrubStateTmpFileName),
+		bytes,
+	)
+}
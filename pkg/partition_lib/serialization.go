@@ -26,6 +26,7 @@ func DeserializePartition(
 	p := Partition{
 		AbsoluteDirOsPath: dirPath,
 		manifest:          manifest,
+		files:             NewOsFileSystem(dirPath),
 	}
 
 	return &p, nil
@@ -116,6 +117,12 @@ func (wrapper *manifestWrapper) unwrap() (*manifest, error) {
 }
 
 func (manifest *manifest) validate() error {
+	if manifest.Chunking != nil {
+		if err := manifest.Chunking.validate(); err != nil {
+			return errors.Join(errors.New("error in .chunking"), err)
+		}
+	}
+
 	for path, entry := range manifest.Files {
 		err := entry.validate()
 
@@ -143,6 +150,20 @@ func (entry *fileEntry) validate() error {
 		return errors.New(".mtime must be >= 0")
 	}
 
+	if entry.Size < unknownSize {
+		return errors.New(".size must be >= 0")
+	}
+
+	if entry.Chunks != nil {
+		if entry.Chunks.ChunkSize <= 0 {
+			return errors.New(".chunks.chunkSize must be > 0")
+		}
+
+		if len(entry.Chunks.Hashes) == 0 {
+			return errors.New(".chunks.hashes must not be empty")
+		}
+	}
+
 	return nil
 }
 
@@ -157,7 +178,13 @@ func (partition *Partition) Serialize() ([]byte, error) {
 }
 
 func (manifest *manifest) wrap() (*manifestWrapper, error) {
-	dataJsonBytes, err := json.Marshal(manifest)
+	return wrapJson(manifest)
+}
+
+// Other files of the partition that need corruption detection are wrapped
+// the same way as the manifest
+func wrapJson(value any) (*manifestWrapper, error) {
+	dataJsonBytes, err := json.Marshal(value)
 
 	if err != nil {
 		return nil, err
@@ -172,3 +199,14 @@ func (manifest *manifest) wrap() (*manifestWrapper, error) {
 
 	return &wrapper, nil
 }
+
+// Inverse of wrapJson(). Verifies .dataHash
+func unwrapJson(bytes []byte, value any) error {
+	wrapper, err := deserializeManifestWrapper(bytes)
+
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal([]byte(wrapper.DataJson), value)
+}
@@ -0,0 +1,350 @@
+package partition_lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/azerum/data-storage-suite/pkg/utils"
+)
+
+type SyncOptions struct {
+	// Delete files of dst that are not in the source
+	Delete bool
+}
+
+type SyncOperation interface {
+	isSyncOperation()
+}
+
+// Copy file missing in dst from the source
+type SyncAdd struct {
+	ManifestPath string
+}
+
+func (o SyncAdd) isSyncOperation() {}
+
+// Replace file of dst with the source file at the same path
+type SyncModify struct {
+	ManifestPath string
+}
+
+func (o SyncModify) isSyncOperation() {}
+
+// Rename file of dst that has the same contents as the source file at
+// ManifestPath, instead of copying it
+type SyncMove struct {
+	FromManifestPath string
+	ManifestPath     string
+}
+
+func (o SyncMove) isSyncOperation() {}
+
+type SyncDelete struct {
+	ManifestPath string
+}
+
+func (o SyncDelete) isSyncOperation() {}
+
+type SyncOutcome interface {
+	isSyncOutcome()
+}
+
+type SyncApplied struct {
+	Operation SyncOperation
+}
+
+func (o SyncApplied) isSyncOutcome() {}
+
+type SyncFailed struct {
+	Operation SyncOperation
+	Reason    string
+}
+
+func (o SyncFailed) isSyncOutcome() {}
+
+// PlanSync compares manifests of the partition and dst and returns operations
+// that make dst files the same as files of the partition. Files are not
+// read: both manifests are assumed to be up to date
+//
+// Operations are ordered as they should be applied: deletes, moves, modifies,
+// adds. Moves are planned only with options.Delete, as otherwise the moved
+// file would have been kept
+func (partition *Partition) PlanSync(dst *Partition, options SyncOptions) ([]SyncOperation, error) {
+	if partition.manifest == nil {
+		return nil, fmt.Errorf("partition %s has no manifest", partition.AbsoluteDirOsPath)
+	}
+
+	if dst.manifest == nil {
+		return nil, fmt.Errorf(
+			"partition %s has no manifest. Use copy for the initial copy",
+			dst.AbsoluteDirOsPath,
+		)
+	}
+
+	src := partition.manifest.Files
+	dstFiles := dst.manifest.Files
+
+	// Files of dst that are not in the source, by hash. Moves take from them
+	moveSources := make(map[string][]string)
+
+	for _, p := range sortedKeys(dstFiles) {
+		if src[p] == nil {
+			hash := dstFiles[p].Hash
+			moveSources[hash] = append(moveSources[hash], p)
+		}
+	}
+
+	moved := make(map[string]struct{})
+
+	moves := make([]SyncOperation, 0)
+	modifies := make([]SyncOperation, 0)
+	adds := make([]SyncOperation, 0)
+
+	for _, p := range sortedKeys(src) {
+		entry := src[p]
+		dstEntry := dstFiles[p]
+
+		if dstEntry != nil {
+			if dstEntry.Hash != entry.Hash {
+				modifies = append(modifies, SyncModify{ManifestPath: p})
+			}
+
+			continue
+		}
+
+		if candidates := moveSources[entry.Hash]; options.Delete && len(candidates) > 0 {
+			moveSources[entry.Hash] = candidates[1:]
+			moved[candidates[0]] = struct{}{}
+
+			moves = append(moves, SyncMove{FromManifestPath: candidates[0], ManifestPath: p})
+			continue
+		}
+
+		adds = append(adds, SyncAdd{ManifestPath: p})
+	}
+
+	ops := make([]SyncOperation, 0)
+
+	if options.Delete {
+		for _, p := range sortedKeys(dstFiles) {
+			_, isMoved := moved[p]
+
+			if src[p] == nil && !isMoved {
+				ops = append(ops, SyncDelete{ManifestPath: p})
+			}
+		}
+	}
+
+	ops = append(ops, moves...)
+	ops = append(ops, modifies...)
+	ops = append(ops, adds...)
+
+	return ops, nil
+}
+
+// ApplySync applies operations from PlanSync() to dst, and updates and saves
+// dst manifest, even if failed halfway
+//
+// Each copied file is hashed while being copied, so a source file that
+// does not match its manifest entry is never installed - SyncFailed is
+// reported instead. Moved file is hashed before the move. If it does not
+// match, it is copied from the source instead, and the old path is deleted
+func (partition *Partition) ApplySync(
+	ctx context.Context,
+	dst *Partition,
+	ops []SyncOperation,
+) *utils.ChanWithError[SyncOutcome] {
+	out := utils.NewChanWithError[SyncOutcome](1)
+	go applySyncWorker(partition, dst, ops, out, ctx)
+
+	return out
+}
+
+func applySyncWorker(
+	partition *Partition,
+	dst *Partition,
+	ops []SyncOperation,
+	out *utils.ChanWithError[SyncOutcome],
+	ctx context.Context,
+) {
+	if partition.manifest == nil || dst.manifest == nil {
+		out.CloseWithError(errors.New("both partitions must have manifests"))
+		return
+	}
+
+	err := applySyncOperations(partition, dst, ops, out.Channel, ctx)
+
+	if saveErr := dst.Save(); saveErr != nil {
+		err = errors.Join(err, saveErr)
+	}
+
+	if err != nil {
+		out.CloseWithError(err)
+		return
+	}
+
+	out.CloseOk()
+}
+
+func applySyncOperations(
+	partition *Partition,
+	dst *Partition,
+	ops []SyncOperation,
+	out chan<- SyncOutcome,
+	ctx context.Context,
+) error {
+	// Returns whether the file was copied. Failed copy is reported
+	// as SyncFailed
+	copyFromSource := func(op SyncOperation, manifestPath string) (bool, error) {
+		entry := partition.manifest.Files[manifestPath]
+
+		err := copyFileVerified(
+			partition.toAbsoluteOsPath(manifestPath),
+			dst.toAbsoluteOsPath(manifestPath),
+			entry.Hash,
+			entry.Mtime,
+		)
+
+		if errors.Is(err, errCopiedHashMismatch) || errors.Is(err, fs.ErrNotExist) {
+			out <- SyncFailed{Operation: op, Reason: err.Error()}
+			return false, nil
+		}
+
+		if err != nil {
+			return false, err
+		}
+
+		dst.manifest.Files[manifestPath] = entry.clone()
+		out <- SyncApplied{Operation: op}
+
+		return true, nil
+	}
+
+	deleteFromDst := func(manifestPath string) error {
+		absoluteOsPath := dst.toAbsoluteOsPath(manifestPath)
+
+		if err := os.Remove(absoluteOsPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		delete(dst.manifest.Files, manifestPath)
+		removeEmptyParentDirs(dst.AbsoluteDirOsPath, absoluteOsPath)
+
+		return nil
+	}
+
+	for _, op := range ops {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		switch o := op.(type) {
+		case SyncDelete:
+			if err := deleteFromDst(o.ManifestPath); err != nil {
+				return err
+			}
+
+			out <- SyncApplied{Operation: op}
+
+		case SyncMove:
+			entry := partition.manifest.Files[o.ManifestPath]
+			fromPath := dst.toAbsoluteOsPath(o.FromManifestPath)
+			hash, err := HashFile(fromPath)
+
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+
+			// Move is also a delete of FromManifestPath, so once the file is
+			// copied, the stale one is deleted. If copy failed, it is kept
+			// like other files of dst
+			if err != nil || hash != entry.Hash {
+				copied, err := copyFromSource(op, o.ManifestPath)
+
+				if err != nil {
+					return err
+				}
+
+				if copied {
+					if err := deleteFromDst(o.FromManifestPath); err != nil {
+						return err
+					}
+				}
+
+				continue
+			}
+
+			toPath := dst.toAbsoluteOsPath(o.ManifestPath)
+
+			if err := os.MkdirAll(filepath.Dir(toPath), 0o755); err != nil {
+				return err
+			}
+
+			if err := os.Rename(fromPath, toPath); err != nil {
+				return err
+			}
+
+			t := time.Unix(entry.Mtime, 0)
+
+			if err := os.Chtimes(toPath, t, t); err != nil {
+				return err
+			}
+
+			delete(dst.manifest.Files, o.FromManifestPath)
+			dst.manifest.Files[o.ManifestPath] = entry.clone()
+			removeEmptyParentDirs(dst.AbsoluteDirOsPath, fromPath)
+
+			out <- SyncApplied{Operation: op}
+
+		case SyncModify:
+			if _, err := copyFromSource(op, o.ManifestPath); err != nil {
+				return err
+			}
+
+		case SyncAdd:
+			if _, err := copyFromSource(op, o.ManifestPath); err != nil {
+				return err
+			}
+
+		default:
+			panic(fmt.Sprintf("Unknown SyncOperation: %+v", op))
+		}
+	}
+
+	return nil
+}
+
+func (entry *fileEntry) clone() *fileEntry {
+	c := *entry
+	return &c
+}
+
+// Removes directories that became empty after removing a file, up to, but
+// excluding, rootDir
+func removeEmptyParentDirs(rootDir string, absoluteOsPath string) {
+	rootDir = filepath.Clean(rootDir)
+
+	for dir := filepath.Dir(absoluteOsPath); dir != rootDir && len(dir) > len(rootDir); dir = filepath.Dir(dir) {
+		// Fails if the directory is not empty
+		if os.Remove(dir) != nil {
+			return
+		}
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	slices.Sort(keys)
+	return keys
+}
@@ -1,6 +1,9 @@
 package partition_lib
 
-import "path/filepath"
+import (
+	"encoding/json"
+	"path/filepath"
+)
 
 type Partition struct {
 	AbsoluteDirOsPath string
@@ -8,6 +11,10 @@ type Partition struct {
 	// nil if this partition has not been hashed yet, i.e. when it contains
 	// no manifest file
 	manifest *manifest
+
+	// Where files of the partition are. For partitions in OS directories,
+	// OS filesystem of AbsoluteDirOsPath
+	files FileSystem
 }
 
 type manifest struct {
@@ -17,11 +24,36 @@ type manifest struct {
 	// Allowed to be either `{}` or `null` in JSON - both are interpreted
 	// as "there were no files in the partition directory at the moment of hashing"
 	Files map[string]*fileEntry `json:"files"`
+
+	// nil if chunk hashing is disabled
+	Chunking *ChunkingOptions `json:"chunking,omitempty"`
 }
 
 type fileEntry struct {
 	Hash  string `json:"hash"`
 	Mtime int64  `json:"mtime"`
+
+	// unknownSize in manifests created before sizes were recorded
+	Size int64 `json:"size"`
+
+	// nil if the file was not chunked. See ChunkingOptions
+	Chunks *chunkHashes `json:"chunks,omitempty"`
+}
+
+const unknownSize = -1
+
+// Missing .size is read as unknownSize, not 0, as 0 is a valid size
+func (entry *fileEntry) UnmarshalJSON(bytes []byte) error {
+	type plainFileEntry fileEntry
+
+	plain := plainFileEntry{Size: unknownSize}
+
+	if err := json.Unmarshal(bytes, &plain); err != nil {
+		return err
+	}
+
+	*entry = fileEntry(plain)
+	return nil
 }
 
 func toManifestPath(partitionDirAbsoluteOsPath string, absolutePath string) (string, error) {
@@ -33,3 +65,8 @@ func toManifestPath(partitionDirAbsoluteOsPath string, absolutePath string) (str
 
 	return filepath.ToSlash(p), nil
 }
+
+// Inverse of toManifestPath()
+func (partition *Partition) toAbsoluteOsPath(manifestPath string) string {
+	return filepath.Join(partition.AbsoluteDirOsPath, filepath.FromSlash(manifestPath))
+}
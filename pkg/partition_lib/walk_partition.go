@@ -2,22 +2,41 @@ package partition_lib
 
 import (
 	"context"
+	"errors"
 	"io/fs"
-	"path/filepath"
+	"path"
+	"strings"
 )
 
 type WalkPartitionCallback func(absoluteOsPath string, manifestPath string, entry fs.DirEntry) error
 
+// Calls callback for every file of the partition, except for ignored files
+//
+// Sub-directories that contain their own manifest are separate (nested)
+// partitions, and are not descended into. Otherwise the same files would be
+// tracked by both manifests, and re-hashing nested partition would change
+// its manifest file, making parent see modifications
+//
+// For partitions not in OS directories (see LoadPartitionFromFileSystem()),
+// absoluteOsPath is the manifest path joined to AbsoluteDirOsPath, and
+// only describes the file
 func (partition *Partition) Walk(callback WalkPartitionCallback, ctx context.Context) error {
-	shouldIgnore := func(path string) bool {
-		fileName := filepath.Base(path)
-
-		_, exists := fileNamesToIgnore[fileName]
-		return exists
-	}
+	return partition.walkSubtree(".", callback, ctx)
+}
 
-	return filepath.WalkDir(partition.AbsoluteDirOsPath, func(path string, d fs.DirEntry, err error) error {
+// Like Walk(), but walks only the given subtree (directory or a single file)
+// of the partition. Missing subtree has no files
+func (partition *Partition) walkSubtree(
+	manifestPath string,
+	callback WalkPartitionCallback,
+	ctx context.Context,
+) error {
+	return partition.files.WalkDir(manifestPath, func(p string, d fs.DirEntry, err error) error {
 		if err != nil {
+			if p == manifestPath && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+
 			return err
 		}
 
@@ -26,30 +45,88 @@ func (partition *Partition) Walk(callback WalkPartitionCallback, ctx context.Con
 		}
 
 		if d.IsDir() {
+			if p == "." {
+				return nil
+			}
+
+			isPartition, err := partition.isPartitionDir(p)
+
+			if err != nil {
+				return err
+			}
+
+			if isPartition {
+				return fs.SkipDir
+			}
+
 			return nil
 		}
 
-		if shouldIgnore(path) {
+		if isIgnoredFile(p) {
 			return nil
 		}
 
-		manifestPath, err := toManifestPath(partition.AbsoluteDirOsPath, path)
+		if err := callback(partition.toAbsoluteOsPath(p), p, d); err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
+
+func isIgnoredFile(manifestPath string) bool {
+	fileName := path.Base(manifestPath)
+
+	_, exists := fileNamesToIgnore[fileName]
+	return exists || strings.HasSuffix(fileName, partialFileSuffix)
+}
+
+func (partition *Partition) isPartitionDir(manifestPath string) (bool, error) {
+	_, err := partition.files.Stat(path.Join(manifestPath, manifestFileName))
+
+	if err == nil {
+		return true, nil
+	}
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+
+	return false, err
+}
+
+// Returns manifest path of the nested partition the path is in, or "" if
+// it is not in one. The path itself is not checked
+func (partition *Partition) nestedPartitionOf(manifestPath string) (string, error) {
+	for dir := path.Dir(manifestPath); dir != "."; dir = path.Dir(dir) {
+		isPartition, err := partition.isPartitionDir(dir)
 
 		if err != nil {
-			return err
+			return "", err
 		}
 
-		if err := callback(path, manifestPath, d); err != nil {
-			return err
+		if isPartition {
+			return dir, nil
 		}
+	}
 
-		return nil
-	})
+	return "", nil
 }
 
+// Suffix of files being written by `part` itself, e.g. when repairing or
+// copying a file. Once complete, such files are renamed to drop the suffix
+const partialFileSuffix = ".part-partial"
+
 var fileNamesToIgnore = map[string]struct{}{
-	manifestFileName:    {},
-	manifestTmpFileName: {},
+	manifestFileName:      {},
+	manifestTmpFileName:   {},
+	scrubStateFileName:    {},
+	scrubStateTmpFileName: {},
+
+	parityFileName:            {},
+	parityTmpFileName:         {},
+	parityMetadataFileName:    {},
+	parityMetadataTmpFileName: {},
 
 	// macOS
 	// Source: https://github.com/github/gitignore/blob/main/Global/macOS.gitignore
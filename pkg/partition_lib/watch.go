@@ -0,0 +1,365 @@
+package partition_lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/azerum/data-storage-suite/pkg/utils"
+	"github.com/fsnotify/fsnotify"
+)
+
+type WatchOptions struct {
+	// Changed path is hashed once there were no events for it (and for
+	// anything under it) for this long, so files being written are not
+	// hashed after each write
+	Debounce time.Duration
+
+	// While changes keep coming, the manifest is saved at most this often.
+	// It is also saved once all queued paths are hashed
+	CheckpointInterval time.Duration
+}
+
+var DefaultWatchOptions = WatchOptions{
+	Debounce:           2 * time.Second,
+	CheckpointInterval: time.Minute,
+}
+
+// Watch keeps the manifest current by watching the partition for changes
+// (inotify on Linux) instead of walking it periodically. Changed paths are
+// queued, debounced and hashed incrementally (see HashOptions.Only), and
+// resulting changes are applied to the manifest, saved and emitted
+//
+// Events may be missed before watching starts and when the kernel event
+// queue overflows, so both are followed by a reconciliation: a full Hash()
+//
+// Watches of a renamed directory report events under its old path, so
+// they are replaced with watches of the new path once the rename is seen
+//
+// Runs until ctx is cancelled, then saves the manifest and closes the
+// channel without error. Only for partitions in OS directories
+func (partition *Partition) Watch(ctx context.Context, options WatchOptions) *utils.ChanWithError[ManifestChange] {
+	out := utils.NewChanWithError[ManifestChange](1)
+	go watchWorker(partition, options, out, ctx)
+
+	return out
+}
+
+type watcher struct {
+	partition *Partition
+	options   WatchOptions
+	out       *utils.ChanWithError[ManifestChange]
+	ctx       context.Context
+	fsWatcher *fsnotify.Watcher
+
+	// Time of the last event by manifest path. The partition dir itself
+	// is "", as in HashOptions.Only
+	pending map[string]time.Time
+
+	// Whether the manifest has changes that are not saved
+	dirty    bool
+	lastSave time.Time
+}
+
+func watchWorker(
+	partition *Partition,
+	options WatchOptions,
+	out *utils.ChanWithError[ManifestChange],
+	ctx context.Context,
+) {
+	if _, isOs := partition.files.(*osFileSystem); !isOs {
+		out.CloseWithError(fmt.Errorf("cannot watch %s: not an OS directory", partition.AbsoluteDirOsPath))
+		return
+	}
+
+	fsWatcher, err := fsnotify.NewWatcher()
+
+	if err != nil {
+		out.CloseWithError(err)
+		return
+	}
+
+	defer fsWatcher.Close()
+
+	w := watcher{
+		partition: partition,
+		options:   options,
+		out:       out,
+		ctx:       ctx,
+		fsWatcher: fsWatcher,
+		pending:   make(map[string]time.Time),
+		lastSave:  time.Now(),
+	}
+
+	err = w.run()
+
+	// Changes applied so far are valid even if watching failed
+	if w.dirty {
+		err = errors.Join(err, partition.Save())
+	}
+
+	if err != nil && !errors.Is(err, context.Canceled) {
+		out.CloseWithError(err)
+		return
+	}
+
+	out.CloseOk()
+}
+
+func (w *watcher) run() error {
+	if err := w.reconcile(); err != nil {
+		return err
+	}
+
+	tick := time.NewTicker(max(w.options.Debounce/4, 10*time.Millisecond))
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-w.ctx.Done():
+			return w.ctx.Err()
+
+		case event, ok := <-w.fsWatcher.Events:
+			if !ok {
+				return errors.New("watcher closed unexpectedly")
+			}
+
+			if err := w.handleEvent(event); err != nil {
+				return err
+			}
+
+		case err, ok := <-w.fsWatcher.Errors:
+			if !ok {
+				return errors.New("watcher closed unexpectedly")
+			}
+
+			if !errors.Is(err, fsnotify.ErrEventOverflow) {
+				return err
+			}
+
+			// Queued paths are covered by the full hash
+			clear(w.pending)
+
+			if err := w.reconcile(); err != nil {
+				return err
+			}
+
+		case now := <-tick.C:
+			if err := w.hashSettled(now); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Watches all directories, then hashes the whole partition, so changes
+// made before the watches were added are not missed
+func (w *watcher) reconcile() error {
+	if err := w.addWatches(""); err != nil {
+		return err
+	}
+
+	return w.hash("")
+}
+
+// Watches the directory and its sub-directories, except nested partitions
+func (w *watcher) addWatches(subtree string) error {
+	return w.partition.files.WalkDir(manifestPathOf(subtree), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Removed while walking. Its removal is queued anyway
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+
+			return err
+		}
+
+		if !d.IsDir() {
+			return nil
+		}
+
+		if p != "." {
+			isPartition, err := w.partition.isPartitionDir(p)
+
+			if err != nil {
+				return err
+			}
+
+			if isPartition {
+				return fs.SkipDir
+			}
+		}
+
+		err = w.fsWatcher.Add(w.partition.toAbsoluteOsPath(p))
+
+		if errors.Is(err, fs.ErrNotExist) {
+			return fs.SkipDir
+		}
+
+		return err
+	})
+}
+
+// Removes watches of the directory and its sub-directories
+func (w *watcher) removeWatches(absoluteOsPath string) error {
+	prefix := absoluteOsPath + string(filepath.Separator)
+
+	for _, p := range w.fsWatcher.WatchList() {
+		if p != absoluteOsPath && !strings.HasPrefix(p, prefix) {
+			continue
+		}
+
+		err := w.fsWatcher.Remove(p)
+
+		if err != nil && !errors.Is(err, fsnotify.ErrNonExistentWatch) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *watcher) handleEvent(event fsnotify.Event) error {
+	manifestPath, err := toManifestPath(w.partition.AbsoluteDirOsPath, event.Name)
+
+	if err != nil {
+		return err
+	}
+
+	if manifestPath == "." {
+		manifestPath = ""
+	} else if isIgnoredFile(manifestPath) {
+		return nil
+	}
+
+	// Watches of a renamed directory (and of its sub-directories) would keep
+	// reporting events under the old paths. Its new path is watched anew
+	// once Create event for it comes
+	if event.Has(fsnotify.Rename) {
+		if err := w.removeWatches(event.Name); err != nil {
+			return err
+		}
+	}
+
+	// Files may be created in a new directory before it is watched. They
+	// are found when the directory is hashed
+	if event.Has(fsnotify.Create) {
+		info, err := os.Lstat(event.Name)
+
+		if err == nil && info.IsDir() {
+			if err := w.addWatches(manifestPath); err != nil {
+				return err
+			}
+		}
+	}
+
+	w.pending[manifestPath] = time.Now()
+	return nil
+}
+
+// Hashes paths that had no events for the debounce period, and saves the
+// manifest if it is time for a checkpoint
+func (w *watcher) hashSettled(now time.Time) error {
+	settled := make([]string, 0)
+
+	for p, lastEvent := range w.pending {
+		if now.Sub(lastEvent) >= w.options.Debounce && !w.hasUnsettledPathsUnder(p, now) {
+			settled = append(settled, p)
+		}
+	}
+
+	slices.Sort(settled)
+
+	for i, p := range settled {
+		delete(w.pending, p)
+
+		// Sub-path of already hashed directory. Sorting puts directories
+		// before their contents
+		if i > 0 && isInSubtree(settled[i-1], p) {
+			settled[i] = settled[i-1]
+			continue
+		}
+
+		if err := w.hash(p); err != nil {
+			return err
+		}
+	}
+
+	checkpointDue := now.Sub(w.lastSave) >= w.options.CheckpointInterval
+
+	if w.dirty && (len(w.pending) == 0 || checkpointDue) {
+		if err := w.partition.Save(); err != nil {
+			return err
+		}
+
+		w.dirty = false
+		w.lastSave = now
+	}
+
+	return nil
+}
+
+// Directory is not hashed while files in it are being written, e.g. right
+// after it was created, since they would be hashed half-written
+func (w *watcher) hasUnsettledPathsUnder(subtree string, now time.Time) bool {
+	for p, lastEvent := range w.pending {
+		if p != subtree && isInSubtree(subtree, p) && now.Sub(lastEvent) < w.options.Debounce {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Hashes the subtree ("" for the whole partition), applies and emits
+// the changes
+func (w *watcher) hash(subtree string) error {
+	// Directory may have been watched before it became a nested partition
+	nested, err := w.partition.nestedPartitionOf(subtree)
+
+	if err != nil || nested != "" {
+		return err
+	}
+
+	// Changes are applied after hashing, as Hash() reads the manifest
+	changes, err := w.partition.HashWithOptions(w.ctx, HashOptions{Only: subtree}).Drain()
+
+	// File was removed while hashing. Its removal event is queued, so
+	// hash the subtree again once it settles
+	if errors.Is(err, fs.ErrNotExist) {
+		w.pending[subtree] = time.Now()
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, c := range changes {
+		w.partition.ApplyChange(c)
+		w.dirty = true
+
+		select {
+		case w.out.Channel <- c:
+		case <-w.ctx.Done():
+			return w.ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// Inverse of cleanSubtreePath()
+func manifestPathOf(subtree string) string {
+	if subtree == "" {
+		return "."
+	}
+
+	return subtree
+}
@@ -0,0 +1,161 @@
+package partition_lib
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+// Extended attribute with the hash of the file, written by Hash() with
+// HashOptions.Xattr. Files copied or moved with xattrs carry it along, so
+// the hash does not have to be recomputed in the new place
+//
+// Value is `<algorithm> <hash> <mtime ns> <size>`: the hash is valid while
+// the file still has that mtime and size
+const hashXattrName = "user.part.hash"
+
+var errXattrNotSupported = errors.New("extended attributes are not supported")
+
+type hashXattr struct {
+	Algorithm string
+	Hash      string
+	MtimeNs   int64
+	Size      int64
+}
+
+// Xattr hash of the file does not match the manifest, even though the xattr
+// was computed for the same mtime and size as the manifest entry. Either
+// the file or its xattr has been altered
+type XattrDoesNotMatch struct {
+	ManifestPath string
+	XattrHash    string
+	ExpectedHash string
+}
+
+func (m XattrDoesNotMatch) isManifestMismatch() {}
+
+func (x *hashXattr) String() string {
+	return fmt.Sprintf("%s %s %d %d", x.Algorithm, x.Hash, x.MtimeNs, x.Size)
+}
+
+func parseHashXattr(value string) (*hashXattr, bool) {
+	fields := strings.Fields(value)
+
+	if len(fields) != 4 {
+		return nil, false
+	}
+
+	mtimeNs, err := strconv.ParseInt(fields[2], 10, 64)
+
+	if err != nil {
+		return nil, false
+	}
+
+	size, err := strconv.ParseInt(fields[3], 10, 64)
+
+	if err != nil {
+		return nil, false
+	}
+
+	x := hashXattr{Algorithm: fields[0], Hash: fields[1], MtimeNs: mtimeNs, Size: size}
+	return &x, true
+}
+
+// Whether xattr holds SHA-1 of the file in its current state
+func (x *hashXattr) matches(info fs.FileInfo) bool {
+	return x.Algorithm == "sha1" &&
+		isSha1Hex(x.Hash) &&
+		x.MtimeNs == info.ModTime().UnixNano() &&
+		x.Size == info.Size()
+}
+
+// Returns hash xattr of the file, or nil if it has none, has malformed one,
+// or is not in an OS directory
+func (partition *Partition) readHashXattr(manifestPath string) (*hashXattr, error) {
+	if _, isOs := partition.files.(*osFileSystem); !isOs {
+		return nil, nil
+	}
+
+	value, err := getXattr(partition.toAbsoluteOsPath(manifestPath), hashXattrName)
+
+	if errors.Is(err, errXattrNotSupported) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if value == "" {
+		return nil, nil
+	}
+
+	x, valid := parseHashXattr(value)
+
+	if !valid {
+		return nil, nil
+	}
+
+	return x, nil
+}
+
+// Writes hash xattr for the file in state described by info, unless it is
+// already there. Files that cannot have xattrs (not supported by the
+// filesystem, not writable) are skipped
+func (partition *Partition) writeHashXattr(manifestPath string, info fs.FileInfo, hash string) error {
+	if _, isOs := partition.files.(*osFileSystem); !isOs || !info.Mode().IsRegular() {
+		return nil
+	}
+
+	existing, err := partition.readHashXattr(manifestPath)
+
+	if err != nil {
+		return err
+	}
+
+	if existing != nil && existing.matches(info) && existing.Hash == hash {
+		return nil
+	}
+
+	x := hashXattr{
+		Algorithm: "sha1",
+		Hash:      hash,
+		MtimeNs:   info.ModTime().UnixNano(),
+		Size:      info.Size(),
+	}
+
+	err = setXattr(partition.toAbsoluteOsPath(manifestPath), hashXattrName, x.String())
+
+	if errors.Is(err, errXattrNotSupported) || errors.Is(err, fs.ErrPermission) {
+		return nil
+	}
+
+	return err
+}
+
+// Reports XattrDoesNotMatch if the file has hash xattr of the same version
+// (mtime & size) of the file as the manifest entry, but with another hash.
+// Xattrs of other versions are just outdated
+func (partition *Partition) checkHashXattr(manifestPath string, entry *fileEntry) (ManifestMismatch, error) {
+	x, err := partition.readHashXattr(manifestPath)
+
+	if err != nil || x == nil || x.Algorithm != "sha1" {
+		return nil, err
+	}
+
+	sameVersion := x.MtimeNs/1e9 == entry.Mtime && (entry.Size == unknownSize || x.Size == entry.Size)
+
+	if !sameVersion || x.Hash == entry.Hash {
+		return nil, nil
+	}
+
+	mismatch := XattrDoesNotMatch{
+		ManifestPath: manifestPath,
+		XattrHash:    x.Hash,
+		ExpectedHash: entry.Hash,
+	}
+
+	return mismatch, nil
+}
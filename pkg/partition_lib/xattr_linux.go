@@ -0,0 +1,46 @@
+//go:build linux
+
+package partition_lib
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// Returns "" if the file has no such attribute
+func getXattr(absoluteOsPath string, name string) (string, error) {
+	buf := make([]byte, 256)
+
+	for {
+		n, err := unix.Getxattr(absoluteOsPath, name, buf)
+
+		if errors.Is(err, unix.ENODATA) {
+			return "", nil
+		}
+
+		if errors.Is(err, unix.ERANGE) {
+			buf = make([]byte, len(buf)*2)
+			continue
+		}
+
+		if err != nil {
+			return "", wrapXattrError(err)
+		}
+
+		return string(buf[:n]), nil
+	}
+}
+
+func setXattr(absoluteOsPath string, name string, value string) error {
+	return wrapXattrError(unix.Setxattr(absoluteOsPath, name, []byte(value), 0))
+}
+
+func wrapXattrError(err error) error {
+	if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EOPNOTSUPP) {
+		return fmt.Errorf("%w: %w", errXattrNotSupported, err)
+	}
+
+	return err
+}
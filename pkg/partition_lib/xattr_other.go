@@ -0,0 +1,11 @@
+//go:build !linux
+
+package partition_lib
+
+func getXattr(absoluteOsPath string, name string) (string, error) {
+	return "", errXattrNotSupported
+}
+
+func setXattr(absoluteOsPath string, name string, value string) error {
+	return errXattrNotSupported
+}
@@ -0,0 +1,223 @@
+package partition_lib_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+	. "github.com/onsi/gomega"
+	gs "github.com/onsi/gomega/gstruct"
+)
+
+func Test_CreateBag_creates_valid_bag(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	hashAndSave(p)
+
+	bagDir := filepath.Join(t.TempDir(), "bag")
+
+	if err := p.CreateBag(context.Background(), bagDir); err != nil {
+		panic(err)
+	}
+
+	for _, name := range []string{"bagit.txt", "bag-info.txt", "manifest-sha256.txt", "tagmanifest-sha256.txt", "data/c/d"} {
+		g.Expect(filepath.Join(bagDir, name)).To(BeAnExistingFile())
+	}
+
+	mismatches, err := partition_lib.ValidateBag(context.Background(), bagDir).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(mismatches).To(BeEmpty())
+}
+
+func Test_CreateBag_fails_if_file_does_not_match_manifest(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	hashAndSave(p)
+	modifyFileA(p)
+
+	err := p.CreateBag(context.Background(), filepath.Join(t.TempDir(), "bag"))
+	g.Expect(err).To(MatchError(ContainSubstring("a does not match")))
+}
+
+func Test_ValidateBag_reports_damaged_bag(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	hashAndSave(p)
+
+	bagDir := filepath.Join(t.TempDir(), "bag")
+
+	if err := p.CreateBag(context.Background(), bagDir); err != nil {
+		panic(err)
+	}
+
+	writes := map[string]string{
+		"data/a":       "A2",
+		"data/extra":   "X",
+		"bag-info.txt": "Tampered: yes\n",
+	}
+
+	for name, contents := range writes {
+		if err := os.WriteFile(filepath.Join(bagDir, name), ([]byte)(contents), 0o600); err != nil {
+			panic(err)
+		}
+	}
+
+	if err := os.Remove(filepath.Join(bagDir, "data", "b")); err != nil {
+		panic(err)
+	}
+
+	mismatches, err := partition_lib.ValidateBag(context.Background(), bagDir).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(mismatches).To(ConsistOf(
+		SatisfyAll(
+			BeAssignableToTypeOf(partition_lib.HashDoesNotMatch{}),
+			gs.MatchFields(gs.IgnoreExtras, gs.Fields{"ManifestPath": Equal("data/a")}),
+		),
+
+		SatisfyAll(
+			BeAssignableToTypeOf(partition_lib.HashDoesNotMatch{}),
+			gs.MatchFields(gs.IgnoreExtras, gs.Fields{"ManifestPath": Equal("bag-info.txt")}),
+		),
+
+		partition_lib.FileMissing{ManifestPath: "data/b"},
+		partition_lib.FileNotHashed{ManifestPath: "data/extra"},
+	))
+}
+
+func Test_ValidateBag_reports_payload_files_a_partition_would_ignore(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	hashAndSave(p)
+
+	bagDir := filepath.Join(t.TempDir(), "bag")
+
+	if err := p.CreateBag(context.Background(), bagDir); err != nil {
+		panic(err)
+	}
+
+	mkdirWithFile(filepath.Join(bagDir, "data", "nested"))
+
+	for _, name := range []string{"data/.manifest.json", "data/nested/.manifest.json"} {
+		if err := os.WriteFile(filepath.Join(bagDir, name), ([]byte)("{}"), 0o600); err != nil {
+			panic(err)
+		}
+	}
+
+	mismatches, err := partition_lib.ValidateBag(context.Background(), bagDir).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(mismatches).To(ConsistOf(
+		partition_lib.FileNotHashed{ManifestPath: "data/.manifest.json"},
+		partition_lib.FileNotHashed{ManifestPath: "data/nested/.manifest.json"},
+		partition_lib.FileNotHashed{ManifestPath: "data/nested/file"},
+
+		gs.MatchFields(gs.IgnoreExtras, gs.Fields{
+			"ManifestPath": Equal("bag-info.txt"),
+			"Keyword":      Equal("Payload-Oxum"),
+			"Expected":     Equal("4.4"),
+		}),
+	))
+}
+
+func Test_ValidateBag_checks_Payload_Oxum(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	hashAndSave(p)
+
+	bagDir := filepath.Join(t.TempDir(), "bag")
+
+	if err := p.CreateBag(context.Background(), bagDir); err != nil {
+		panic(err)
+	}
+
+	// Without tag manifests, bag-info.txt can be changed unnoticed
+	for _, name := range []string{"tagmanifest-sha256.txt", "tagmanifest-sha1.txt"} {
+		if err := os.Remove(filepath.Join(bagDir, name)); err != nil {
+			panic(err)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(bagDir, "bag-info.txt"), ([]byte)("payload-oxum: 5.4\n"), 0o600); err != nil {
+		panic(err)
+	}
+
+	mismatches, err := partition_lib.ValidateBag(context.Background(), bagDir).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(mismatches).To(ConsistOf(partition_lib.MetadataDoesNotMatch{
+		ManifestPath: "bag-info.txt",
+		Keyword:      "Payload-Oxum",
+		Actual:       "4.4",
+		Expected:     "5.4",
+	}))
+}
+
+func Test_ValidateBag_does_not_read_files_outside_of_bag(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	hashAndSave(p)
+
+	bagDir := filepath.Join(t.TempDir(), "bag")
+
+	if err := p.CreateBag(context.Background(), bagDir); err != nil {
+		panic(err)
+	}
+
+	// With the right hashes, files outside would pass if they were read
+	outsidePath := filepath.Join(filepath.Dir(bagDir), "outside")
+
+	if err := os.WriteFile(outsidePath, ([]byte)("O"), 0o600); err != nil {
+		panic(err)
+	}
+
+	hostileLines := partition_lib.HashString("O") + "  ../outside\n" +
+		partition_lib.HashString("O") + "  " + filepath.ToSlash(outsidePath) + "\n"
+
+	// Payload manifest paths are not opened, only compared with data/
+	file, err := os.OpenFile(filepath.Join(bagDir, "tagmanifest-sha1.txt"), os.O_APPEND|os.O_WRONLY, 0)
+
+	if err != nil {
+		panic(err)
+	}
+
+	if _, err := file.WriteString(hostileLines); err != nil {
+		panic(err)
+	}
+
+	if err := file.Close(); err != nil {
+		panic(err)
+	}
+
+	mismatches, err := partition_lib.ValidateBag(context.Background(), bagDir).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(mismatches).To(ConsistOf(
+		partition_lib.FileMissing{ManifestPath: "../outside"},
+		partition_lib.FileMissing{ManifestPath: filepath.ToSlash(outsidePath)},
+	))
+}
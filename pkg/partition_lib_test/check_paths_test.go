@@ -0,0 +1,123 @@
+package partition_lib_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+	. "github.com/onsi/gomega"
+	gs "github.com/onsi/gomega/gstruct"
+)
+
+func Test_CheckPaths_checks_only_given_files(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	hashAndSave(p)
+
+	modifyFileA(p)
+	removeFileBAndDirectoryC(p)
+	addFileF(p)
+
+	mismatches, err := p.CheckPaths(context.Background(), []string{"a", "c/d", "e", "f"}).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(mismatches).To(ConsistOf(
+		gs.MatchFields(gs.IgnoreExtras, gs.Fields{
+			"ManifestPath": Equal("a"),
+			"ExpectedHash": Equal(partition_lib.HashString("A")),
+			"ActualHash":   Equal(partition_lib.HashString("A2")),
+		}),
+
+		partition_lib.FileMissing{ManifestPath: "c/d"},
+		partition_lib.FileNotHashed{ManifestPath: "f"},
+	))
+}
+
+func Test_CheckPaths_fails_on_unknown_path(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	hashAndSave(p)
+
+	_, err := p.CheckPaths(context.Background(), []string{"no-such-file"}).Drain()
+	g.Expect(err).To(HaveOccurred())
+}
+
+func Test_CheckPaths_fails_on_paths_outside_of_partition_before_checking_any_file(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	hashAndSave(p)
+	removeFileBAndDirectoryC(p)
+
+	for _, outside := range []string{"../x", "c/../../x", "/etc/passwd", "."} {
+		mismatches := p.CheckPaths(context.Background(), []string{"c/d", outside})
+		mismatchesCount := 0
+
+		// Unlike Drain(), keeps mismatches sent before the error
+		for range mismatches.Channel {
+			mismatchesCount++
+		}
+
+		g.Expect(mismatches.Err).To(HaveOccurred(), outside)
+		g.Expect(mismatchesCount).To(Equal(0), outside)
+	}
+}
+
+func Test_CheckPaths_fails_on_paths_Check_does_not_cover(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	hashAndSave(p)
+
+	child := loadPartition(mkdirWithFile(filepath.Join(p.AbsoluteDirOsPath, "child")))
+	hashAndSave(child)
+
+	if err := os.WriteFile(filepath.Join(p.AbsoluteDirOsPath, "x.part-partial"), ([]byte)("X"), 0o600); err != nil {
+		panic(err)
+	}
+
+	notCovered := map[string]string{
+		".manifest.json":       "ignored file name",
+		"x.part-partial":       "ignored file name",
+		"child/file":           "nested partition child",
+		"child/.manifest.json": "ignored file name",
+	}
+
+	for manifestPath, reason := range notCovered {
+		_, err := p.CheckPaths(context.Background(), []string{manifestPath}).Drain()
+		g.Expect(err).To(MatchError(ContainSubstring(reason)), manifestPath)
+	}
+}
+
+func Test_ManifestPathsMatching_matches_manifest_entries(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	hashAndSave(p)
+
+	// Missing files match too, so they are reported
+	removeFileBAndDirectoryC(p)
+
+	paths, err := p.ManifestPathsMatching("c/*")
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(paths).To(Equal([]string{"c/d"}))
+
+	paths, err = p.ManifestPathsMatching("[ab]")
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(paths).To(Equal([]string{"a", "b"}))
+}
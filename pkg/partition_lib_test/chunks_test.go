@@ -0,0 +1,195 @@
+package partition_lib_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+	. "github.com/onsi/gomega"
+	gs "github.com/onsi/gomega/gstruct"
+)
+
+var testChunking = partition_lib.ChunkingOptions{ChunkSize: 4, MinFileSize: 8}
+
+func Test_Check_reports_corrupted_byte_ranges_of_chunked_files(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupChunkedTestPartition(t)
+
+	// Chunks: [0, 4), [4, 8), [8, 12), [12, 16), [16, 20)
+	corruptBigFile(p, 5, 9, 17)
+
+	mismatches, err := p.Check(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(mismatches).To(ConsistOf(
+		gs.MatchFields(gs.IgnoreExtras, gs.Fields{
+			"ManifestPath": Equal("big"),
+			"ActualHash":   Not(BeEmpty()),
+
+			"CorruptedRanges": Equal([]partition_lib.ByteRange{
+				{Offset: 4, Length: 8},
+				{Offset: 16, Length: 4},
+			}),
+		}),
+	))
+}
+
+func Test_Check_with_StopAtFirstBadChunk_reports_only_the_first_corrupted_range(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupChunkedTestPartition(t)
+	corruptBigFile(p, 5, 17)
+
+	options := partition_lib.CheckOptions{StopAtFirstBadChunk: true}
+	mismatches, err := p.CheckWithOptions(context.Background(), options).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(mismatches).To(ConsistOf(
+		gs.MatchFields(gs.IgnoreExtras, gs.Fields{
+			"ManifestPath":    Equal("big"),
+			"ActualHash":      BeEmpty(),
+			"CorruptedRanges": Equal([]partition_lib.ByteRange{{Offset: 4, Length: 4}}),
+		}),
+	))
+}
+
+func Test_Check_reports_truncated_tail_of_chunked_file_as_corrupted(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupChunkedTestPartition(t)
+
+	if err := os.Truncate(filepath.Join(p.AbsoluteDirOsPath, "big"), 10); err != nil {
+		panic(err)
+	}
+
+	mismatches, err := p.Check(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(mismatches).To(ConsistOf(
+		gs.MatchFields(gs.IgnoreExtras, gs.Fields{
+			"CorruptedRanges": Equal([]partition_lib.ByteRange{{Offset: 8, Length: 12}}),
+		}),
+	))
+}
+
+func Test_Hash_chunks_already_hashed_files_once_chunking_is_enabled(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	writeBigFile(p)
+	hashAndSave(p)
+
+	if err := p.SetChunking(&testChunking); err != nil {
+		panic(err)
+	}
+
+	changes, err := p.Hash(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	// Small files are not chunked, so are not re-hashed
+	g.Expect(changes).To(ConsistOf(
+		SatisfyAll(
+			BeAssignableToTypeOf(partition_lib.SpuriousMtimeChange{}),
+
+			gs.MatchFields(gs.IgnoreExtras, gs.Fields{
+				"ManifestPath": Equal("big"),
+			}),
+		),
+	))
+
+	for _, c := range changes {
+		p.ApplyChange(c)
+	}
+
+	if err := p.Save(); err != nil {
+		panic(err)
+	}
+
+	// Chunking options & chunk hashes are persisted
+	p = loadPartition(p.AbsoluteDirOsPath)
+
+	changes, err = p.Hash(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(changes).To(BeEmpty())
+
+	corruptBigFile(p, 0)
+
+	mismatches, err := p.Check(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(mismatches).To(ConsistOf(
+		gs.MatchFields(gs.IgnoreExtras, gs.Fields{
+			"CorruptedRanges": Equal([]partition_lib.ByteRange{{Offset: 0, Length: 4}}),
+		}),
+	))
+}
+
+func Test_SetChunking_rejects_non_positive_chunk_size(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	err := p.SetChunking(&partition_lib.ChunkingOptions{ChunkSize: 0})
+
+	g.Expect(err).To(MatchError(ContainSubstring(".chunkSize")))
+}
+
+func setupChunkedTestPartition(t *testing.T) *partition_lib.Partition {
+	p := setupTestPartition(t)
+	writeBigFile(p)
+
+	if err := p.SetChunking(&testChunking); err != nil {
+		panic(err)
+	}
+
+	hashAndSave(p)
+	return p
+}
+
+// 20 bytes, so chunked with testChunking
+func writeBigFile(partition *partition_lib.Partition) {
+	path := filepath.Join(partition.AbsoluteDirOsPath, "big")
+
+	if err := os.WriteFile(path, ([]byte)(strings.Repeat("0123456789", 2)), 0o600); err != nil {
+		panic(err)
+	}
+}
+
+// Overwrites bytes at given offsets without changing file size
+func corruptBigFile(partition *partition_lib.Partition, offsets ...int64) {
+	file, err := os.OpenFile(filepath.Join(partition.AbsoluteDirOsPath, "big"), os.O_WRONLY, 0)
+
+	if err != nil {
+		panic(err)
+	}
+
+	defer file.Close()
+
+	for _, o := range offsets {
+		if _, err := file.WriteAt(([]byte)("X"), o); err != nil {
+			panic(err)
+		}
+	}
+}
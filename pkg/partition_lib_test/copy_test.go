@@ -0,0 +1,152 @@
+package partition_lib_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+	. "github.com/onsi/gomega"
+	gs "github.com/onsi/gomega/gstruct"
+)
+
+func Test_CopyTo_creates_identical_partition(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	hashAndSave(p)
+
+	dstDir := filepath.Join(t.TempDir(), "dst")
+	outcomes, err := p.CopyTo(context.Background(), dstDir).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(outcomes).To(ConsistOf(
+		partition_lib.FileCopied{ManifestPath: "a", Size: 1},
+		partition_lib.FileCopied{ManifestPath: "b", Size: 1},
+		partition_lib.FileCopied{ManifestPath: "c/d", Size: 1},
+		partition_lib.FileCopied{ManifestPath: "e", Size: 1},
+	))
+
+	dst := loadPartition(dstDir)
+
+	mismatches, err := dst.Check(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(mismatches).To(BeEmpty())
+
+	// mtimes are preserved
+	changes, err := dst.Hash(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(changes).To(BeEmpty())
+}
+
+func Test_CopyTo_does_not_write_manifest_if_source_file_is_corrupted(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	hashAndSave(p)
+	modifyFileA(p)
+
+	dstDir := t.TempDir()
+	outcomes, err := p.CopyTo(context.Background(), dstDir).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(outcomes).To(ContainElement(SatisfyAll(
+		BeAssignableToTypeOf(partition_lib.FileNotCopied{}),
+
+		gs.MatchFields(gs.IgnoreExtras, gs.Fields{
+			"ManifestPath": Equal("a"),
+		}),
+	)))
+
+	g.Expect(filepath.Join(dstDir, "a")).NotTo(BeAnExistingFile())
+	g.Expect(filepath.Join(dstDir, ".manifest.json")).NotTo(BeAnExistingFile())
+}
+
+func Test_CopyTo_resumes_interrupted_copy(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	hashAndSave(p)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	dstDir := t.TempDir()
+	outcomes := p.CopyTo(ctx, dstDir)
+
+	// Interrupt after the first file
+	<-outcomes.Channel
+	cancel()
+
+	for range outcomes.Channel {
+	}
+
+	g.Expect(outcomes.Err).To(MatchError(context.Canceled))
+	g.Expect(filepath.Join(dstDir, ".manifest.json")).NotTo(BeAnExistingFile())
+
+	resumed, err := p.CopyTo(context.Background(), dstDir).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(resumed).To(ContainElement(partition_lib.FileAlreadyCopied{ManifestPath: "a"}))
+	g.Expect(filepath.Join(dstDir, ".manifest.json")).To(BeAnExistingFile())
+}
+
+func Test_CopyTo_resume_copies_again_files_corrupted_in_dst(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	hashAndSave(p)
+
+	dstDir := t.TempDir()
+
+	// Left by an interrupted copy, then corrupted. Size & mtime are the
+	// same as in the manifest
+	if err := os.WriteFile(filepath.Join(dstDir, "a"), ([]byte)("A"), 0o600); err != nil {
+		panic(err)
+	}
+
+	corruptByte(filepath.Join(dstDir, "a"), 0)
+
+	aInfo, err := os.Stat(filepath.Join(p.AbsoluteDirOsPath, "a"))
+
+	if err != nil {
+		panic(err)
+	}
+
+	if err := os.Chtimes(filepath.Join(dstDir, "a"), aInfo.ModTime(), aInfo.ModTime()); err != nil {
+		panic(err)
+	}
+
+	outcomes, err := p.CopyTo(context.Background(), dstDir).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(outcomes).To(ContainElement(partition_lib.FileCopied{ManifestPath: "a", Size: 1}))
+
+	copied := loadPartition(dstDir)
+	mismatches, err := copied.Check(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(mismatches).To(BeEmpty())
+}
@@ -0,0 +1,64 @@
+package partition_lib_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+	. "github.com/onsi/gomega"
+)
+
+func Test_FindPartitions_finds_nested_partitions_in_sorted_order(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	root := t.TempDir()
+
+	x := loadPartition(mkdirWithFile(filepath.Join(root, "x")))
+	hashAndSave(x)
+
+	xy := loadPartition(mkdirWithFile(filepath.Join(root, "x", "y")))
+	hashAndSave(xy)
+
+	z := loadPartition(mkdirWithFile(filepath.Join(root, "z")))
+	hashAndSave(z)
+
+	// Not a partition
+	mkdirWithFile(filepath.Join(root, "w"))
+
+	dirs, err := partition_lib.FindPartitions(context.Background(), root)
+
+	g.Expect(err).To(BeNil())
+	g.Expect(dirs).To(Equal([]string{
+		x.AbsoluteDirOsPath,
+		xy.AbsoluteDirOsPath,
+		z.AbsoluteDirOsPath,
+	}))
+}
+
+func Test_FindNestedPartitionConflicts_reports_parent_manifest_covering_child_files(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	root := t.TempDir()
+
+	// Parent is hashed while child is a plain directory, so parent's
+	// manifest covers child's files
+	mkdirWithFile(filepath.Join(root, "child"))
+	parent := loadPartition(root)
+	hashAndSave(parent)
+
+	child := loadPartition(filepath.Join(root, "child"))
+	hashAndSave(child)
+
+	conflicts, err := partition_lib.FindNestedPartitionConflicts([]string{
+		parent.AbsoluteDirOsPath,
+		child.AbsoluteDirOsPath,
+	})
+
+	g.Expect(err).To(BeNil())
+	g.Expect(conflicts).To(ConsistOf(partition_lib.NestedPartitionConflict{
+		ParentDir:    parent.AbsoluteDirOsPath,
+		ChildDir:     child.AbsoluteDirOsPath,
+		CoveredFiles: 1,
+	}))
+}
@@ -0,0 +1,150 @@
+package partition_lib_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+	. "github.com/onsi/gomega"
+	gs "github.com/onsi/gomega/gstruct"
+)
+
+func Test_FindDuplicates_groups_files_within_and_across_partitions(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p1, p2 := setupDuplicatePartitions(t)
+
+	groups, err := partition_lib.FindDuplicates([]*partition_lib.Partition{p1, p2})
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(groups).To(HaveLen(4))
+
+	// "A" has 3 copies, so it is the most reclaimable
+	g.Expect(groups[0].Hash).To(Equal(partition_lib.HashString("A")))
+	g.Expect(groups[0].ReclaimableBytes()).To(Equal(int64(2)))
+
+	g.Expect(groups[0].Files).To(Equal([]partition_lib.DuplicateFile{
+		{Partition: p1, ManifestPath: "a"},
+		{Partition: p1, ManifestPath: "a-copy"},
+		{Partition: p2, ManifestPath: "a"},
+	}))
+}
+
+func Test_Dedupe_replaces_duplicates_with_hardlinks(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p1, p2 := setupDuplicatePartitions(t)
+
+	groups, err := partition_lib.FindDuplicates([]*partition_lib.Partition{p1, p2})
+
+	if err != nil {
+		panic(err)
+	}
+
+	outcomes, err := partition_lib.Dedupe(context.Background(), groups, partition_lib.DedupeHardlink).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(outcomes).To(HaveLen(5))
+	g.Expect(outcomes).To(HaveEach(BeAssignableToTypeOf(partition_lib.DuplicateReplaced{})))
+
+	kept, err := os.Stat(filepath.Join(p1.AbsoluteDirOsPath, "a"))
+
+	if err != nil {
+		panic(err)
+	}
+
+	dup, err := os.Stat(filepath.Join(p2.AbsoluteDirOsPath, "a"))
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(os.SameFile(kept, dup)).To(BeTrue())
+
+	// Manifests are updated, so nothing needs rehashing
+	for _, p := range []*partition_lib.Partition{p1, p2} {
+		changes, err := loadPartition(p.AbsoluteDirOsPath).Hash(context.Background()).Drain()
+
+		if err != nil {
+			panic(err)
+		}
+
+		g.Expect(changes).To(BeEmpty())
+	}
+}
+
+func Test_Dedupe_skips_files_that_do_not_match_stale_manifest(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p1, p2 := setupDuplicatePartitions(t)
+
+	groups, err := partition_lib.FindDuplicates([]*partition_lib.Partition{p1, p2})
+
+	if err != nil {
+		panic(err)
+	}
+
+	// Manifest of p2 still says "A"
+	modifyFileA(p2)
+
+	outcomes, err := partition_lib.Dedupe(context.Background(), groups[:1], partition_lib.DedupeDelete).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(outcomes).To(ConsistOf(
+		partition_lib.DuplicateDeleted{File: partition_lib.DuplicateFile{Partition: p1, ManifestPath: "a-copy"}},
+
+		gs.MatchFields(gs.IgnoreExtras, gs.Fields{
+			"File": Equal(partition_lib.DuplicateFile{Partition: p2, ManifestPath: "a"}),
+		}),
+	))
+
+	g.Expect(filepath.Join(p2.AbsoluteDirOsPath, "a")).To(BeAnExistingFile())
+	g.Expect(filepath.Join(p1.AbsoluteDirOsPath, "a-copy")).NotTo(BeAnExistingFile())
+
+	mismatches, err := loadPartition(p1.AbsoluteDirOsPath).Check(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(mismatches).To(BeEmpty())
+}
+
+// Two copies of the test partition. The first one also has a-copy
+func setupDuplicatePartitions(t *testing.T) (*partition_lib.Partition, *partition_lib.Partition) {
+	root := t.TempDir()
+	p1 := setupTestPartition(t)
+	p2 := setupTestPartition(t)
+
+	if err := os.WriteFile(filepath.Join(p1.AbsoluteDirOsPath, "a-copy"), ([]byte)("A"), 0o600); err != nil {
+		panic(err)
+	}
+
+	hashAndSave(p1)
+	hashAndSave(p2)
+
+	// Make order of partitions deterministic
+	p1Dir := filepath.Join(root, "1")
+	p2Dir := filepath.Join(root, "2")
+
+	if err := os.Rename(p1.AbsoluteDirOsPath, p1Dir); err != nil {
+		panic(err)
+	}
+
+	if err := os.Rename(p2.AbsoluteDirOsPath, p2Dir); err != nil {
+		panic(err)
+	}
+
+	return loadPartition(p1Dir), loadPartition(p2Dir)
+}
@@ -0,0 +1,60 @@
+package partition_lib_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+	. "github.com/onsi/gomega"
+)
+
+func Test_Export_writes_sha1sum_and_bsd_formats(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	hashAndSave(p)
+
+	sha1sum := bytes.Buffer{}
+
+	if err := p.Export(context.Background(), &sha1sum, partition_lib.ExportSha1sum); err != nil {
+		panic(err)
+	}
+
+	g.Expect(sha1sum.String()).To(Equal(
+		partition_lib.HashString("A") + "  a\n" +
+			partition_lib.HashString("B") + "  b\n" +
+			partition_lib.HashString("D") + "  c/d\n" +
+			partition_lib.HashString("E") + "  e\n",
+	))
+
+	bsd := bytes.Buffer{}
+
+	if err := p.Export(context.Background(), &bsd, partition_lib.ExportBsd); err != nil {
+		panic(err)
+	}
+
+	g.Expect(bsd.String()).To(HavePrefix("SHA1 (a) = " + partition_lib.HashString("A") + "\n"))
+}
+
+func Test_Export_sha256sum_fails_if_file_does_not_match_manifest(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	hashAndSave(p)
+
+	sha256sum := bytes.Buffer{}
+
+	if err := p.Export(context.Background(), &sha256sum, partition_lib.ExportSha256sum); err != nil {
+		panic(err)
+	}
+
+	g.Expect(sha256sum.String()).To(HavePrefix(fmt.Sprintf("%x  a\n", sha256.Sum256(([]byte)("A")))))
+
+	modifyFileA(p)
+
+	err := p.Export(context.Background(), &bytes.Buffer{}, partition_lib.ExportSha256sum)
+	g.Expect(err).To(MatchError(ContainSubstring("does not match")))
+}
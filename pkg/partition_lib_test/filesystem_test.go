@@ -0,0 +1,155 @@
+package partition_lib_test
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+	. "github.com/onsi/gomega"
+	gs "github.com/onsi/gomega/gstruct"
+)
+
+var memoryMtime = time.Unix(1_700_000_000, 0)
+
+func Test_MemoryFileSystem_is_valid_fs(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	files := setupMemoryFileSystem()
+
+	g.Expect(fstest.TestFS(files, "a", "b", "c/d", "e")).To(Succeed())
+}
+
+func Test_Hash_and_Check_work_on_MemoryFileSystem(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	files := setupMemoryFileSystem()
+	p := loadMemoryPartition(files)
+
+	changes, err := p.Hash(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(changes).To(HaveLen(4))
+
+	for _, c := range changes {
+		p.ApplyChange(c)
+	}
+
+	if err := p.Save(); err != nil {
+		panic(err)
+	}
+
+	// Same mtime, different contents: Hash() trusts mtime, Check() does not
+	writeMemoryFile(files, "a", "X", memoryMtime)
+
+	if err := files.Remove("c/d"); err != nil {
+		panic(err)
+	}
+
+	reloaded := loadMemoryPartition(files)
+	mismatches, err := reloaded.Check(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(mismatches).To(ConsistOf(
+		SatisfyAll(
+			BeAssignableToTypeOf(partition_lib.HashDoesNotMatch{}),
+
+			gs.MatchFields(gs.IgnoreExtras, gs.Fields{
+				"ManifestPath": Equal("a"),
+			}),
+		),
+		partition_lib.FileMissing{ManifestPath: "c/d"},
+	))
+
+	writeMemoryFile(files, "b", "B2", memoryMtime.Add(time.Second))
+
+	changes, err = reloaded.Hash(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(changes).To(ConsistOf(
+		BeAssignableToTypeOf(partition_lib.FileModified{}),
+		partition_lib.FileDeleted{ManifestPath: "c/d"},
+	))
+}
+
+func Test_Walk_skips_nested_partitions_on_MemoryFileSystem(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	files := setupMemoryFileSystem()
+	hashAndSave(loadMemoryPartition(files))
+
+	writeMemoryFile(files, "nested/.manifest.json", "{}", memoryMtime)
+	writeMemoryFile(files, "nested/file", "N", memoryMtime)
+
+	changes, err := loadMemoryPartition(files).Hash(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(changes).To(BeEmpty())
+}
+
+func Test_partition_in_read_only_FileSystem_cannot_be_saved(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	fsys := fstest.MapFS{
+		"a": &fstest.MapFile{Data: []byte("A"), ModTime: memoryMtime},
+	}
+
+	p, err := partition_lib.LoadPartitionFS(fsys, "mapfs")
+
+	if err != nil {
+		panic(err)
+	}
+
+	changes, err := p.Hash(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	for _, c := range changes {
+		p.ApplyChange(c)
+	}
+
+	g.Expect(p.Save()).To(MatchError(ContainSubstring("read-only")))
+}
+
+// In-memory counterpart of setupTestPartition()
+func setupMemoryFileSystem() *partition_lib.MemoryFileSystem {
+	files := partition_lib.NewMemoryFileSystem()
+
+	writeMemoryFile(files, "a", "A", memoryMtime)
+	writeMemoryFile(files, "b", "B", memoryMtime)
+	writeMemoryFile(files, "c/d", "D", memoryMtime)
+	writeMemoryFile(files, "e", "E", memoryMtime)
+
+	return files
+}
+
+func writeMemoryFile(files *partition_lib.MemoryFileSystem, name string, contents string, mtime time.Time) {
+	if err := files.WriteFile(name, []byte(contents), mtime); err != nil {
+		panic(err)
+	}
+}
+
+func loadMemoryPartition(files *partition_lib.MemoryFileSystem) *partition_lib.Partition {
+	p, err := partition_lib.LoadPartitionFromFileSystem(files, "memory")
+
+	if err != nil {
+		panic(err)
+	}
+
+	return p
+}
@@ -0,0 +1,176 @@
+package partition_lib_test
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+	. "github.com/onsi/gomega"
+)
+
+func Test_HashCache_is_shared_by_partitions_with_the_same_files(t *testing.T) {
+	skipIfHashCacheUnsupported(t)
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	other := loadPartition(t.TempDir())
+
+	// Hardlinks: the same files seen by both partitions
+	for _, name := range []string{"a", "b", "e"} {
+		err := os.Link(filepath.Join(p.AbsoluteDirOsPath, name), filepath.Join(other.AbsoluteDirOsPath, name))
+
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	cache := openHashCache(t.TempDir())
+
+	hashAndSaveWithCache(p, cache)
+	g.Expect(cache.Hits()).To(Equal(int64(0)))
+	g.Expect(cache.Misses()).To(Equal(int64(4)))
+
+	hashAndSaveWithCache(other, cache)
+	g.Expect(cache.Hits()).To(Equal(int64(3)))
+
+	mismatches, err := other.Check(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(mismatches).To(BeEmpty())
+}
+
+func Test_HashCache_misses_modified_files(t *testing.T) {
+	skipIfHashCacheUnsupported(t)
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	cacheDir := t.TempDir()
+
+	hashAndSaveWithCache(p, openHashCache(cacheDir))
+
+	// Same size and mtime, but ctime changes
+	aPath := filepath.Join(p.AbsoluteDirOsPath, "a")
+	info, err := os.Stat(aPath)
+
+	if err != nil {
+		panic(err)
+	}
+
+	if err := os.WriteFile(aPath, ([]byte)("Z"), 0o600); err != nil {
+		panic(err)
+	}
+
+	if err := os.Chtimes(aPath, info.ModTime(), info.ModTime()); err != nil {
+		panic(err)
+	}
+
+	// Forget the manifest, so files are not skipped by mtime
+	if err := os.Remove(filepath.Join(p.AbsoluteDirOsPath, ".manifest.json")); err != nil {
+		panic(err)
+	}
+
+	fresh := loadPartition(p.AbsoluteDirOsPath)
+	cache := openHashCache(cacheDir)
+
+	hashAndSaveWithCache(fresh, cache)
+
+	g.Expect(cache.Hits()).To(Equal(int64(3)))
+	g.Expect(cache.Misses()).To(Equal(int64(1)))
+
+	mismatches, err := fresh.Check(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(mismatches).To(BeEmpty())
+}
+
+func Test_HashCache_prunes_entries_not_used_for_long(t *testing.T) {
+	skipIfHashCacheUnsupported(t)
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	cacheDir := t.TempDir()
+
+	hashAndSaveWithCache(p, openHashCache(cacheDir))
+	g.Expect(countFiles(cacheDir)).To(Equal(5))
+
+	longAgo := time.Now().Add(-60 * 24 * time.Hour)
+
+	err := filepath.WalkDir(cacheDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		return os.Chtimes(path, longAgo, longAgo)
+	})
+
+	if err != nil {
+		panic(err)
+	}
+
+	openHashCache(cacheDir)
+
+	// Only the prune marker is left
+	g.Expect(countFiles(cacheDir)).To(Equal(1))
+}
+
+func skipIfHashCacheUnsupported(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("hash cache keys are supported only on Linux")
+	}
+}
+
+func openHashCache(dir string) *partition_lib.HashCache {
+	cache, err := partition_lib.NewHashCache(dir)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return cache
+}
+
+func hashAndSaveWithCache(partition *partition_lib.Partition, cache *partition_lib.HashCache) {
+	options := partition_lib.HashOptions{Cache: cache}
+	changes, err := partition.HashWithOptions(context.Background(), options).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	for _, c := range changes {
+		partition.ApplyChange(c)
+	}
+
+	if err := partition.Save(); err != nil {
+		panic(err)
+	}
+}
+
+func countFiles(dir string) int {
+	count := 0
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			count++
+		}
+
+		return err
+	})
+
+	if err != nil {
+		panic(err)
+	}
+
+	return count
+}
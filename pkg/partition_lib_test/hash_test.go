@@ -2,6 +2,8 @@ package partition_lib_test
 
 import (
 	"context"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/azerum/data-storage-suite/pkg/partition_lib"
@@ -167,3 +169,97 @@ func Test_Hash_if_called_right_after_changes_are_applied_and_saved_returns_no_ch
 
 	g.Expect(changes2).To(BeEmpty())
 }
+
+func Test_Hash_detects_modified_files_if_size_changes_but_mtime_does_not(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	hashAndSave(p)
+
+	path := filepath.Join(p.AbsoluteDirOsPath, "a")
+	info, err := os.Stat(path)
+
+	if err != nil {
+		panic(err)
+	}
+
+	if err := os.WriteFile(path, ([]byte)("A2"), 0o600); err != nil {
+		panic(err)
+	}
+
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		panic(err)
+	}
+
+	changes, err := p.Hash(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(changes).To(ConsistOf(
+		SatisfyAll(
+			BeAssignableToTypeOf(partition_lib.FileModified{}),
+
+			gs.MatchFields(gs.IgnoreExtras, gs.Fields{
+				"ManifestPath": Equal("a"),
+			}),
+		),
+	))
+}
+
+func Test_Hash_with_Only_walks_and_deletes_only_within_subtree(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	hashAndSave(p)
+
+	modifyFileA(p)
+	removeFileBAndDirectoryC(p)
+
+	if err := os.MkdirAll(filepath.Join(p.AbsoluteDirOsPath, "c"), 0o700); err != nil {
+		panic(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(p.AbsoluteDirOsPath, "c", "g"), ([]byte)("G"), 0o600); err != nil {
+		panic(err)
+	}
+
+	changes, err := p.HashWithOptions(context.Background(), partition_lib.HashOptions{Only: "c/"}).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	// Changes of a and b are outside of the subtree
+	g.Expect(changes).To(ConsistOf(
+		SatisfyAll(
+			BeAssignableToTypeOf(partition_lib.FileAdded{}),
+
+			gs.MatchFields(gs.IgnoreExtras, gs.Fields{
+				"ManifestPath": Equal("c/g"),
+			}),
+		),
+
+		partition_lib.FileDeleted{ManifestPath: "c/d"},
+	))
+}
+
+func Test_Hash_with_Only_deletes_entries_of_missing_subtree(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	hashAndSave(p)
+	removeFileBAndDirectoryC(p)
+
+	changes, err := p.HashWithOptions(context.Background(), partition_lib.HashOptions{Only: "c"}).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(changes).To(ConsistOf(partition_lib.FileDeleted{ManifestPath: "c/d"}))
+
+	_, err = p.HashWithOptions(context.Background(), partition_lib.HashOptions{Only: "../x"}).Drain()
+	g.Expect(err).To(HaveOccurred())
+}
@@ -101,3 +101,25 @@ func modifyFileEMtime(partition *partition_lib.Partition) {
 		panic(err)
 	}
 }
+
+func mkdirWithFile(dirPath string) string {
+	if err := os.MkdirAll(dirPath, 0o700); err != nil {
+		panic(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dirPath, "file"), ([]byte)(dirPath), 0o600); err != nil {
+		panic(err)
+	}
+
+	return dirPath
+}
+
+func loadPartition(dirPath string) *partition_lib.Partition {
+	partition, err := partition_lib.LoadPartition(dirPath)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return partition
+}
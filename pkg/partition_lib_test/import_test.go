@@ -0,0 +1,162 @@
+package partition_lib_test
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha512"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+	. "github.com/onsi/gomega"
+	gs "github.com/onsi/gomega/gstruct"
+)
+
+func Test_ImportChecksums_adds_only_verified_files_to_manifest(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+
+	sums := partition_lib.HashString("A") + "  a\n" +
+		partition_lib.HashString("not B") + " *b\n" +
+		partition_lib.HashString("X") + "  missing\n"
+
+	outcomes := importChecksums(p, "SHA1SUMS", sums)
+
+	g.Expect(outcomes).To(ConsistOf(
+		partition_lib.ImportVerified{ManifestPath: "a"},
+
+		SatisfyAll(
+			BeAssignableToTypeOf(partition_lib.ImportChecksumMismatch{}),
+
+			gs.MatchFields(gs.IgnoreExtras, gs.Fields{
+				"ManifestPath": Equal("b"),
+				"Algorithm":    Equal("sha1"),
+			}),
+		),
+
+		partition_lib.ImportFileMissing{ManifestPath: "missing"},
+	))
+
+	if err := p.Save(); err != nil {
+		panic(err)
+	}
+
+	// Imported entry is the same as the one Hash() would create
+	changes, err := loadPartition(p.AbsoluteDirOsPath).Hash(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(changes).To(ConsistOf(
+		gs.MatchFields(gs.IgnoreExtras, gs.Fields{"ManifestPath": Equal("b")}),
+		gs.MatchFields(gs.IgnoreExtras, gs.Fields{"ManifestPath": Equal("c/d")}),
+		gs.MatchFields(gs.IgnoreExtras, gs.Fields{"ManifestPath": Equal("e")}),
+		gs.MatchFields(gs.IgnoreExtras, gs.Fields{"ManifestPath": Equal("SHA1SUMS")}),
+	))
+}
+
+func Test_ImportChecksums_parses_md5_bsd_and_sfv_formats(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+
+	md5sum := fmt.Sprintf("%x  c/d\n", md5.Sum(([]byte)("D")))
+	bsd := "SHA1 (b) = " + partition_lib.HashString("B") + "\n"
+	sfv := fmt.Sprintf("; comment\ne %08X\n", crc32.ChecksumIEEE(([]byte)("E")))
+
+	g.Expect(importChecksums(p, "sums.md5", md5sum)).To(ConsistOf(partition_lib.ImportVerified{ManifestPath: "c/d"}))
+	g.Expect(importChecksums(p, "tags.sha1", bsd)).To(ConsistOf(partition_lib.ImportVerified{ManifestPath: "b"}))
+	g.Expect(importChecksums(p, "sums.sfv", sfv)).To(ConsistOf(partition_lib.ImportVerified{ManifestPath: "e"}))
+
+	// Second import does not touch existing entries
+	g.Expect(importChecksums(p, "sums.md5", md5sum)).To(ConsistOf(
+		BeAssignableToTypeOf(partition_lib.ImportSkipped{}),
+	))
+}
+
+func Test_ImportChecksums_parses_sha512_sums_and_bsd_tags(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+
+	sha512sum := fmt.Sprintf("%x  a\n", sha512.Sum512(([]byte)("A")))
+	bsd := fmt.Sprintf("SHA512 (b) = %x\n", sha512.Sum512(([]byte)("B")))
+
+	g.Expect(importChecksums(p, "SHA512SUMS", sha512sum)).To(ConsistOf(partition_lib.ImportVerified{ManifestPath: "a"}))
+	g.Expect(importChecksums(p, "tags.sha512", bsd)).To(ConsistOf(partition_lib.ImportVerified{ManifestPath: "b"}))
+}
+
+func Test_ImportChecksums_skips_files_Hash_does_not_see(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+
+	child := loadPartition(mkdirWithFile(filepath.Join(p.AbsoluteDirOsPath, "child")))
+	hashAndSave(child)
+
+	if err := os.WriteFile(filepath.Join(p.AbsoluteDirOsPath, "x.part-partial"), ([]byte)("X"), 0o600); err != nil {
+		panic(err)
+	}
+
+	childFile, err := os.ReadFile(filepath.Join(child.AbsoluteDirOsPath, "file"))
+
+	if err != nil {
+		panic(err)
+	}
+
+	sums := partition_lib.HashString("X") + "  x.part-partial\n" +
+		partition_lib.HashString(string(childFile)) + "  child/file\n" +
+		partition_lib.HashString("") + "  c\n" +
+		partition_lib.HashString("A") + "  a\n"
+
+	outcomes := importChecksums(p, "SHA1SUMS", sums)
+
+	g.Expect(outcomes).To(ConsistOf(
+		partition_lib.ImportSkipped{ManifestPath: "x.part-partial", Reason: "ignored file name"},
+		partition_lib.ImportSkipped{ManifestPath: "child/file", Reason: "in nested partition child"},
+		partition_lib.ImportSkipped{ManifestPath: "c", Reason: "is a directory"},
+		partition_lib.ImportVerified{ManifestPath: "a"},
+	))
+
+	changes, err := p.Hash(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(changes).NotTo(ContainElement(BeAssignableToTypeOf(partition_lib.FileDeleted{})))
+}
+
+func Test_ImportChecksums_resolves_paths_relative_to_checksum_file(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+
+	outcomes := importChecksums(p, "c/SHA1SUMS", partition_lib.HashString("D")+"  d\n")
+	g.Expect(outcomes).To(ConsistOf(partition_lib.ImportVerified{ManifestPath: "c/d"}))
+}
+
+func importChecksums(
+	partition *partition_lib.Partition,
+	checksumFileName string,
+	contents string,
+) []partition_lib.ImportOutcome {
+	checksumFilePath := filepath.Join(partition.AbsoluteDirOsPath, filepath.FromSlash(checksumFileName))
+
+	if err := os.WriteFile(checksumFilePath, ([]byte)(contents), 0o600); err != nil {
+		panic(err)
+	}
+
+	outcomes, err := partition.ImportChecksums(context.Background(), checksumFilePath).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	return outcomes
+}
@@ -0,0 +1,77 @@
+package partition_lib_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+	. "github.com/onsi/gomega"
+)
+
+func Test_HashIndex_finds_all_paths_with_the_hash(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+
+	if err := os.WriteFile(filepath.Join(p.AbsoluteDirOsPath, "c", "a-copy"), ([]byte)("A"), 0o600); err != nil {
+		panic(err)
+	}
+
+	hashAndSave(p)
+
+	index := partition_lib.NewHashIndex(t.TempDir())
+	paths, err := index.Lookup(p.AbsoluteDirOsPath, partition_lib.HashString("A"))
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(paths).To(Equal([]string{"a", "c/a-copy"}))
+
+	paths, err = index.Lookup(p.AbsoluteDirOsPath, partition_lib.HashString("missing"))
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(paths).To(BeEmpty())
+}
+
+func Test_HashIndex_is_rebuilt_when_manifest_changes(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	hashAndSave(p)
+
+	index := partition_lib.NewHashIndex(t.TempDir())
+
+	paths, err := index.Lookup(p.AbsoluteDirOsPath, partition_lib.HashString("F"))
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(paths).To(BeEmpty())
+
+	addFileF(p)
+	hashAndSave(p)
+
+	paths, err = index.Lookup(p.AbsoluteDirOsPath, partition_lib.HashString("F"))
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(paths).To(Equal([]string{"f"}))
+}
+
+func Test_HashIndex_rejects_invalid_hash(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	hashAndSave(p)
+
+	_, err := partition_lib.NewHashIndex(t.TempDir()).Lookup(p.AbsoluteDirOsPath, "not-a-hash")
+	g.Expect(err).To(HaveOccurred())
+}
@@ -0,0 +1,116 @@
+package partition_lib_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+	. "github.com/onsi/gomega"
+)
+
+func Test_CheckMtree_accepts_spec_written_by_WriteMtree(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	hashAndSave(p)
+
+	spec := writeMtree(p)
+
+	g.Expect(spec).To(ContainSubstring(fmt.Sprintf(
+		"./c/d type=file mode=0600 size=1 time=%d.000000000 sha256digest=%x\n",
+		mtimeOf(p, "c/d"),
+		sha256.Sum256(([]byte)("D")),
+	)))
+
+	mismatches, err := p.CheckMtree(context.Background(), strings.NewReader(spec)).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(mismatches).To(BeEmpty())
+}
+
+func Test_CheckMtree_reports_differences_from_spec(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	hashAndSave(p)
+
+	spec := writeMtree(p)
+
+	modifyFileA(p)
+	removeFileBAndDirectoryC(p)
+	addFileF(p)
+
+	if err := os.Chmod(filepath.Join(p.AbsoluteDirOsPath, "e"), 0o644); err != nil {
+		panic(err)
+	}
+
+	mismatches, err := p.CheckMtree(context.Background(), strings.NewReader(spec)).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(mismatches).To(ConsistOf(
+		partition_lib.SizeDoesNotMatch{ManifestPath: "a", ActualSize: 2, ExpectedSize: 1},
+		partition_lib.FileMissing{ManifestPath: "b"},
+		partition_lib.FileMissing{ManifestPath: "c/d"},
+		partition_lib.MetadataDoesNotMatch{ManifestPath: "e", Keyword: "mode", Actual: "0644", Expected: "0600"},
+		partition_lib.FileNotHashed{ManifestPath: "f"},
+	))
+}
+
+func Test_CheckMtree_parses_hierarchical_format(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+
+	spec := "#mtree\n" +
+		"/set type=file\n" +
+		". type=dir\n" +
+		"a size=1\n" +
+		"b sha1digest=" + partition_lib.HashString("B") + "\n" +
+		"c type=dir\n" +
+		"  d size=2\n" +
+		"..\n" +
+		"e \\\n" +
+		"  size=1\n"
+
+	mismatches, err := p.CheckMtree(context.Background(), strings.NewReader(spec)).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(mismatches).To(ConsistOf(
+		partition_lib.SizeDoesNotMatch{ManifestPath: "c/d", ActualSize: 1, ExpectedSize: 2},
+	))
+}
+
+func writeMtree(partition *partition_lib.Partition) string {
+	spec := bytes.Buffer{}
+
+	if err := partition.WriteMtree(context.Background(), &spec); err != nil {
+		panic(err)
+	}
+
+	return spec.String()
+}
+
+func mtimeOf(partition *partition_lib.Partition, manifestPath string) int64 {
+	info, err := os.Stat(filepath.Join(partition.AbsoluteDirOsPath, filepath.FromSlash(manifestPath)))
+
+	if err != nil {
+		panic(err)
+	}
+
+	return info.ModTime().Unix()
+}
@@ -0,0 +1,103 @@
+package partition_lib_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+	. "github.com/onsi/gomega"
+	gs "github.com/onsi/gomega/gstruct"
+)
+
+func Test_Hash_does_not_descend_into_nested_partitions(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+
+	child := loadPartition(mkdirWithFile(filepath.Join(p.AbsoluteDirOsPath, "child")))
+	hashAndSave(child)
+
+	changes, err := p.Hash(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(changes).To(HaveLen(4))
+	g.Expect(changes).ToNot(ContainElement(
+		gs.MatchFields(gs.IgnoreExtras, gs.Fields{
+			"ManifestPath": HavePrefix("child/"),
+		}),
+	))
+}
+
+func Test_Check_of_parent_is_not_affected_by_rehashing_nested_partition(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+
+	child := loadPartition(mkdirWithFile(filepath.Join(p.AbsoluteDirOsPath, "child")))
+	hashAndSave(child)
+	hashAndSave(p)
+
+	addFileF(child)
+	hashAndSave(child)
+
+	mismatches, err := p.Check(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(mismatches).To(BeEmpty())
+}
+
+func Test_Hash_reports_files_of_directory_that_became_nested_partition_as_deleted(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	hashAndSave(p)
+
+	child := loadPartition(filepath.Join(p.AbsoluteDirOsPath, "c"))
+	hashAndSave(child)
+
+	changes, err := p.Hash(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(changes).To(ConsistOf(
+		SatisfyAll(
+			BeAssignableToTypeOf(partition_lib.FileDeleted{}),
+
+			gs.MatchFields(gs.IgnoreExtras, gs.Fields{
+				"ManifestPath": Equal("c/d"),
+			}),
+		),
+	))
+}
+
+func Test_Hash_with_Only_rejects_paths_in_nested_partitions(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	hashAndSave(p)
+
+	child := loadPartition(mkdirWithFile(filepath.Join(p.AbsoluteDirOsPath, "child")))
+	hashAndSave(child)
+
+	for _, only := range []string{"child/file", "child/missing/x"} {
+		_, err := p.HashWithOptions(context.Background(), partition_lib.HashOptions{Only: only}).Drain()
+		g.Expect(err).To(MatchError(ContainSubstring("nested partition child")), only)
+	}
+
+	changes, err := p.HashWithOptions(context.Background(), partition_lib.HashOptions{Only: "child"}).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(changes).To(BeEmpty())
+}
@@ -0,0 +1,146 @@
+package partition_lib_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/azerum/data-storage-suite/pkg/archivefs"
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+	. "github.com/onsi/gomega"
+	gs "github.com/onsi/gomega/gstruct"
+)
+
+func Test_Pack_creates_archive_that_passes_check(t *testing.T) {
+	for _, extension := range []string{".tar", ".zip"} {
+		t.Run(extension, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+
+			p := setupTestPartition(t)
+			hashAndSave(p)
+
+			archivePath := filepath.Join(t.TempDir(), "packed"+extension)
+
+			if err := p.Pack(context.Background(), archivePath); err != nil {
+				panic(err)
+			}
+
+			packed := loadArchivePartition(t, archivePath)
+
+			mismatches, err := packed.Check(context.Background()).Drain()
+
+			if err != nil {
+				panic(err)
+			}
+
+			g.Expect(mismatches).To(BeEmpty())
+		})
+	}
+}
+
+func Test_Check_reports_corrupted_file_in_archive(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+
+	if err := os.WriteFile(filepath.Join(p.AbsoluteDirOsPath, "big"), bytes.Repeat([]byte("x"), 10_000), 0o600); err != nil {
+		panic(err)
+	}
+
+	hashAndSave(p)
+
+	archivePath := filepath.Join(t.TempDir(), "packed.tar")
+
+	if err := p.Pack(context.Background(), archivePath); err != nil {
+		panic(err)
+	}
+
+	archiveBytes, err := os.ReadFile(archivePath)
+
+	if err != nil {
+		panic(err)
+	}
+
+	offset := bytes.Index(archiveBytes, bytes.Repeat([]byte("x"), 10_000))
+	corruptByte(archivePath, int64(offset+5_000))
+
+	packed := loadArchivePartition(t, archivePath)
+
+	mismatches, err := packed.Check(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(mismatches).To(ConsistOf(
+		SatisfyAll(
+			BeAssignableToTypeOf(partition_lib.HashDoesNotMatch{}),
+
+			gs.MatchFields(gs.IgnoreExtras, gs.Fields{
+				"ManifestPath": Equal("big"),
+			}),
+		),
+	))
+}
+
+func Test_Pack_fails_if_file_does_not_match_manifest(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	hashAndSave(p)
+
+	modifyFileA(p)
+
+	archivePath := filepath.Join(t.TempDir(), "packed.tar")
+	err := p.Pack(context.Background(), archivePath)
+
+	g.Expect(err).To(MatchError(ContainSubstring("does not match")))
+
+	_, err = os.Stat(archivePath)
+	g.Expect(os.IsNotExist(err)).To(BeTrue())
+}
+
+func Test_partition_loaded_from_archive_can_be_hashed_but_not_saved(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	hashAndSave(p)
+
+	archivePath := filepath.Join(t.TempDir(), "packed.zip")
+
+	if err := p.Pack(context.Background(), archivePath); err != nil {
+		panic(err)
+	}
+
+	packed := loadArchivePartition(t, archivePath)
+
+	changes, err := packed.Hash(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(changes).To(BeEmpty())
+
+	g.Expect(packed.Save()).NotTo(Succeed())
+}
+
+func loadArchivePartition(t *testing.T, archivePath string) *partition_lib.Partition {
+	fsys, closer, err := archivefs.Open(archivePath)
+
+	if err != nil {
+		panic(err)
+	}
+
+	t.Cleanup(func() { _ = closer.Close() })
+
+	p, err := partition_lib.LoadPartitionFS(fsys, archivePath)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return p
+}
@@ -0,0 +1,379 @@
+package partition_lib_test
+
+import (
+	"context"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+	. "github.com/onsi/gomega"
+	gs "github.com/onsi/gomega/gstruct"
+)
+
+func Test_RepairFromParity_restores_missing_and_corrupted_files(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupProtectedPartition(t, 0.25)
+
+	if err := os.Remove(filepath.Join(p.AbsoluteDirOsPath, "small")); err != nil {
+		panic(err)
+	}
+
+	corruptByte(filepath.Join(p.AbsoluteDirOsPath, "medium"), 70_000)
+
+	outcomes := checkAndRepairFromParity(p)
+
+	g.Expect(outcomes).To(ConsistOf(
+		partition_lib.FileRepairedFromParity{ManifestPath: "small"},
+		partition_lib.FileRepairedFromParity{ManifestPath: "medium"},
+	))
+
+	mismatches, err := p.Check(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(mismatches).To(BeEmpty())
+
+	changes, err := p.Hash(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(changes).To(BeEmpty())
+}
+
+func Test_RepairFromParity_reports_files_with_too_many_damaged_blocks(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupProtectedPartition(t, 0.1)
+
+	if err := os.Remove(filepath.Join(p.AbsoluteDirOsPath, "large")); err != nil {
+		panic(err)
+	}
+
+	outcomes := checkAndRepairFromParity(p)
+
+	g.Expect(outcomes).To(ConsistOf(
+		SatisfyAll(
+			BeAssignableToTypeOf(partition_lib.FileNotRepaired{}),
+
+			gs.MatchFields(gs.IgnoreExtras, gs.Fields{
+				"ManifestPath": Equal("large"),
+			}),
+		),
+	))
+}
+
+func Test_RepairFromParity_does_not_overwrite_files_modified_since_last_hash(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupProtectedPartition(t, 0.25)
+	mediumPath := filepath.Join(p.AbsoluteDirOsPath, "medium")
+
+	// Wait, so mtime will be different even if this FS has 1s resolution
+	time.Sleep(time.Second)
+
+	if err := os.WriteFile(mediumPath, ([]byte)("edited"), 0o600); err != nil {
+		panic(err)
+	}
+
+	outcomes := checkAndRepairFromParity(p)
+
+	g.Expect(outcomes).To(ConsistOf(
+		partition_lib.FileNotRepaired{
+			ManifestPath: "medium",
+			Reason:       "modified since last hash; run hash first",
+
+			ModifiedSinceHash: true,
+		},
+	))
+
+	contents, err := os.ReadFile(mediumPath)
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(string(contents)).To(Equal("edited"))
+}
+
+func Test_RepairFromParityAndReplicas_restores_from_replicas_what_parity_cannot(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupProtectedPartition(t, 0.1)
+	replica := copyToReplica(t, p)
+
+	// Too large for 10% of parity, see the test above
+	if err := os.Remove(filepath.Join(p.AbsoluteDirOsPath, "large")); err != nil {
+		panic(err)
+	}
+
+	outcomes := checkAndRepairFromParityAndReplicas(p, replica)
+
+	g.Expect(outcomes).To(ConsistOf(
+		partition_lib.FileRepaired{
+			ManifestPath:        "large",
+			ReplicaDir:          replica.AbsoluteDirOsPath,
+			ReplicaManifestPath: "large",
+		},
+	))
+
+	mismatches, err := p.Check(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(mismatches).To(BeEmpty())
+}
+
+func Test_RepairFromParityAndReplicas_does_not_overwrite_files_modified_since_last_hash(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupProtectedPartition(t, 0.25)
+	replica := copyToReplica(t, p)
+	mediumPath := filepath.Join(p.AbsoluteDirOsPath, "medium")
+
+	// Wait, so mtime will be different even if this FS has 1s resolution
+	time.Sleep(time.Second)
+
+	if err := os.WriteFile(mediumPath, ([]byte)("edited"), 0o600); err != nil {
+		panic(err)
+	}
+
+	outcomes := checkAndRepairFromParityAndReplicas(p, replica)
+
+	g.Expect(outcomes).To(ConsistOf(
+		partition_lib.FileNotRepaired{
+			ManifestPath: "medium",
+			Reason:       "modified since last hash; run hash first",
+
+			ModifiedSinceHash: true,
+		},
+	))
+
+	contents, err := os.ReadFile(mediumPath)
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(string(contents)).To(Equal("edited"))
+}
+
+func Test_Check_reports_outdated_parity(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupProtectedPartition(t, 0.1)
+
+	addFileF(p)
+	hashAndSave(p)
+
+	mismatches, err := p.Check(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(mismatches).To(ConsistOf(partition_lib.ParityOutdated{}))
+}
+
+func Test_Check_reports_damaged_parity(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupProtectedPartition(t, 0.1)
+
+	corruptByte(filepath.Join(p.AbsoluteDirOsPath, ".parity.bin"), 0)
+
+	mismatches, err := p.Check(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(mismatches).To(ConsistOf(
+		BeAssignableToTypeOf(partition_lib.ParityDamaged{}),
+	))
+}
+
+func Test_parity_of_partition_in_FileSystem_is_checked_but_not_used_for_repair(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupProtectedPartition(t, 0.1)
+	files := partition_lib.NewMemoryFileSystem()
+
+	err := filepath.WalkDir(p.AbsoluteDirOsPath, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		contents, err := os.ReadFile(filePath)
+
+		if err != nil {
+			return err
+		}
+
+		info, err := d.Info()
+
+		if err != nil {
+			return err
+		}
+
+		return files.WriteFile(d.Name(), contents, info.ModTime())
+	})
+
+	if err != nil {
+		panic(err)
+	}
+
+	mismatches, err := loadMemoryPartition(files).Check(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(mismatches).To(BeEmpty())
+
+	corruptByte(filepath.Join(p.AbsoluteDirOsPath, ".parity.bin"), 0)
+	corrupted, err := os.ReadFile(filepath.Join(p.AbsoluteDirOsPath, ".parity.bin"))
+
+	if err != nil {
+		panic(err)
+	}
+
+	writeMemoryFile(files, ".parity.bin", string(corrupted), memoryMtime)
+
+	m := loadMemoryPartition(files)
+	mismatches, err = m.Check(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(mismatches).To(ConsistOf(BeAssignableToTypeOf(partition_lib.ParityDamaged{})))
+
+	g.Expect(m.Protect(context.Background(), 0.1)).To(MatchError(ContainSubstring("not an OS directory")))
+
+	_, err = m.RepairFromParity(context.Background(), mismatches).Drain()
+	g.Expect(err).To(MatchError(ContainSubstring("not an OS directory")))
+}
+
+// Partition with files spanning multiple parity blocks
+func setupProtectedPartition(t *testing.T, redundancy float64) *partition_lib.Partition {
+	dirPath := t.TempDir()
+	random := rand.New(rand.NewPCG(1, 2))
+
+	sizes := map[string]int{
+		"small":  1000,
+		"medium": 150_000,
+		"large":  700_000,
+	}
+
+	for name, size := range sizes {
+		contents := make([]byte, size)
+
+		for i := range contents {
+			contents[i] = byte(random.UintN(256))
+		}
+
+		if err := os.WriteFile(filepath.Join(dirPath, name), contents, 0o600); err != nil {
+			panic(err)
+		}
+	}
+
+	p := loadPartition(dirPath)
+	hashAndSave(p)
+
+	if err := p.Protect(context.Background(), redundancy); err != nil {
+		panic(err)
+	}
+
+	return p
+}
+
+// Flips the byte, keeping mtime, like bit rot does
+func corruptByte(filePath string, offset int64) {
+	info, err := os.Stat(filePath)
+
+	if err != nil {
+		panic(err)
+	}
+
+	defer func() {
+		if err := os.Chtimes(filePath, info.ModTime(), info.ModTime()); err != nil {
+			panic(err)
+		}
+	}()
+
+	file, err := os.OpenFile(filePath, os.O_RDWR, 0)
+
+	if err != nil {
+		panic(err)
+	}
+
+	defer file.Close()
+
+	b := make([]byte, 1)
+
+	if _, err := file.ReadAt(b, offset); err != nil {
+		panic(err)
+	}
+
+	b[0] ^= 0xff
+
+	if _, err := file.WriteAt(b, offset); err != nil {
+		panic(err)
+	}
+}
+
+func checkAndRepairFromParity(partition *partition_lib.Partition) []partition_lib.RepairOutcome {
+	mismatches, err := partition.Check(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	outcomes, err := partition.RepairFromParity(context.Background(), mismatches).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	return outcomes
+}
+
+func checkAndRepairFromParityAndReplicas(
+	partition *partition_lib.Partition,
+	replicas ...*partition_lib.Partition,
+) []partition_lib.RepairOutcome {
+	mismatches, err := partition.Check(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	outcomes, err := partition.RepairFromParityAndReplicas(context.Background(), mismatches, replicas).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	return outcomes
+}
+
+func copyToReplica(t *testing.T, partition *partition_lib.Partition) *partition_lib.Partition {
+	replicaDir := filepath.Join(t.TempDir(), "replica")
+
+	if _, err := partition.CopyTo(context.Background(), replicaDir).Drain(); err != nil {
+		panic(err)
+	}
+
+	return loadPartition(replicaDir)
+}
@@ -0,0 +1,159 @@
+package partition_lib_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+	. "github.com/onsi/gomega"
+	gs "github.com/onsi/gomega/gstruct"
+)
+
+func Test_Repair_restores_missing_and_corrupted_files_from_replica(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	hashAndSave(p)
+
+	replica := setupTestPartition(t)
+	hashAndSave(replica)
+
+	corruptByte(filepath.Join(p.AbsoluteDirOsPath, "a"), 0)
+	removeFileBAndDirectoryC(p)
+
+	outcomes := checkAndRepair(p, replica)
+
+	g.Expect(outcomes).To(ConsistOf(
+		partition_lib.FileRepaired{ManifestPath: "a", ReplicaDir: replica.AbsoluteDirOsPath, ReplicaManifestPath: "a"},
+		partition_lib.FileRepaired{ManifestPath: "b", ReplicaDir: replica.AbsoluteDirOsPath, ReplicaManifestPath: "b"},
+		partition_lib.FileRepaired{ManifestPath: "c/d", ReplicaDir: replica.AbsoluteDirOsPath, ReplicaManifestPath: "c/d"},
+	))
+
+	mismatches, err := p.Check(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(mismatches).To(BeEmpty())
+
+	// mtime is restored, so the manifest is still up to date
+	changes, err := p.Hash(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(changes).To(BeEmpty())
+}
+
+func Test_Repair_finds_copy_with_the_same_hash_at_different_path(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	hashAndSave(p)
+
+	replica := loadPartition(t.TempDir())
+
+	if err := os.WriteFile(filepath.Join(replica.AbsoluteDirOsPath, "renamed"), ([]byte)("B"), 0o600); err != nil {
+		panic(err)
+	}
+
+	hashAndSave(replica)
+
+	if err := os.Remove(filepath.Join(p.AbsoluteDirOsPath, "b")); err != nil {
+		panic(err)
+	}
+
+	outcomes := checkAndRepair(p, replica)
+
+	g.Expect(outcomes).To(ConsistOf(
+		partition_lib.FileRepaired{ManifestPath: "b", ReplicaDir: replica.AbsoluteDirOsPath, ReplicaManifestPath: "renamed"},
+	))
+}
+
+func Test_Repair_does_not_install_copy_that_is_corrupted_in_replica(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	hashAndSave(p)
+
+	replica := setupTestPartition(t)
+	hashAndSave(replica)
+
+	corruptByte(filepath.Join(p.AbsoluteDirOsPath, "a"), 0)
+	modifyFileA(replica)
+
+	outcomes := checkAndRepair(p, replica)
+
+	g.Expect(outcomes).To(ConsistOf(
+		SatisfyAll(
+			BeAssignableToTypeOf(partition_lib.FileNotRepaired{}),
+
+			gs.MatchFields(gs.IgnoreExtras, gs.Fields{
+				"ManifestPath": Equal("a"),
+				"Reason":       ContainSubstring("does not match"),
+			}),
+		),
+	))
+
+	contents, err := os.ReadFile(filepath.Join(p.AbsoluteDirOsPath, "a"))
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(contents).To(Equal([]byte{'A' ^ 0xff}))
+}
+
+func Test_Repair_does_not_overwrite_files_modified_since_last_hash(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	hashAndSave(p)
+
+	replica := setupTestPartition(t)
+	hashAndSave(replica)
+
+	modifyFileA(p)
+
+	outcomes := checkAndRepair(p, replica)
+
+	g.Expect(outcomes).To(ConsistOf(
+		partition_lib.FileNotRepaired{
+			ManifestPath: "a",
+			Reason:       "modified since last hash; run hash first",
+
+			ModifiedSinceHash: true,
+		},
+	))
+
+	contents, err := os.ReadFile(filepath.Join(p.AbsoluteDirOsPath, "a"))
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(string(contents)).To(Equal("A2"))
+}
+
+func checkAndRepair(
+	partition *partition_lib.Partition,
+	replicas ...*partition_lib.Partition,
+) []partition_lib.RepairOutcome {
+	mismatches, err := partition.Check(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	outcomes, err := partition.Repair(context.Background(), mismatches, replicas).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	return outcomes
+}
@@ -0,0 +1,105 @@
+package partition_lib_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+	. "github.com/onsi/gomega"
+	gs "github.com/onsi/gomega/gstruct"
+)
+
+func Test_CheckSample_reports_not_hashed_missing_and_resized_files_regardless_of_sample(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	hashAndSave(p)
+
+	addFileF(p)
+	removeFileBAndDirectoryC(p)
+	modifyFileA(p)
+
+	mismatches, stats := p.CheckSample(context.Background(), partition_lib.SampleOptions{Count: 0})
+	drained, err := mismatches.Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(drained).To(ConsistOf(
+		SatisfyAll(
+			BeAssignableToTypeOf(partition_lib.FileNotHashed{}),
+			gs.MatchFields(gs.IgnoreExtras, gs.Fields{"ManifestPath": Equal("f")}),
+		),
+
+		SatisfyAll(
+			BeAssignableToTypeOf(partition_lib.FileMissing{}),
+			gs.MatchFields(gs.IgnoreExtras, gs.Fields{"ManifestPath": Equal("b")}),
+		),
+
+		SatisfyAll(
+			BeAssignableToTypeOf(partition_lib.FileMissing{}),
+			gs.MatchFields(gs.IgnoreExtras, gs.Fields{"ManifestPath": Equal("c/d")}),
+		),
+
+		partition_lib.SizeDoesNotMatch{ManifestPath: "a", ActualSize: 2, ExpectedSize: 1},
+	))
+
+	g.Expect(stats.TotalFiles).To(Equal(1))
+	g.Expect(stats.SampledFiles).To(Equal(0))
+}
+
+func Test_CheckSample_with_the_same_seed_hashes_the_same_files(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	hashAndSave(p)
+
+	// Corrupt every file without changing its size, so each sampled file
+	// is reported
+	for _, name := range []string{"a", "b", "c/d", "e"} {
+		path := filepath.Join(p.AbsoluteDirOsPath, filepath.FromSlash(name))
+
+		if err := os.WriteFile(path, ([]byte)("Z"), 0o600); err != nil {
+			panic(err)
+		}
+	}
+
+	options := partition_lib.SampleOptions{Fraction: 0.5, Seed: 42}
+
+	first, stats := p.CheckSample(context.Background(), options)
+	firstDrained, err := first.Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	second, _ := p.CheckSample(context.Background(), options)
+	secondDrained, err := second.Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(stats.SampledFiles).To(Equal(2))
+	g.Expect(firstDrained).To(HaveLen(2))
+	g.Expect(secondDrained).To(Equal(firstDrained))
+}
+
+func Test_MaxCorruptedFiles_is_zero_when_every_file_is_sampled_and_grows_as_sample_shrinks(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	full := partition_lib.SampleStats{TotalFiles: 1000, SampledFiles: 1000}
+	g.Expect(full.MaxCorruptedFiles(0.95)).To(Equal(0))
+
+	none := partition_lib.SampleStats{TotalFiles: 1000, SampledFiles: 0}
+	g.Expect(none.MaxCorruptedFiles(0.95)).To(Equal(1000))
+
+	tenth := partition_lib.SampleStats{TotalFiles: 1000, SampledFiles: 100}
+	hundredth := partition_lib.SampleStats{TotalFiles: 1000, SampledFiles: 10}
+
+	g.Expect(tenth.MaxCorruptedFiles(0.95)).To(BeNumerically("<", hundredth.MaxCorruptedFiles(0.95)))
+	g.Expect(tenth.MaxCorruptedFiles(0.95)).To(BeNumerically("~", 29, 3))
+}
@@ -0,0 +1,96 @@
+package partition_lib_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+	. "github.com/onsi/gomega"
+	gs "github.com/onsi/gomega/gstruct"
+)
+
+func Test_Scrub_detects_the_same_mismatches_as_Check_for_manifest_entries(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	hashAndSave(p)
+
+	modifyFileA(p)
+	removeFileBAndDirectoryC(p)
+
+	mismatches, stats := p.Scrub(context.Background(), partition_lib.ScrubOptions{})
+	drained, err := mismatches.Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(drained).To(ConsistOf(
+		SatisfyAll(
+			BeAssignableToTypeOf(partition_lib.HashDoesNotMatch{}),
+			gs.MatchFields(gs.IgnoreExtras, gs.Fields{"ManifestPath": Equal("a")}),
+		),
+
+		SatisfyAll(
+			BeAssignableToTypeOf(partition_lib.FileMissing{}),
+			gs.MatchFields(gs.IgnoreExtras, gs.Fields{"ManifestPath": Equal("b")}),
+		),
+
+		SatisfyAll(
+			BeAssignableToTypeOf(partition_lib.FileMissing{}),
+			gs.MatchFields(gs.IgnoreExtras, gs.Fields{"ManifestPath": Equal("c/d")}),
+		),
+	))
+
+	g.Expect(stats.RemainingDueFiles).To(Equal(0))
+}
+
+func Test_Scrub_skips_files_verified_within_interval(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	hashAndSave(p)
+
+	options := partition_lib.ScrubOptions{Interval: time.Hour}
+
+	stats := scrubAndDrain(p, options)
+	g.Expect(stats.VerifiedFiles).To(Equal(4))
+
+	// Scrub state is persisted, so it survives reloading the partition
+	p = loadPartition(p.AbsoluteDirOsPath)
+
+	stats = scrubAndDrain(p, options)
+	g.Expect(stats.VerifiedFiles).To(Equal(0))
+}
+
+func Test_Scrub_stops_once_byte_budget_is_exhausted_and_continues_from_there_next_time(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	hashAndSave(p)
+
+	// Each file in test partition is 1 byte
+	options := partition_lib.ScrubOptions{Interval: time.Hour, MaxBytes: 3}
+
+	stats := scrubAndDrain(p, options)
+	g.Expect(stats.VerifiedFiles).To(Equal(3))
+	g.Expect(stats.RemainingDueFiles).To(Equal(1))
+
+	stats = scrubAndDrain(p, options)
+	g.Expect(stats.VerifiedFiles).To(Equal(1))
+	g.Expect(stats.RemainingDueFiles).To(Equal(0))
+}
+
+func scrubAndDrain(
+	partition *partition_lib.Partition,
+	options partition_lib.ScrubOptions,
+) *partition_lib.ScrubStats {
+	mismatches, stats := partition.Scrub(context.Background(), options)
+
+	if _, err := mismatches.Drain(); err != nil {
+		panic(err)
+	}
+
+	return stats
+}
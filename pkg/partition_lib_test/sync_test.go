@@ -0,0 +1,201 @@
+package partition_lib_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+	. "github.com/onsi/gomega"
+)
+
+func Test_PlanSync_finds_minimal_operations(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	src, dst := setupSyncedPartitions(t)
+	changeSyncSource(src)
+
+	ops, err := src.PlanSync(dst, partition_lib.SyncOptions{Delete: true})
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(ops).To(Equal([]partition_lib.SyncOperation{
+		partition_lib.SyncDelete{ManifestPath: "b"},
+		partition_lib.SyncMove{FromManifestPath: "c/d", ManifestPath: "moved/d"},
+		partition_lib.SyncModify{ManifestPath: "a"},
+		partition_lib.SyncAdd{ManifestPath: "f"},
+	}))
+
+	opsWithoutDelete, err := src.PlanSync(dst, partition_lib.SyncOptions{})
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(opsWithoutDelete).To(Equal([]partition_lib.SyncOperation{
+		partition_lib.SyncModify{ManifestPath: "a"},
+		partition_lib.SyncAdd{ManifestPath: "f"},
+		partition_lib.SyncAdd{ManifestPath: "moved/d"},
+	}))
+}
+
+func Test_ApplySync_makes_dst_identical_to_source(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	src, dst := setupSyncedPartitions(t)
+	changeSyncSource(src)
+
+	ops, err := src.PlanSync(dst, partition_lib.SyncOptions{Delete: true})
+
+	if err != nil {
+		panic(err)
+	}
+
+	outcomes, err := src.ApplySync(context.Background(), dst, ops).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(outcomes).To(HaveLen(len(ops)))
+	g.Expect(outcomes).To(HaveEach(BeAssignableToTypeOf(partition_lib.SyncApplied{})))
+
+	// Directory of the moved file is removed once empty
+	g.Expect(filepath.Join(dst.AbsoluteDirOsPath, "c")).NotTo(BeADirectory())
+
+	reloaded := loadPartition(dst.AbsoluteDirOsPath)
+
+	mismatches, err := reloaded.Check(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(mismatches).To(BeEmpty())
+
+	changes, err := reloaded.Hash(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(changes).To(BeEmpty())
+
+	ops, err = src.PlanSync(reloaded, partition_lib.SyncOptions{Delete: true})
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(ops).To(BeEmpty())
+}
+
+func Test_ApplySync_does_not_copy_source_file_that_fails_its_manifest_check(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	src, dst := setupSyncedPartitions(t)
+	addFileF(src)
+	hashAndSave(src)
+
+	ops, err := src.PlanSync(dst, partition_lib.SyncOptions{})
+
+	if err != nil {
+		panic(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(src.AbsoluteDirOsPath, "f"), ([]byte)("X"), 0o600); err != nil {
+		panic(err)
+	}
+
+	outcomes, err := src.ApplySync(context.Background(), dst, ops).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(outcomes).To(ConsistOf(BeAssignableToTypeOf(partition_lib.SyncFailed{})))
+	g.Expect(filepath.Join(dst.AbsoluteDirOsPath, "f")).NotTo(BeAnExistingFile())
+
+	mismatches, err := loadPartition(dst.AbsoluteDirOsPath).Check(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(mismatches).To(BeEmpty())
+}
+
+func Test_ApplySync_copies_moved_file_whose_dst_copy_was_tampered_with_and_deletes_the_old_path(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	src, dst := setupSyncedPartitions(t)
+	changeSyncSource(src)
+
+	ops, err := src.PlanSync(dst, partition_lib.SyncOptions{Delete: true})
+
+	if err != nil {
+		panic(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dst.AbsoluteDirOsPath, "c", "d"), ([]byte)("X"), 0o600); err != nil {
+		panic(err)
+	}
+
+	outcomes, err := src.ApplySync(context.Background(), dst, ops).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(outcomes).To(HaveEach(BeAssignableToTypeOf(partition_lib.SyncApplied{})))
+	g.Expect(filepath.Join(dst.AbsoluteDirOsPath, "c")).NotTo(BeADirectory())
+
+	reloaded := loadPartition(dst.AbsoluteDirOsPath)
+	mismatches, err := reloaded.Check(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(mismatches).To(BeEmpty())
+
+	ops, err = src.PlanSync(reloaded, partition_lib.SyncOptions{Delete: true})
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(ops).To(BeEmpty())
+}
+
+func setupSyncedPartitions(t *testing.T) (*partition_lib.Partition, *partition_lib.Partition) {
+	src := setupTestPartition(t)
+	hashAndSave(src)
+
+	dstDir := filepath.Join(t.TempDir(), "dst")
+
+	if _, err := src.CopyTo(context.Background(), dstDir).Drain(); err != nil {
+		panic(err)
+	}
+
+	return src, loadPartition(dstDir)
+}
+
+// Modifies a, removes b, moves c/d to moved/d, adds f
+func changeSyncSource(src *partition_lib.Partition) {
+	modifyFileA(src)
+
+	if err := os.Remove(filepath.Join(src.AbsoluteDirOsPath, "b")); err != nil {
+		panic(err)
+	}
+
+	if err := os.Rename(filepath.Join(src.AbsoluteDirOsPath, "c"), filepath.Join(src.AbsoluteDirOsPath, "moved")); err != nil {
+		panic(err)
+	}
+
+	addFileF(src)
+	hashAndSave(src)
+}
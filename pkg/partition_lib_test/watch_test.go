@@ -0,0 +1,246 @@
+package partition_lib_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+	. "github.com/onsi/gomega"
+)
+
+var testWatchOptions = partition_lib.WatchOptions{
+	Debounce:           50 * time.Millisecond,
+	CheckpointInterval: time.Minute,
+}
+
+type runningWatch struct {
+	mutex   sync.Mutex
+	changes []partition_lib.ManifestChange
+	cancel  context.CancelFunc
+	done    chan error
+}
+
+func startWatch(partition *partition_lib.Partition) *runningWatch {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	w := &runningWatch{
+		cancel: cancel,
+		done:   make(chan error, 1),
+	}
+
+	changes := partition.Watch(ctx, testWatchOptions)
+
+	go func() {
+		for c := range changes.Channel {
+			w.mutex.Lock()
+			w.changes = append(w.changes, c)
+			w.mutex.Unlock()
+		}
+
+		w.done <- changes.Err
+	}()
+
+	return w
+}
+
+// Adds file f before starting, and waits until the startup reconciliation
+// reports it. Watches are added before that, so changes made after this
+// returns come as events
+func startWatchAndWaitForStartup(g *WithT, partition *partition_lib.Partition) *runningWatch {
+	addFileF(partition)
+	w := startWatch(partition)
+
+	g.Eventually(w.changedPaths).Should(ContainElement("+f"))
+	return w
+}
+
+func (w *runningWatch) changedPaths() []string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	paths := make([]string, 0, len(w.changes))
+
+	for _, c := range w.changes {
+		switch c := c.(type) {
+		case partition_lib.FileAdded:
+			paths = append(paths, "+"+c.ManifestPath)
+
+		case partition_lib.FileModified:
+			paths = append(paths, "*"+c.ManifestPath)
+
+		case partition_lib.FileDeleted:
+			paths = append(paths, "-"+c.ManifestPath)
+
+		case partition_lib.SpuriousMtimeChange:
+			paths = append(paths, "~"+c.ManifestPath)
+		}
+	}
+
+	return paths
+}
+
+func (w *runningWatch) stop() error {
+	w.cancel()
+	return <-w.done
+}
+
+// Manifest saved on disk matches the partition
+func savedManifestIsCurrent(partition *partition_lib.Partition) bool {
+	reloaded := loadPartition(partition.AbsoluteDirOsPath)
+
+	mismatches, err := reloaded.Check(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	return len(mismatches) == 0
+}
+
+func Test_Watch_keeps_the_manifest_current(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	partition := setupTestPartition(t)
+	hashAndSave(partition)
+
+	w := startWatch(partition)
+
+	addFileF(partition)
+	modifyFileA(partition)
+	removeFileBAndDirectoryC(partition)
+
+	g.Eventually(w.changedPaths).Should(ConsistOf("+f", "*a", "-b", "-c/d"))
+	g.Eventually(func() bool { return savedManifestIsCurrent(partition) }).Should(BeTrue())
+
+	g.Expect(w.stop()).To(Succeed())
+}
+
+func Test_Watch_hashes_files_in_new_directories(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	partition := setupTestPartition(t)
+	hashAndSave(partition)
+
+	w := startWatch(partition)
+
+	dir := filepath.Join(partition.AbsoluteDirOsPath, "new", "nested")
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		panic(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "g"), ([]byte)("G"), 0o600); err != nil {
+		panic(err)
+	}
+
+	g.Eventually(w.changedPaths).Should(ConsistOf("+new/nested/g"))
+
+	// Directory is watched, so later changes in it are noticed too
+	if err := os.WriteFile(filepath.Join(dir, "h"), ([]byte)("H"), 0o600); err != nil {
+		panic(err)
+	}
+
+	g.Eventually(w.changedPaths).Should(ConsistOf("+new/nested/g", "+new/nested/h"))
+	g.Expect(w.stop()).To(Succeed())
+	g.Expect(savedManifestIsCurrent(partition)).To(BeTrue())
+}
+
+func Test_Watch_follows_renamed_directories(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	partition := setupTestPartition(t)
+	subDir := filepath.Join(partition.AbsoluteDirOsPath, "c", "sub")
+
+	if err := os.Mkdir(subDir, 0o700); err != nil {
+		panic(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(subDir, "s"), ([]byte)("S"), 0o600); err != nil {
+		panic(err)
+	}
+
+	hashAndSave(partition)
+
+	w := startWatchAndWaitForStartup(g, partition)
+
+	movedDir := filepath.Join(partition.AbsoluteDirOsPath, "moved")
+
+	if err := os.Rename(filepath.Join(partition.AbsoluteDirOsPath, "c"), movedDir); err != nil {
+		panic(err)
+	}
+
+	g.Eventually(w.changedPaths).Should(ConsistOf("+f", "-c/d", "-c/sub/s", "+moved/d", "+moved/sub/s"))
+
+	// Events of sub-directory of the moved one come under the new path
+	if err := os.WriteFile(filepath.Join(movedDir, "sub", "t"), ([]byte)("T"), 0o600); err != nil {
+		panic(err)
+	}
+
+	g.Eventually(w.changedPaths).Should(ContainElement("+moved/sub/t"))
+	g.Eventually(func() bool { return savedManifestIsCurrent(partition) }).Should(BeTrue())
+	g.Expect(w.stop()).To(Succeed())
+}
+
+func Test_Watch_picks_up_changes_made_before_it_started(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	partition := setupTestPartition(t)
+	hashAndSave(partition)
+
+	addFileF(partition)
+	modifyFileA(partition)
+
+	w := startWatch(partition)
+
+	g.Eventually(w.changedPaths).Should(ConsistOf("+f", "*a"))
+	g.Expect(w.stop()).To(Succeed())
+	g.Expect(savedManifestIsCurrent(partition)).To(BeTrue())
+}
+
+func Test_Watch_does_not_hash_nested_partitions(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	partition := setupTestPartition(t)
+	hashAndSave(partition)
+
+	w := startWatchAndWaitForStartup(g, partition)
+
+	nestedDir := filepath.Join(partition.AbsoluteDirOsPath, "nested")
+
+	if err := os.Mkdir(nestedDir, 0o700); err != nil {
+		panic(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(nestedDir, ".manifest.json"), ([]byte)(`{"files":{}}`), 0o600); err != nil {
+		panic(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(nestedDir, "x"), ([]byte)("X"), 0o600); err != nil {
+		panic(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(partition.AbsoluteDirOsPath, "g"), ([]byte)("G"), 0o600); err != nil {
+		panic(err)
+	}
+
+	g.Eventually(w.changedPaths).Should(ConsistOf("+f", "+g"))
+	g.Consistently(w.changedPaths, 300*time.Millisecond).Should(ConsistOf("+f", "+g"))
+	g.Expect(w.stop()).To(Succeed())
+}
+
+func Test_Watch_fails_for_read_only_partitions(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	partition, err := partition_lib.LoadPartitionFS(os.DirFS(t.TempDir()), "fs")
+
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = partition.Watch(context.Background(), testWatchOptions).Drain()
+	g.Expect(err).To(HaveOccurred())
+}
@@ -0,0 +1,203 @@
+//go:build linux
+
+package partition_lib_test
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+	. "github.com/onsi/gomega"
+	gs "github.com/onsi/gomega/gstruct"
+	"golang.org/x/sys/unix"
+)
+
+const hashXattrName = "user.part.hash"
+
+// SHA-1 of "A"
+const hashOfA = "6dcd4ce23d88e2ee9568ba546c007c63d9131c1b"
+
+func Test_Hash_with_Xattr_writes_hash_into_xattr(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	skipIfXattrsUnsupported(t, p.AbsoluteDirOsPath)
+
+	hashAndSaveWithXattr(p)
+
+	value := getHashXattr(filepath.Join(p.AbsoluteDirOsPath, "a"))
+	g.Expect(value).To(HavePrefix("sha1 " + hashOfA + " "))
+}
+
+func Test_Hash_with_Xattr_trusts_xattr_matching_mtime_and_size(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	skipIfXattrsUnsupported(t, p.AbsoluteDirOsPath)
+
+	// Xattr claims another hash. If it is trusted, the file is not read,
+	// and the manifest gets the claimed hash
+	aPath := filepath.Join(p.AbsoluteDirOsPath, "a")
+	info, err := os.Stat(aPath)
+
+	if err != nil {
+		panic(err)
+	}
+
+	claimed := strings.Repeat("1", 40)
+	setHashXattr(aPath, fmt.Sprintf("sha1 %s %d %d", claimed, info.ModTime().UnixNano(), info.Size()))
+
+	// Xattr of another version of the file is ignored
+	ePath := filepath.Join(p.AbsoluteDirOsPath, "e")
+	setHashXattr(ePath, fmt.Sprintf("sha1 %s %d %d", claimed, 1, 1))
+
+	hashAndSaveWithXattr(p)
+
+	mismatches, err := p.Check(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(mismatches).To(ConsistOf(
+		partition_lib.HashDoesNotMatch{
+			ManifestPath: "a",
+			ActualHash:   hashOfA,
+			ExpectedHash: claimed,
+		},
+	))
+}
+
+func Test_Hash_with_Xattr_does_not_fill_HashCache_with_entries_that_cannot_hit(t *testing.T) {
+	skipIfHashCacheUnsupported(t)
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	skipIfXattrsUnsupported(t, p.AbsoluteDirOsPath)
+
+	cacheDir := t.TempDir()
+	options := partition_lib.HashOptions{Cache: openHashCache(cacheDir), Xattr: true}
+
+	if _, err := p.HashWithOptions(context.Background(), options).Drain(); err != nil {
+		panic(err)
+	}
+
+	// Writing xattrs changed ctime of the files, which is part of the key
+	entries := make([]string, 0)
+
+	err := filepath.WalkDir(cacheDir, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && d.Name() != "last-prune" {
+			entries = append(entries, p)
+		}
+
+		return err
+	})
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(entries).To(BeEmpty())
+	g.Expect(getHashXattr(filepath.Join(p.AbsoluteDirOsPath, "a"))).To(HavePrefix("sha1 " + hashOfA + " "))
+}
+
+func Test_Check_reports_xattr_that_does_not_match_manifest(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	skipIfXattrsUnsupported(t, p.AbsoluteDirOsPath)
+
+	hashAndSaveWithXattr(p)
+
+	aPath := filepath.Join(p.AbsoluteDirOsPath, "a")
+	value := getHashXattr(aPath)
+	setHashXattr(aPath, strings.Replace(value, hashOfA, strings.Repeat("1", 40), 1))
+
+	mismatches, err := p.Check(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(mismatches).To(ConsistOf(
+		gs.MatchFields(gs.IgnoreExtras, gs.Fields{
+			"ManifestPath": Equal("a"),
+			"XattrHash":    Equal(strings.Repeat("1", 40)),
+			"ExpectedHash": Equal(hashOfA),
+		}),
+	))
+}
+
+func Test_Check_ignores_xattr_of_older_version_of_file(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	p := setupTestPartition(t)
+	skipIfXattrsUnsupported(t, p.AbsoluteDirOsPath)
+
+	hashAndSaveWithXattr(p)
+
+	// Hashed without --xattr, so xattr of "a" is left outdated
+	modifyFileA(p)
+	hashAndSave(p)
+
+	mismatches, err := p.Check(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(mismatches).To(BeEmpty())
+}
+
+func skipIfXattrsUnsupported(t *testing.T, dir string) {
+	probe := filepath.Join(dir, ".xattr-probe")
+
+	if err := os.WriteFile(probe, nil, 0o600); err != nil {
+		panic(err)
+	}
+
+	defer os.Remove(probe)
+
+	if err := unix.Setxattr(probe, "user.probe", []byte("1"), 0); err != nil {
+		t.Skipf("user xattrs are not supported in %s: %v", dir, err)
+	}
+}
+
+func hashAndSaveWithXattr(partition *partition_lib.Partition) {
+	options := partition_lib.HashOptions{Xattr: true}
+	changes, err := partition.HashWithOptions(context.Background(), options).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	for _, c := range changes {
+		partition.ApplyChange(c)
+	}
+
+	if err := partition.Save(); err != nil {
+		panic(err)
+	}
+}
+
+func getHashXattr(filePath string) string {
+	buf := make([]byte, 256)
+	n, err := unix.Getxattr(filePath, hashXattrName, buf)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return string(buf[:n])
+}
+
+func setHashXattr(filePath string, value string) {
+	if err := unix.Setxattr(filePath, hashXattrName, []byte(value), 0); err != nil {
+		panic(err)
+	}
+}
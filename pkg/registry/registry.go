@@ -0,0 +1,208 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/azerum/data-storage-suite/pkg/utils"
+)
+
+// Registry is a user-level list of known partitions, so commands can act
+// on all of them without spelling out every directory
+//
+// Registry is loaded and saved as a whole. Concurrent `part` processes
+// that both modify the registry may lose one of the updates
+type Registry struct {
+	filePath string
+
+	Partitions []*Entry `json:"partitions"`
+}
+
+type Entry struct {
+	Label string `json:"label"`
+
+	// Absolute OS path of the partition directory
+	Path string `json:"path"`
+
+	// Unix time of the last successful `part hash`. 0 if never hashed
+	LastHashTime int64 `json:"lastHashTime,omitempty"`
+
+	// Unix time of the last completed `part check`. 0 if never checked
+	LastCheckTime int64 `json:"lastCheckTime,omitempty"`
+
+	// Number of mismatches found by the last completed `part check`
+	LastCheckMismatches int `json:"lastCheckMismatches,omitempty"`
+}
+
+const registryPathEnvVar = "PART_REGISTRY"
+
+// Same as in partition_lib
+const manifestFileName = ".manifest.json"
+
+// DefaultPath returns path of the registry file: $PART_REGISTRY if set,
+// otherwise part/registry.json inside the user config dir
+func DefaultPath() (string, error) {
+	if p := os.Getenv(registryPathEnvVar); p != "" {
+		return p, nil
+	}
+
+	configDir, err := os.UserConfigDir()
+
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(configDir, "part", "registry.json"), nil
+}
+
+// Load reads registry from filePath. Missing file is treated as an
+// empty registry
+func Load(filePath string) (*Registry, error) {
+	registry := Registry{
+		filePath:   filePath,
+		Partitions: make([]*Entry, 0),
+	}
+
+	bytes, err := os.ReadFile(filePath)
+
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &registry, nil
+		}
+
+		return nil, err
+	}
+
+	if err := json.Unmarshal(bytes, &registry); err != nil {
+		fullErr := errors.Join(
+			fmt.Errorf("while parsing registry file %s", filePath),
+			err,
+		)
+
+		return nil, fullErr
+	}
+
+	return &registry, nil
+}
+
+func LoadDefault() (*Registry, error) {
+	filePath, err := DefaultPath()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return Load(filePath)
+}
+
+func (registry *Registry) Save() error {
+	bytes, err := json.MarshalIndent(registry, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(registry.filePath), 0o700); err != nil {
+		return err
+	}
+
+	return utils.Overwrite(registry.filePath, registry.filePath+".tmp", bytes)
+}
+
+// Add registers partition directory under the given label. Both label and
+// path must be unique within the registry
+func (registry *Registry) Add(label string, partitionDir string) (*Entry, error) {
+	absolutePath, err := filepath.Abs(partitionDir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range registry.Partitions {
+		if e.Label == label {
+			return nil, fmt.Errorf("label %s is already registered for %s", label, e.Path)
+		}
+
+		if e.Path == absolutePath {
+			return nil, fmt.Errorf("%s is already registered as %s", absolutePath, e.Label)
+		}
+	}
+
+	entry := Entry{
+		Label: label,
+		Path:  absolutePath,
+	}
+
+	registry.Partitions = append(registry.Partitions, &entry)
+	return &entry, nil
+}
+
+// Remove unregisters partition with given label or path. Returns an error
+// if there is no such partition
+func (registry *Registry) Remove(labelOrPath string) error {
+	entry := registry.Find(labelOrPath)
+
+	if entry == nil {
+		return fmt.Errorf("partition %s is not registered", labelOrPath)
+	}
+
+	kept := make([]*Entry, 0, len(registry.Partitions)-1)
+
+	for _, e := range registry.Partitions {
+		if e != entry {
+			kept = append(kept, e)
+		}
+	}
+
+	registry.Partitions = kept
+	return nil
+}
+
+// Find returns entry with given label or path, or nil if there is none.
+// Labels take precedence. Relative paths are resolved against the current
+// directory
+func (registry *Registry) Find(labelOrPath string) *Entry {
+	for _, e := range registry.Partitions {
+		if e.Label == labelOrPath {
+			return e
+		}
+	}
+
+	return registry.FindByPath(labelOrPath)
+}
+
+// FindByPath returns entry of the partition directory, or nil if it is not
+// registered. Unlike Find(), never matches labels, so results of a command
+// run on directory ./photos are not recorded onto partition labelled photos
+func (registry *Registry) FindByPath(partitionDir string) *Entry {
+	absolutePath, err := filepath.Abs(partitionDir)
+
+	if err != nil {
+		return nil
+	}
+
+	for _, e := range registry.Partitions {
+		if e.Path == absolutePath {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// IsMounted reports whether the partition directory currently has
+// a manifest. Directory alone is not enough: mount point of an unplugged
+// drive still exists, but is empty. Hence a partition that was never hashed
+// is not mounted either
+func (entry *Entry) IsMounted() bool {
+	info, err := os.Stat(filepath.Join(entry.Path, manifestFileName))
+
+	if err != nil {
+		return false
+	}
+
+	return info.Mode().IsRegular()
+}
@@ -0,0 +1,160 @@
+package registry_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/azerum/data-storage-suite/pkg/registry"
+	. "github.com/onsi/gomega"
+)
+
+func Test_Load_returns_empty_registry_if_file_does_not_exist(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	r, err := registry.Load(filepath.Join(t.TempDir(), "registry.json"))
+
+	g.Expect(err).To(BeNil())
+	g.Expect(r.Partitions).To(BeEmpty())
+}
+
+func Test_Save_then_Load_preserves_entries(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	filePath := filepath.Join(t.TempDir(), "nested", "registry.json")
+	partitionDir := t.TempDir()
+
+	r, err := registry.Load(filePath)
+
+	if err != nil {
+		panic(err)
+	}
+
+	entry, err := r.Add("photos", partitionDir)
+
+	if err != nil {
+		panic(err)
+	}
+
+	entry.LastCheckTime = 42
+	entry.LastCheckMismatches = 3
+
+	if err := r.Save(); err != nil {
+		panic(err)
+	}
+
+	r2, err := registry.Load(filePath)
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(r2.Partitions).To(HaveLen(1))
+	g.Expect(*r2.Partitions[0]).To(Equal(registry.Entry{
+		Label:               "photos",
+		Path:                partitionDir,
+		LastCheckTime:       42,
+		LastCheckMismatches: 3,
+	}))
+}
+
+func Test_Add_rejects_duplicate_labels_and_paths(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	r, err := registry.Load(filepath.Join(t.TempDir(), "registry.json"))
+
+	if err != nil {
+		panic(err)
+	}
+
+	dir1 := t.TempDir()
+	dir2 := t.TempDir()
+
+	if _, err := r.Add("a", dir1); err != nil {
+		panic(err)
+	}
+
+	_, err = r.Add("a", dir2)
+	g.Expect(err).To(MatchError(ContainSubstring("label a")))
+
+	_, err = r.Add("b", dir1)
+	g.Expect(err).To(MatchError(ContainSubstring(dir1)))
+}
+
+func Test_Remove_accepts_label_or_path(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	r, err := registry.Load(filepath.Join(t.TempDir(), "registry.json"))
+
+	if err != nil {
+		panic(err)
+	}
+
+	dir1 := t.TempDir()
+	dir2 := t.TempDir()
+
+	if _, err := r.Add("a", dir1); err != nil {
+		panic(err)
+	}
+
+	if _, err := r.Add("b", dir2); err != nil {
+		panic(err)
+	}
+
+	g.Expect(r.Remove("a")).To(Succeed())
+	g.Expect(r.Remove(dir2)).To(Succeed())
+	g.Expect(r.Partitions).To(BeEmpty())
+
+	g.Expect(r.Remove("a")).To(MatchError(ContainSubstring("not registered")))
+}
+
+func Test_FindByPath_does_not_match_labels(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	r, err := registry.Load(filepath.Join(t.TempDir(), "registry.json"))
+
+	if err != nil {
+		panic(err)
+	}
+
+	other, err := r.Add("photos", t.TempDir())
+
+	if err != nil {
+		panic(err)
+	}
+
+	// Directory named like the label of the other partition
+	photosDir := filepath.Join(t.TempDir(), "photos")
+	g.Expect(r.FindByPath(photosDir)).To(BeNil())
+
+	photos, err := r.Add("photos2", photosDir)
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(r.FindByPath(photosDir)).To(BeIdenticalTo(photos))
+	g.Expect(r.FindByPath(other.Path)).To(BeIdenticalTo(other))
+	g.Expect(r.Find("photos")).To(BeIdenticalTo(other))
+}
+
+func Test_IsMounted_is_false_for_missing_directories(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	entry := registry.Entry{Label: "x", Path: filepath.Join(t.TempDir(), "missing")}
+	g.Expect(entry.IsMounted()).To(BeFalse())
+}
+
+func Test_IsMounted_is_false_for_directories_without_manifest(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	// E.g. mount point of unplugged drive
+	entry := registry.Entry{Label: "x", Path: t.TempDir()}
+	g.Expect(entry.IsMounted()).To(BeFalse())
+
+	if err := os.WriteFile(filepath.Join(entry.Path, ".manifest.json"), ([]byte)("{}"), 0o600); err != nil {
+		panic(err)
+	}
+
+	g.Expect(entry.IsMounted()).To(BeTrue())
+}
@@ -0,0 +1,270 @@
+package s3fs
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Minimal client of S3 REST API: path-style requests signed with AWS
+// Signature Version 4. Only what FileSystem needs is implemented
+type client struct {
+	endpoint        *url.URL
+	region          string
+	accessKeyID     string
+	secretAccessKey string
+	httpClient      *http.Client
+
+	// Replaced in tests, to compare signatures with known ones
+	now func() time.Time
+}
+
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// Sends request for the object key of the bucket. Empty key addresses the
+// bucket itself. body may be nil
+func (c *client) do(
+	method string,
+	bucket string,
+	key string,
+	query url.Values,
+	header http.Header,
+	body []byte,
+) (*http.Response, error) {
+	u := *c.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + bucket
+
+	if key != "" {
+		u.Path += "/" + key
+	}
+
+	// Go leaves some reserved characters (e.g. +, =) unescaped in paths,
+	// while SigV4 canonical URI must have them escaped
+	u.RawPath = uriEncode(u.Path)
+
+	u.RawQuery = canonicalQuery(query)
+
+	request, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range header {
+		request.Header[name] = values
+	}
+
+	payloadHash := emptyPayloadHash
+
+	if body != nil {
+		sum := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(sum[:])
+		request.ContentLength = int64(len(body))
+	}
+
+	c.sign(request, payloadHash, c.now())
+
+	response, err := c.httpClient.Do(request)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode >= 300 {
+		defer response.Body.Close()
+		return nil, readError(response)
+	}
+
+	return response, nil
+}
+
+// Error returned by S3 API
+type responseError struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *responseError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("S3 responded with status %d", e.StatusCode)
+	}
+
+	return fmt.Sprintf("S3 responded with status %d: %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+func readError(response *http.Response) error {
+	e := responseError{StatusCode: response.StatusCode}
+
+	// HEAD responses have no body, and some errors are not XML
+	body, err := io.ReadAll(io.LimitReader(response.Body, 64*1024))
+
+	if err == nil && len(body) > 0 {
+		_ = xml.Unmarshal(body, &e)
+	}
+
+	return &e
+}
+
+// Adds Authorization header, see
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func (c *client) sign(request *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := []string{"host"}
+	headerValues := map[string]string{"host": request.URL.Host}
+
+	for name, values := range request.Header {
+		lower := strings.ToLower(name)
+
+		if strings.HasPrefix(lower, "x-amz-") || lower == "range" || lower == "content-md5" || lower == "content-type" {
+			signedHeaders = append(signedHeaders, lower)
+			headerValues[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+
+	slices.Sort(signedHeaders)
+
+	var canonicalHeaders strings.Builder
+
+	for _, name := range signedHeaders {
+		canonicalHeaders.WriteString(name + ":" + headerValues[name] + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		request.URL.RawQuery,
+		canonicalHeaders.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + c.region + "/s3/aws4_request"
+	canonicalRequestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalRequestHash[:])
+
+	key := hmacSha256([]byte("AWS4"+c.secretAccessKey), date)
+	key = hmacSha256(key, c.region)
+	key = hmacSha256(key, "s3")
+	key = hmacSha256(key, "aws4_request")
+
+	signature := hex.EncodeToString(hmacSha256(key, stringToSign))
+
+	request.Header.Set(
+		"Authorization",
+		fmt.Sprintf(
+			"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+			c.accessKeyID,
+			scope,
+			strings.Join(signedHeaders, ";"),
+			signature,
+		),
+	)
+}
+
+// Escapes everything except unreserved characters and /, as SigV4 requires
+func uriEncode(s string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		isUnreserved := 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '.' || c == '_' || c == '~' || c == '/'
+
+		if isUnreserved {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+
+	return h.Sum(nil)
+}
+
+// Sorted by key, with spaces encoded as %20, as SigV4 requires. url.Values
+// Encode() encodes spaces as +
+func canonicalQuery(query url.Values) string {
+	return strings.ReplaceAll(query.Encode(), "+", "%20")
+}
+
+type listObjectsResult struct {
+	IsTruncated           bool           `xml:"IsTruncated"`
+	NextContinuationToken string         `xml:"NextContinuationToken"`
+	Contents              []listedObject `xml:"Contents"`
+}
+
+type listedObject struct {
+	Key          string    `xml:"Key"`
+	LastModified time.Time `xml:"LastModified"`
+	ETag         string    `xml:"ETag"`
+	Size         int64     `xml:"Size"`
+}
+
+// Lists objects with the key prefix, following continuation tokens. If
+// maxKeys > 0, lists at most maxKeys objects
+func (c *client) listObjects(bucket string, prefix string, maxKeys int) ([]listedObject, error) {
+	objects := make([]listedObject, 0)
+	continuationToken := ""
+
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+
+		if maxKeys > 0 {
+			query.Set("max-keys", strconv.Itoa(maxKeys-len(objects)))
+		}
+
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+
+		response, err := c.do(http.MethodGet, bucket, "", query, nil, nil)
+
+		if err != nil {
+			return nil, err
+		}
+
+		var result listObjectsResult
+		err = xml.NewDecoder(response.Body).Decode(&result)
+		_ = response.Body.Close()
+
+		if err != nil {
+			return nil, err
+		}
+
+		objects = append(objects, result.Contents...)
+
+		if !result.IsTruncated || maxKeys > 0 && len(objects) >= maxKeys {
+			return objects, nil
+		}
+
+		if result.NextContinuationToken == "" {
+			return nil, fmt.Errorf("listing of %s/%s is truncated, but has no continuation token", bucket, prefix)
+		}
+
+		continuationToken = result.NextContinuationToken
+	}
+}
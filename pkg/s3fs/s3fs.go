@@ -0,0 +1,412 @@
+// Package s3fs stores partitions in S3-compatible object storage (AWS S3,
+// MinIO, etc.). A partition is a bucket plus key prefix. Objects under the
+// prefix are files of the partition, with "/" in keys separating
+// directories, and the manifest is stored as object `<prefix>/.manifest.json`
+package s3fs
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+)
+
+type Config struct {
+	// E.g. https://s3.eu-central-1.amazonaws.com or http://localhost:9000.
+	// Requests are path-style: <Endpoint>/<bucket>/<key>
+	Endpoint string
+
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+}
+
+// ConfigFromEnv reads config from the variables AWS tools use:
+// AWS_ENDPOINT_URL_S3 (or AWS_ENDPOINT_URL), AWS_REGION (defaults to
+// us-east-1), AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+func ConfigFromEnv() Config {
+	region := os.Getenv("AWS_REGION")
+
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_S3")
+
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+
+	return Config{
+		Endpoint:        endpoint,
+		Region:          region,
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+	}
+}
+
+// IsURL reports whether location is s3://bucket[/prefix] URL
+func IsURL(location string) bool {
+	return strings.HasPrefix(location, "s3://")
+}
+
+// ParseURL splits s3://bucket[/prefix] URL
+func ParseURL(location string) (bucket string, prefix string, err error) {
+	rest, found := strings.CutPrefix(location, "s3://")
+
+	if !found {
+		return "", "", fmt.Errorf("%s is not an s3:// URL", location)
+	}
+
+	bucket, prefix, _ = strings.Cut(rest, "/")
+
+	if bucket == "" {
+		return "", "", fmt.Errorf("%s has no bucket", location)
+	}
+
+	return bucket, strings.Trim(prefix, "/"), nil
+}
+
+// FileSystem is partition_lib.FileSystem of objects under the prefix of
+// the bucket
+//
+// Walking lists objects, so Hash() gets sizes and mtimes (LastModified)
+// without a request per file. Files that need hashing are read in full,
+// unless the object has a full-object SHA-1 checksum, which S3 verifies on
+// upload (see StoredSha1()). Check() always reads objects, so corruption
+// of stored data is detected
+type FileSystem struct {
+	client *client
+	bucket string
+
+	// Without leading & trailing /. Empty for the whole bucket
+	prefix string
+}
+
+var _ partition_lib.StoredSha1FileSystem = (*FileSystem)(nil)
+
+func New(config Config, bucket string, prefix string) (*FileSystem, error) {
+	endpoint, err := url.Parse(config.Endpoint)
+
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("invalid S3 endpoint %s", config.Endpoint), err)
+	}
+
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, fmt.Errorf("invalid S3 endpoint %s: must be http:// or https:// URL", config.Endpoint)
+	}
+
+	c := client{
+		endpoint:        endpoint,
+		region:          config.Region,
+		accessKeyID:     config.AccessKeyID,
+		secretAccessKey: config.SecretAccessKey,
+		httpClient:      http.DefaultClient,
+		now:             time.Now,
+	}
+
+	f := FileSystem{
+		client: &c,
+		bucket: bucket,
+		prefix: strings.Trim(prefix, "/"),
+	}
+
+	return &f, nil
+}
+
+// Object key of the file name. "." is the prefix itself
+func (f *FileSystem) key(name string) string {
+	if name == "." {
+		return f.prefix
+	}
+
+	if f.prefix == "" {
+		return name
+	}
+
+	return f.prefix + "/" + name
+}
+
+// Prefix of keys of objects in the directory name
+func (f *FileSystem) dirPrefix(name string) string {
+	key := f.key(name)
+
+	if key == "" {
+		return ""
+	}
+
+	return key + "/"
+}
+
+func (f *FileSystem) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	response, err := f.client.do(http.MethodGet, f.bucket, f.key(name), nil, nil, nil)
+
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+
+	info, err := objectInfoFromHeader(name, response.Header)
+
+	if err != nil {
+		_ = response.Body.Close()
+		return nil, pathError("open", name, err)
+	}
+
+	return &object{info: info, body: response.Body}, nil
+}
+
+func (f *FileSystem) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+
+	dirInfo := objectInfo{name: path.Base(name), isDir: true}
+
+	if name == "." {
+		return &dirInfo, nil
+	}
+
+	info, err := f.head(name, nil)
+
+	if err == nil {
+		return info, nil
+	}
+
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	// Directories exist as long as there are objects in them
+	objects, err := f.client.listObjects(f.bucket, f.dirPrefix(name), 1)
+
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+
+	if len(objects) == 0 {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return &dirInfo, nil
+}
+
+func (f *FileSystem) head(name string, header http.Header) (*objectInfo, error) {
+	response, err := f.client.do(http.MethodHead, f.bucket, f.key(name), nil, header, nil)
+
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+
+	_ = response.Body.Close()
+
+	info, err := objectInfoFromHeader(name, response.Header)
+
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+
+	return info, nil
+}
+
+// StoredSha1 returns SHA-1 of the object from its checksum, or "" if the
+// object has no full-object SHA-1 checksum. Such checksums are verified by
+// S3 on upload, so are safe to use instead of reading the object. Objects
+// uploaded in multiple parts have only checksums of their parts
+func (f *FileSystem) StoredSha1(name string) (string, error) {
+	info, err := f.head(name, http.Header{"X-Amz-Checksum-Mode": {"ENABLED"}})
+
+	if err != nil {
+		return "", err
+	}
+
+	if info.checksumSha1 == "" || strings.Contains(info.checksumSha1, "-") {
+		return "", nil
+	}
+
+	sum, err := base64.StdEncoding.DecodeString(info.checksumSha1)
+
+	if err != nil || len(sum) != sha1.Size {
+		return "", nil
+	}
+
+	return hex.EncodeToString(sum), nil
+}
+
+func (f *FileSystem) WalkDir(root string, fn fs.WalkDirFunc) error {
+	if !fs.ValidPath(root) {
+		return fn(root, nil, &fs.PathError{Op: "walk", Path: root, Err: fs.ErrInvalid})
+	}
+
+	objects, err := f.client.listObjects(f.bucket, f.dirPrefix(root), 0)
+
+	if err != nil {
+		return fn(root, nil, pathError("walk", root, err))
+	}
+
+	if len(objects) == 0 && root != "." {
+		// Maybe root is a single file
+		info, err := f.Stat(root)
+
+		if err != nil {
+			return fn(root, nil, err)
+		}
+
+		return ignoreSkip(fn(root, info.(*objectInfo), nil))
+	}
+
+	tree := newDirTree(root)
+
+	for _, o := range objects {
+		name, found := strings.CutPrefix(o.Key, f.dirPrefix("."))
+
+		// Keys that are not valid paths (e.g. `a//b`, or "directory
+		// markers" ending with /) cannot be files of a partition
+		if !found || !fs.ValidPath(name) {
+			continue
+		}
+
+		info := objectInfo{
+			name:    path.Base(name),
+			size:    o.Size,
+			modTime: o.LastModified,
+			etag:    o.ETag,
+		}
+
+		tree.add(name, &info)
+	}
+
+	return ignoreSkip(tree.walk(root, fn))
+}
+
+func ignoreSkip(err error) error {
+	if err == fs.SkipDir || err == fs.SkipAll {
+		return nil
+	}
+
+	return err
+}
+
+// PUT of an object replaces it atomically. Content-MD5 makes S3 reject
+// contents corrupted in transit, and SHA-1 checksum is stored for
+// StoredSha1()
+func (f *FileSystem) WriteFileAtomic(name string, write func(w io.Writer) error) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrInvalid}
+	}
+
+	var buffer bytes.Buffer
+
+	if err := write(&buffer); err != nil {
+		return err
+	}
+
+	md5Sum := md5.Sum(buffer.Bytes())
+	sha1Sum := sha1.Sum(buffer.Bytes())
+
+	header := http.Header{
+		"Content-Md5":         {base64.StdEncoding.EncodeToString(md5Sum[:])},
+		"X-Amz-Checksum-Sha1": {base64.StdEncoding.EncodeToString(sha1Sum[:])},
+	}
+
+	response, err := f.client.do(http.MethodPut, f.bucket, f.key(name), nil, header, buffer.Bytes())
+
+	if err != nil {
+		return pathError("write", name, err)
+	}
+
+	return response.Body.Close()
+}
+
+func pathError(op string, name string, err error) error {
+	var responseErr *responseError
+
+	if errors.As(err, &responseErr) && responseErr.StatusCode == http.StatusNotFound {
+		err = errors.Join(fs.ErrNotExist, err)
+	}
+
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+type objectInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	isDir   bool
+	etag    string
+
+	// Base64. Set only by HEAD with checksum mode enabled
+	checksumSha1 string
+}
+
+func objectInfoFromHeader(name string, header http.Header) (*objectInfo, error) {
+	size, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+
+	if err != nil {
+		return nil, errors.Join(errors.New("invalid Content-Length"), err)
+	}
+
+	modTime, err := http.ParseTime(header.Get("Last-Modified"))
+
+	if err != nil {
+		return nil, errors.Join(errors.New("invalid Last-Modified"), err)
+	}
+
+	info := objectInfo{
+		name:         path.Base(name),
+		size:         size,
+		modTime:      modTime,
+		etag:         header.Get("ETag"),
+		checksumSha1: header.Get("X-Amz-Checksum-Sha1"),
+	}
+
+	return &info, nil
+}
+
+func (i *objectInfo) Name() string               { return i.name }
+func (i *objectInfo) Size() int64                { return i.size }
+func (i *objectInfo) ModTime() time.Time         { return i.modTime }
+func (i *objectInfo) IsDir() bool                { return i.isDir }
+func (i *objectInfo) Type() fs.FileMode          { return i.Mode().Type() }
+func (i *objectInfo) Info() (fs.FileInfo, error) { return i, nil }
+
+// ETag of the object, "" for directories
+func (i *objectInfo) Sys() any { return i.etag }
+
+func (i *objectInfo) Mode() fs.FileMode {
+	if i.isDir {
+		return fs.ModeDir | 0o755
+	}
+
+	return 0o644
+}
+
+type object struct {
+	info *objectInfo
+	body io.ReadCloser
+}
+
+func (o *object) Stat() (fs.FileInfo, error) { return o.info, nil }
+func (o *object) Read(p []byte) (int, error) { return o.body.Read(p) }
+func (o *object) Close() error               { return o.body.Close() }
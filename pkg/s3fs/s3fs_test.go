@@ -0,0 +1,227 @@
+package s3fs_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/azerum/data-storage-suite/pkg/partition_lib"
+	"github.com/azerum/data-storage-suite/pkg/s3fs"
+	. "github.com/onsi/gomega"
+	gs "github.com/onsi/gomega/gstruct"
+)
+
+var objectMtime = time.Unix(1_700_000_000, 0)
+
+func Test_Hash_and_Check_partition_in_bucket(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	s := setupStandIn(t)
+	p := loadS3Partition(s, "bucket", "photos")
+
+	changes := hashAndSave(p)
+
+	// Objects outside the prefix are not part of the partition
+	g.Expect(addedPaths(changes)).To(ConsistOf("a.jpg", "2024/b.jpg", "2024/c.jpg", "2025/d/e.jpg"))
+
+	reloaded := loadS3Partition(s, "bucket", "photos")
+	mismatches, err := reloaded.Check(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(mismatches).To(BeEmpty())
+
+	changes, err = reloaded.Hash(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(changes).To(BeEmpty())
+}
+
+func Test_Check_detects_corruption_of_stored_objects(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	s := setupStandIn(t)
+	hashAndSave(loadS3Partition(s, "bucket", "photos"))
+
+	s.corrupt("bucket/photos/2024/b.jpg")
+
+	mismatches, err := loadS3Partition(s, "bucket", "photos").Check(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(mismatches).To(ConsistOf(
+		SatisfyAll(
+			BeAssignableToTypeOf(partition_lib.HashDoesNotMatch{}),
+
+			gs.MatchFields(gs.IgnoreExtras, gs.Fields{
+				"ManifestPath": Equal("2024/b.jpg"),
+			}),
+		),
+	))
+}
+
+func Test_Hash_uses_stored_sha1_checksums_instead_of_reading_objects(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	s := setupStandIn(t)
+	files := newFileSystem(s, "bucket", "copied")
+
+	// Upload through s3fs, so objects get SHA-1 checksums
+	for _, name := range []string{"a", "b"} {
+		if err := writeString(files, name, name); err != nil {
+			panic(err)
+		}
+	}
+
+	p, err := partition_lib.LoadPartitionFromFileSystem(files, "s3://bucket/copied")
+
+	if err != nil {
+		panic(err)
+	}
+
+	hashAndSave(p)
+
+	g.Expect(s.getCount("bucket/copied/a")).To(Equal(0))
+	g.Expect(s.getCount("bucket/copied/b")).To(Equal(0))
+
+	// Objects without checksums are read
+	hashAndSave(loadS3Partition(s, "bucket", "photos"))
+	g.Expect(s.getCount("bucket/photos/a.jpg")).To(Equal(1))
+
+	mismatches, err := p.Check(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(mismatches).To(BeEmpty())
+}
+
+func Test_Hash_skips_object_whose_key_is_also_a_directory(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	s := newStandIn(t)
+
+	s.put("bucket/p/x", "X", objectMtime)
+	s.put("bucket/p/x/y", "Y", objectMtime)
+	s.put("bucket/p/x-z", "Z", objectMtime)
+
+	changes := hashAndSave(loadS3Partition(s, "bucket", "p"))
+	g.Expect(addedPaths(changes)).To(ConsistOf("x/y", "x-z"))
+
+	mismatches, err := loadS3Partition(s, "bucket", "p").Check(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	g.Expect(mismatches).To(BeEmpty())
+}
+
+func Test_ParseURL_splits_bucket_and_prefix(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	bucket, prefix, err := s3fs.ParseURL("s3://bucket/some/prefix/")
+
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(bucket).To(Equal("bucket"))
+	g.Expect(prefix).To(Equal("some/prefix"))
+
+	_, prefix, err = s3fs.ParseURL("s3://bucket")
+
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(prefix).To(Equal(""))
+
+	_, _, err = s3fs.ParseURL("/local/dir")
+	g.Expect(err).To(HaveOccurred())
+}
+
+// Partition photos/ spanning several listing pages, next to an unrelated
+// object
+func setupStandIn(t *testing.T) *standIn {
+	s := newStandIn(t)
+
+	s.put("bucket/photos/a.jpg", "A", objectMtime)
+	s.put("bucket/photos/2024/b.jpg", "B", objectMtime)
+	s.put("bucket/photos/2024/c.jpg", "C", objectMtime)
+	s.put("bucket/photos/2025/d/e.jpg", "E", objectMtime)
+	s.put("bucket/photos-other", "O", objectMtime)
+	s.put("bucket/other", "O", objectMtime)
+
+	return s
+}
+
+func newFileSystem(s *standIn, bucket string, prefix string) *s3fs.FileSystem {
+	config := s3fs.Config{
+		Endpoint:        s.server.URL,
+		Region:          "us-east-1",
+		AccessKeyID:     testAccessKeyID,
+		SecretAccessKey: "test-secret-key",
+	}
+
+	files, err := s3fs.New(config, bucket, prefix)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return files
+}
+
+func loadS3Partition(s *standIn, bucket string, prefix string) *partition_lib.Partition {
+	p, err := partition_lib.LoadPartitionFromFileSystem(
+		newFileSystem(s, bucket, prefix),
+		"s3://"+bucket+"/"+prefix,
+	)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return p
+}
+
+func hashAndSave(p *partition_lib.Partition) []partition_lib.ManifestChange {
+	changes, err := p.Hash(context.Background()).Drain()
+
+	if err != nil {
+		panic(err)
+	}
+
+	for _, c := range changes {
+		p.ApplyChange(c)
+	}
+
+	if err := p.Save(); err != nil {
+		panic(err)
+	}
+
+	return changes
+}
+
+func writeString(files *s3fs.FileSystem, name string, contents string) error {
+	return files.WriteFileAtomic(name, func(w io.Writer) error {
+		_, err := w.Write([]byte(contents))
+		return err
+	})
+}
+
+func addedPaths(changes []partition_lib.ManifestChange) []string {
+	paths := make([]string, 0)
+
+	for _, c := range changes {
+		if added, ok := c.(partition_lib.FileAdded); ok {
+			paths = append(paths, added.ManifestPath)
+		}
+	}
+
+	return paths
+}
@@ -0,0 +1,228 @@
+package s3fs_test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// In-memory stand-in for S3 (or MinIO), implementing just enough of the
+// API for s3fs. Listing returns at most pageSize keys per page, to exercise
+// continuation tokens
+type standIn struct {
+	server   *httptest.Server
+	pageSize int
+
+	mutex   sync.Mutex
+	objects map[string]*standInObject
+
+	// Number of GET requests of objects (not listings) by key
+	gets map[string]int
+}
+
+type standInObject struct {
+	data    []byte
+	modTime time.Time
+
+	// Base64, "" if uploaded without checksum
+	checksumSha1 string
+}
+
+const testAccessKeyID = "test-access-key"
+
+func newStandIn(t *testing.T) *standIn {
+	s := standIn{
+		pageSize: 2,
+		objects:  make(map[string]*standInObject),
+		gets:     make(map[string]int),
+	}
+
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.server.Close)
+
+	return &s
+}
+
+// Stores object as a tool other than s3fs would, without checksum
+func (s *standIn) put(bucketAndKey string, contents string, modTime time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.objects[bucketAndKey] = &standInObject{data: []byte(contents), modTime: modTime}
+}
+
+// Flips a byte of stored data, keeping checksum and ETag as they were
+func (s *standIn) corrupt(bucketAndKey string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.objects[bucketAndKey].data = bytes.Clone(s.objects[bucketAndKey].data)
+	s.objects[bucketAndKey].data[0] ^= 0xff
+}
+
+func (s *standIn) getCount(bucketAndKey string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.gets[bucketAndKey]
+}
+
+func (s *standIn) handle(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	auth := r.Header.Get("Authorization")
+
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential="+testAccessKeyID+"/") {
+		writeError(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+
+	if key == "" && r.Method == http.MethodGet {
+		s.list(w, r, bucket)
+		return
+	}
+
+	name := bucket + "/" + key
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		o := s.objects[name]
+
+		if o == nil {
+			writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+
+		md5Sum := md5.Sum(o.data)
+
+		w.Header().Set("Content-Length", strconv.Itoa(len(o.data)))
+		w.Header().Set("Last-Modified", o.modTime.UTC().Format(http.TimeFormat))
+		w.Header().Set("ETag", `"`+hex.EncodeToString(md5Sum[:])+`"`)
+
+		if r.Header.Get("X-Amz-Checksum-Mode") == "ENABLED" && o.checksumSha1 != "" {
+			w.Header().Set("X-Amz-Checksum-Sha1", o.checksumSha1)
+		}
+
+		if r.Method == http.MethodGet {
+			s.gets[name]++
+			_, _ = w.Write(o.data)
+		}
+
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+
+		md5Sum := md5.Sum(data)
+		sha1Sum := sha1.Sum(data)
+		checksumSha1 := base64.StdEncoding.EncodeToString(sha1Sum[:])
+
+		if r.Header.Get("Content-Md5") != base64.StdEncoding.EncodeToString(md5Sum[:]) {
+			writeError(w, http.StatusBadRequest, "BadDigest")
+			return
+		}
+
+		if c := r.Header.Get("X-Amz-Checksum-Sha1"); c != "" && c != checksumSha1 {
+			writeError(w, http.StatusBadRequest, "BadDigest")
+			return
+		}
+
+		s.objects[name] = &standInObject{
+			data:         data,
+			modTime:      time.Now().Truncate(time.Second),
+			checksumSha1: checksumSha1,
+		}
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (s *standIn) list(w http.ResponseWriter, r *http.Request, bucket string) {
+	query := r.URL.Query()
+
+	if query.Get("list-type") != "2" {
+		writeError(w, http.StatusNotImplemented, "NotImplemented")
+		return
+	}
+
+	prefix := bucket + "/" + query.Get("prefix")
+	keys := make([]string, 0)
+
+	for name := range s.objects {
+		if strings.HasPrefix(name, prefix) {
+			keys = append(keys, name)
+		}
+	}
+
+	slices.Sort(keys)
+
+	start := 0
+
+	if token := query.Get("continuation-token"); token != "" {
+		start, _ = strconv.Atoi(token)
+	}
+
+	pageSize := s.pageSize
+
+	if maxKeys, err := strconv.Atoi(query.Get("max-keys")); err == nil {
+		pageSize = min(pageSize, maxKeys)
+	}
+
+	end := min(start+pageSize, len(keys))
+
+	var body strings.Builder
+	body.WriteString(`<?xml version="1.0" encoding="UTF-8"?><ListBucketResult>`)
+	fmt.Fprintf(&body, "<IsTruncated>%t</IsTruncated>", end < len(keys))
+
+	if end < len(keys) {
+		fmt.Fprintf(&body, "<NextContinuationToken>%d</NextContinuationToken>", end)
+	}
+
+	for _, name := range keys[start:end] {
+		o := s.objects[name]
+		md5Sum := md5.Sum(o.data)
+
+		body.WriteString("<Contents><Key>")
+		_ = xml.EscapeText(&body, []byte(strings.TrimPrefix(name, bucket+"/")))
+
+		fmt.Fprintf(
+			&body,
+			"</Key><LastModified>%s</LastModified><ETag>&quot;%x&quot;</ETag><Size>%d</Size></Contents>",
+			o.modTime.UTC().Format("2006-01-02T15:04:05.000Z"),
+			md5Sum,
+			len(o.data),
+		)
+	}
+
+	body.WriteString("</ListBucketResult>")
+
+	w.Header().Set("Content-Type", "application/xml")
+	_, _ = w.Write([]byte(body.String()))
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>%s</Code><Message>%s</Message></Error>`, code, code)
+}
@@ -0,0 +1,104 @@
+package s3fs
+
+import (
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Directory tree built from listed keys, walked the way fs.WalkDir() walks
+type dirTree struct {
+	root  string
+	nodes map[string]*treeNode
+}
+
+type treeNode struct {
+	info *objectInfo
+
+	// Names of children. nil for files
+	children []string
+}
+
+func newDirTree(root string) *dirTree {
+	rootNode := treeNode{info: &objectInfo{name: path.Base(root), isDir: true}, children: []string{}}
+
+	return &dirTree{
+		root:  root,
+		nodes: map[string]*treeNode{root: &rootNode},
+	}
+}
+
+// Adds the file and its parent directories up to the root
+//
+// Object storage may have both object a and objects under a/. Directory
+// wins, whatever order the keys are added in: object a is skipped, as it
+// cannot be a file of a partition
+func (t *dirTree) add(name string, info *objectInfo) {
+	if t.nodes[name] != nil {
+		return
+	}
+
+	t.nodes[name] = &treeNode{info: info}
+
+	for child := name; child != t.root; {
+		parent := path.Dir(child)
+		parentNode := t.nodes[parent]
+
+		if parentNode != nil {
+			// Object added earlier is in place of the directory. It is
+			// already a child of its own parent
+			if !parentNode.info.isDir {
+				parentNode.info = &objectInfo{name: path.Base(parent), isDir: true}
+				parentNode.children = []string{}
+			}
+
+			parentNode.children = append(parentNode.children, child)
+			return
+		}
+
+		parentNode = &treeNode{
+			info:     &objectInfo{name: path.Base(parent), isDir: true},
+			children: []string{child},
+		}
+
+		t.nodes[parent] = parentNode
+		child = parent
+	}
+}
+
+func (t *dirTree) walk(name string, fn fs.WalkDirFunc) error {
+	node := t.nodes[name]
+
+	if err := fn(name, node.info, nil); err != nil {
+		return err
+	}
+
+	if !node.info.isDir {
+		return nil
+	}
+
+	slices.SortFunc(node.children, func(a, b string) int {
+		return strings.Compare(path.Base(a), path.Base(b))
+	})
+
+	for _, child := range node.children {
+		err := t.walk(child, fn)
+
+		if err == fs.SkipDir {
+			if t.nodes[child].info.isDir {
+				continue
+			}
+
+			// SkipDir on a file skips the rest of its directory, as in
+			// fs.WalkDir()
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,32 @@
+package s3fs
+
+import (
+	"io/fs"
+	"path"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func Test_dirTree_prefers_directory_to_object_with_the_same_name(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	for _, names := range [][]string{{"a", "a/b"}, {"a/b", "a"}} {
+		tree := newDirTree(".")
+
+		for _, name := range names {
+			tree.add(name, &objectInfo{name: path.Base(name)})
+		}
+
+		walked := make([]string, 0)
+
+		err := tree.walk(".", func(name string, d fs.DirEntry, err error) error {
+			walked = append(walked, name)
+			return err
+		})
+
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(walked).To(Equal([]string{".", "a", "a/b"}), "%v", names)
+		g.Expect(tree.nodes["a"].info.isDir).To(BeTrue(), "%v", names)
+	}
+}
@@ -1,3 +1,3 @@
 #!/bin/bash
 
-go test ./pkg/partition_lib_test ./pkg/utils
+go test ./pkg/archivefs ./pkg/daemon ./pkg/partition_lib_test ./pkg/registry ./pkg/s3fs ./pkg/utils